@@ -15,6 +15,10 @@ A statement doesn't return a value e.g. let a = 5;
 type Node interface {
 	TokenLiteral() string
 	String() string
+	// Pos returns the position of the first character belonging to the node
+	Pos() token.Position
+	// End returns the position immediately after the node
+	End() token.Position
 }
 
 // Statement interface wraps the Node interface and also has a dummy StatementNode method to
@@ -34,6 +38,29 @@ type Expression interface {
 // in the AST we are trying to build
 type Program struct {
 	Statements []Statement
+	// Comments holds the comments of the program in source order, if the
+	// lexer was asked to keep them
+	Comments []*Comment
+}
+
+// CommentsOf returns the comments attached to node
+func (p *Program) CommentsOf(node Node) []*Comment {
+	var comments []*Comment
+	for _, c := range p.Comments {
+		if c.Node == node {
+			comments = append(comments, c)
+		}
+	}
+	return comments
+}
+
+// Comment is a comment attached to the innermost statement it is part of
+// or, failing that, the statement that follows it. Node is nil for the
+// comments after the last statement of the program.
+type Comment struct {
+	// COMMENT token
+	Token token.Token
+	Node  Node
 }
 
 func (p *Program) TokenLiteral() string {
@@ -43,6 +70,20 @@ func (p *Program) TokenLiteral() string {
 	return ""
 }
 
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return token.Position{}
+}
+
+func (p *Program) End() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[len(p.Statements)-1].End()
+	}
+	return token.Position{}
+}
+
 func (p *Program) String() string {
 	var out bytes.Buffer
 	for _, statement := range p.Statements {
@@ -71,6 +112,17 @@ func (l *LetStatement) TokenLiteral() string {
 	return l.Token.Literal
 }
 
+func (l *LetStatement) Pos() token.Position { return l.Token.Pos }
+func (l *LetStatement) End() token.Position {
+	if l.Value != nil {
+		return l.Value.End()
+	}
+	if l.Name != nil {
+		return l.Name.End()
+	}
+	return l.Token.End
+}
+
 func (l *LetStatement) String() string {
 	var out bytes.Buffer
 
@@ -103,6 +155,9 @@ func (i *Identifier) String() string {
 	return i.Value
 }
 
+func (i *Identifier) Pos() token.Position { return i.Token.Pos }
+func (i *Identifier) End() token.Position { return i.Token.End }
+
 // ReturnStatement represents the 'return' statement in the monkey language
 type ReturnStatement struct {
 	Token       token.Token
@@ -115,6 +170,14 @@ func (r *ReturnStatement) TokenLiteral() string {
 	return r.Token.Literal
 }
 
+func (r *ReturnStatement) Pos() token.Position { return r.Token.Pos }
+func (r *ReturnStatement) End() token.Position {
+	if r.ReturnValue != nil {
+		return r.ReturnValue.End()
+	}
+	return r.Token.End
+}
+
 func (r *ReturnStatement) String() string {
 	var out bytes.Buffer
 
@@ -142,6 +205,14 @@ func (e *ExpressionStatement) TokenLiteral() string {
 	return e.Token.Literal
 }
 
+func (e *ExpressionStatement) Pos() token.Position { return e.Token.Pos }
+func (e *ExpressionStatement) End() token.Position {
+	if e.Expression != nil {
+		return e.Expression.End()
+	}
+	return e.Token.End
+}
+
 func (e *ExpressionStatement) String() string {
 	if e.Expression != nil {
 		return e.Expression.String()
@@ -161,6 +232,19 @@ func (i *IntegerLiteral) TokenLiteral() string {
 func (i *IntegerLiteral) String() string {
 	return i.Token.Literal
 }
+func (i *IntegerLiteral) Pos() token.Position { return i.Token.Pos }
+func (i *IntegerLiteral) End() token.Position { return i.Token.End }
+
+type FloatLiteral struct {
+	Token token.Token
+	Value float64
+}
+
+func (f *FloatLiteral) ExpressionNode()      {}
+func (f *FloatLiteral) TokenLiteral() string { return f.Token.Literal }
+func (f *FloatLiteral) String() string       { return f.Token.Literal }
+func (f *FloatLiteral) Pos() token.Position  { return f.Token.Pos }
+func (f *FloatLiteral) End() token.Position  { return f.Token.End }
 
 type PrefixExpression struct {
 	Token    token.Token
@@ -172,6 +256,13 @@ func (pe *PrefixExpression) ExpressionNode() {}
 func (pe *PrefixExpression) TokenLiteral() string {
 	return pe.Token.Literal
 }
+func (pe *PrefixExpression) Pos() token.Position { return pe.Token.Pos }
+func (pe *PrefixExpression) End() token.Position {
+	if pe.Right != nil {
+		return pe.Right.End()
+	}
+	return pe.Token.End
+}
 func (pe *PrefixExpression) String() string {
 	var out bytes.Buffer
 
@@ -194,6 +285,18 @@ func (ie *InfixExpression) ExpressionNode() {}
 func (ie *InfixExpression) TokenLiteral() string {
 	return ie.Token.Literal
 }
+func (ie *InfixExpression) Pos() token.Position {
+	if ie.Left != nil {
+		return ie.Left.Pos()
+	}
+	return ie.Token.Pos
+}
+func (ie *InfixExpression) End() token.Position {
+	if ie.Right != nil {
+		return ie.Right.End()
+	}
+	return ie.Token.End
+}
 func (ie *InfixExpression) String() string {
 	var out bytes.Buffer
 
@@ -206,6 +309,38 @@ func (ie *InfixExpression) String() string {
 	return out.String()
 }
 
+// AssignExpression stores Value into Target, which is an Identifier or an
+// IndexExpression. Operator is "=" or a compound form such as "+=".
+// e.g. x = 5, arr[0] += 1
+type AssignExpression struct {
+	// the assignment operator token
+	Token    token.Token
+	Target   Expression
+	Operator string
+	Value    Expression
+}
+
+func (ae *AssignExpression) ExpressionNode()      {}
+func (ae *AssignExpression) TokenLiteral() string { return ae.Token.Literal }
+func (ae *AssignExpression) Pos() token.Position  { return ae.Target.Pos() }
+func (ae *AssignExpression) End() token.Position {
+	if ae.Value != nil {
+		return ae.Value.End()
+	}
+	return ae.Token.End
+}
+func (ae *AssignExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(ae.Target.String())
+	out.WriteString(" " + ae.Operator + " ")
+	out.WriteString(ae.Value.String())
+	out.WriteString(")")
+
+	return out.String()
+}
+
 type Boolean struct {
 	Token token.Token
 	Value bool
@@ -214,6 +349,8 @@ type Boolean struct {
 func (b *Boolean) ExpressionNode()      {}
 func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
 func (b *Boolean) String() string       { return b.Token.Literal }
+func (b *Boolean) Pos() token.Position  { return b.Token.Pos }
+func (b *Boolean) End() token.Position  { return b.Token.End }
 
 type IfExpression struct {
 	// if token
@@ -225,6 +362,16 @@ type IfExpression struct {
 
 func (ie *IfExpression) ExpressionNode()      {}
 func (ie *IfExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IfExpression) Pos() token.Position  { return ie.Token.Pos }
+func (ie *IfExpression) End() token.Position {
+	if ie.Alternative != nil {
+		return ie.Alternative.End()
+	}
+	if ie.Consequence != nil {
+		return ie.Consequence.End()
+	}
+	return ie.Token.End
+}
 func (ie *IfExpression) String() string {
 	var out bytes.Buffer
 
@@ -242,12 +389,25 @@ func (ie *IfExpression) String() string {
 }
 
 type BlockStatement struct {
+	// { token
 	Token      token.Token
 	Statements []Statement
+	// closing } token
+	Rbrace token.Token
 }
 
 func (bs *BlockStatement) StatementNode()       {}
 func (bs *BlockStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BlockStatement) Pos() token.Position  { return bs.Token.Pos }
+func (bs *BlockStatement) End() token.Position {
+	if bs.Rbrace.End.IsValid() {
+		return bs.Rbrace.End
+	}
+	if len(bs.Statements) > 0 {
+		return bs.Statements[len(bs.Statements)-1].End()
+	}
+	return bs.Token.End
+}
 func (bs *BlockStatement) String() string {
 	var out bytes.Buffer
 
@@ -258,14 +418,105 @@ func (bs *BlockStatement) String() string {
 	return out.String()
 }
 
+// WhileStatement repeats Body as long as Condition is truthy
+// e.g. while (x < 10) { puts(x) }
+type WhileStatement struct {
+	// while token
+	Token     token.Token
+	Condition Expression
+	Body      *BlockStatement
+}
+
+func (ws *WhileStatement) StatementNode()       {}
+func (ws *WhileStatement) TokenLiteral() string { return ws.Token.Literal }
+func (ws *WhileStatement) Pos() token.Position  { return ws.Token.Pos }
+func (ws *WhileStatement) End() token.Position {
+	if ws.Body != nil {
+		return ws.Body.End()
+	}
+	return ws.Token.End
+}
+func (ws *WhileStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("while")
+	out.WriteString(ws.Condition.String())
+	out.WriteString(" ")
+	out.WriteString(ws.Body.String())
+
+	return out.String()
+}
+
+// ForStatement runs Body once for every element of Iterable, bound to Variable
+// e.g. for (x in [1, 2, 3]) { puts(x) }
+type ForStatement struct {
+	// for token
+	Token    token.Token
+	Variable *Identifier
+	Iterable Expression
+	Body     *BlockStatement
+}
+
+func (fs *ForStatement) StatementNode()       {}
+func (fs *ForStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *ForStatement) Pos() token.Position  { return fs.Token.Pos }
+func (fs *ForStatement) End() token.Position {
+	if fs.Body != nil {
+		return fs.Body.End()
+	}
+	return fs.Token.End
+}
+func (fs *ForStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("for")
+	out.WriteString("(" + fs.Variable.String() + " in " + fs.Iterable.String() + ")")
+	out.WriteString(" ")
+	out.WriteString(fs.Body.String())
+
+	return out.String()
+}
+
+// BreakStatement leaves the innermost loop
+type BreakStatement struct {
+	Token token.Token
+}
+
+func (bs *BreakStatement) StatementNode()       {}
+func (bs *BreakStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BreakStatement) Pos() token.Position  { return bs.Token.Pos }
+func (bs *BreakStatement) End() token.Position  { return bs.Token.End }
+func (bs *BreakStatement) String() string       { return bs.Token.Literal + ";" }
+
+// ContinueStatement skips to the next iteration of the innermost loop
+type ContinueStatement struct {
+	Token token.Token
+}
+
+func (cs *ContinueStatement) StatementNode()       {}
+func (cs *ContinueStatement) TokenLiteral() string { return cs.Token.Literal }
+func (cs *ContinueStatement) Pos() token.Position  { return cs.Token.Pos }
+func (cs *ContinueStatement) End() token.Position  { return cs.Token.End }
+func (cs *ContinueStatement) String() string       { return cs.Token.Literal + ";" }
+
 type FunctionLiteral struct {
 	Token      token.Token
 	Parameters []*Identifier
 	Body       *BlockStatement
+	// Name is the identifier the function is bound to by a let statement,
+	// empty for anonymous functions
+	Name string
 }
 
 func (fl *FunctionLiteral) ExpressionNode()      {}
 func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FunctionLiteral) Pos() token.Position  { return fl.Token.Pos }
+func (fl *FunctionLiteral) End() token.Position {
+	if fl.Body != nil {
+		return fl.Body.End()
+	}
+	return fl.Token.End
+}
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 
@@ -292,15 +543,57 @@ type StringLiteral struct {
 func (sl *StringLiteral) ExpressionNode()      {}
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
 func (sl *StringLiteral) String() string       { return sl.Value }
+func (sl *StringLiteral) Pos() token.Position  { return sl.Token.Pos }
+func (sl *StringLiteral) End() token.Position  { return sl.Token.End }
+
+// InterpolatedString is a string with embedded expressions, e.g.
+// "Hello ${name}!". Strings holds the text around the expressions, so it
+// has one more element than Expressions.
+type InterpolatedString struct {
+	// TEMPLATE_HEAD token
+	Token       token.Token
+	Strings     []string
+	Expressions []Expression
+	// TEMPLATE_TAIL token
+	Tail token.Token
+}
+
+func (is *InterpolatedString) ExpressionNode()      {}
+func (is *InterpolatedString) TokenLiteral() string { return is.Token.Literal }
+func (is *InterpolatedString) Pos() token.Position  { return is.Token.Pos }
+func (is *InterpolatedString) End() token.Position  { return closingEnd(is.Tail, is.Token) }
+func (is *InterpolatedString) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(is.Strings[0])
+	for i, exp := range is.Expressions {
+		out.WriteString("${")
+		out.WriteString(exp.String())
+		out.WriteString("}")
+		out.WriteString(is.Strings[i+1])
+	}
+
+	return out.String()
+}
 
 type CallExpression struct {
+	// ( token
 	Token     token.Token
 	Function  Expression
 	Arguments []Expression
+	// closing ) token
+	Rparen token.Token
 }
 
 func (ce *CallExpression) ExpressionNode()      {}
 func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
+func (ce *CallExpression) Pos() token.Position {
+	if ce.Function != nil {
+		return ce.Function.Pos()
+	}
+	return ce.Token.Pos
+}
+func (ce *CallExpression) End() token.Position { return closingEnd(ce.Rparen, ce.Token) }
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 
@@ -321,10 +614,14 @@ func (ce *CallExpression) String() string {
 type ArrayLiteral struct {
 	Token    token.Token
 	Elements []Expression
+	// closing ] token
+	Rbracket token.Token
 }
 
 func (al *ArrayLiteral) ExpressionNode()      {}
 func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
+func (al *ArrayLiteral) Pos() token.Position  { return al.Token.Pos }
+func (al *ArrayLiteral) End() token.Position  { return closingEnd(al.Rbracket, al.Token) }
 func (al *ArrayLiteral) String() string {
 	var out bytes.Buffer
 
@@ -340,13 +637,23 @@ func (al *ArrayLiteral) String() string {
 }
 
 type IndexExpression struct {
+	// [ token
 	Token token.Token
 	Left  Expression
 	Index Expression
+	// closing ] token
+	Rbracket token.Token
 }
 
 func (ie *IndexExpression) ExpressionNode()      {}
 func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IndexExpression) Pos() token.Position {
+	if ie.Left != nil {
+		return ie.Left.Pos()
+	}
+	return ie.Token.Pos
+}
+func (ie *IndexExpression) End() token.Position { return closingEnd(ie.Rbracket, ie.Token) }
 func (ie *IndexExpression) String() string {
 	var out bytes.Buffer
 
@@ -360,23 +667,81 @@ func (ie *IndexExpression) String() string {
 	return out.String()
 }
 
+// SliceExpression is left[start:end], where start and end may be nil
+type SliceExpression struct {
+	// [ token
+	Token token.Token
+	Left  Expression
+	Start Expression
+	Stop  Expression
+	// closing ] token
+	Rbracket token.Token
+}
+
+func (se *SliceExpression) ExpressionNode()      {}
+func (se *SliceExpression) TokenLiteral() string { return se.Token.Literal }
+func (se *SliceExpression) Pos() token.Position {
+	if se.Left != nil {
+		return se.Left.Pos()
+	}
+	return se.Token.Pos
+}
+func (se *SliceExpression) End() token.Position { return closingEnd(se.Rbracket, se.Token) }
+func (se *SliceExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(se.Left.String())
+	out.WriteString("[")
+	if se.Start != nil {
+		out.WriteString(se.Start.String())
+	}
+	out.WriteString(":")
+	if se.Stop != nil {
+		out.WriteString(se.Stop.String())
+	}
+	out.WriteString("])")
+
+	return out.String()
+}
+
 type HashLiteral struct {
 	Token token.Token
-	Pairs map[Expression]Expression
+	// Pairs are in source order
+	Pairs []HashPair
+	// closing } token
+	Rbrace token.Token
+}
+
+// HashPair is a key: value entry of a hash literal
+type HashPair struct {
+	Key   Expression
+	Value Expression
 }
 
 func (h *HashLiteral) ExpressionNode()      {}
 func (h *HashLiteral) TokenLiteral() string { return h.Token.Literal }
+func (h *HashLiteral) Pos() token.Position  { return h.Token.Pos }
+func (h *HashLiteral) End() token.Position  { return closingEnd(h.Rbrace, h.Token) }
 func (h *HashLiteral) String() string {
 	var out bytes.Buffer
 
 	out.WriteString("{")
 	pairs := []string{}
-	for key, value := range h.Pairs {
-		pairs = append(pairs, key.String()+":"+value.String())
+	for _, pair := range h.Pairs {
+		pairs = append(pairs, pair.Key.String()+":"+pair.Value.String())
 	}
 
 	out.WriteString(strings.Join(pairs, ","))
 	out.WriteString("}")
 	return out.String()
 }
+
+// closingEnd returns the end of the closing delimiter of a node, falling back
+// to the opening one when the parser never reached the closing delimiter
+func closingEnd(closing token.Token, opening token.Token) token.Position {
+	if closing.End.IsValid() {
+		return closing.End
+	}
+	return opening.End
+}
@@ -2,6 +2,7 @@ package ast
 
 import (
 	"interpreters/token"
+	"strings"
 	"testing"
 )
 
@@ -27,3 +28,86 @@ func TestString(t *testing.T) {
 	}
 
 }
+
+func TestFprint(t *testing.T) {
+	program := &Program{
+		Statements: []Statement{
+			&LetStatement{
+				Token: token.Token{Type: token.LET, Literal: "let", Pos: token.Position{Line: 1, Column: 1}},
+				Name: &Identifier{
+					Token: token.Token{Type: token.IDENT, Literal: "x", Pos: token.Position{Line: 1, Column: 5}},
+					Value: "x",
+				},
+				Value: &InfixExpression{
+					Token:    token.Token{Type: token.MINUS, Literal: "-", Pos: token.Position{Line: 1, Column: 11}},
+					Left:     &IntegerLiteral{Token: token.Token{Pos: token.Position{Line: 1, Column: 9}}, Value: 1},
+					Operator: "-",
+					Right:    &Boolean{Token: token.Token{Pos: token.Position{Line: 1, Column: 13}}, Value: true},
+				},
+			},
+		},
+	}
+
+	expected := `Program 1:1
+  Statements[0]: LetStatement 1:1
+    Name: Identifier 1:5 Value="x"
+    Value: InfixExpression 1:9 Operator="-"
+      Left: IntegerLiteral 1:9 Value=1
+      Right: Boolean 1:13 Value=true
+`
+
+	var out strings.Builder
+	if err := Fprint(&out, program); err != nil {
+		t.Fatalf("Fprint returned error: %s", err)
+	}
+	if out.String() != expected {
+		t.Errorf("Fprint wrong.\nwant:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
+
+func TestInspect(t *testing.T) {
+	// let x = fn() { {a: -b} }
+	program := &Program{
+		Statements: []Statement{
+			&LetStatement{
+				Name: &Identifier{Value: "x"},
+				Value: &FunctionLiteral{
+					Body: &BlockStatement{
+						Statements: []Statement{
+							&ExpressionStatement{
+								Expression: &HashLiteral{
+									Pairs: []HashPair{
+										{Key: &Identifier{Value: "a"}, Value: &PrefixExpression{Operator: "-", Right: &Identifier{Value: "b"}}},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	var visited []string
+	Inspect(program, func(node Node) bool {
+		if ident, ok := node.(*Identifier); ok {
+			visited = append(visited, ident.Value)
+		}
+		return true
+	})
+	if strings.Join(visited, ",") != "x,a,b" {
+		t.Errorf("wrong identifiers visited. want: %q, got: %q", "x,a,b", strings.Join(visited, ","))
+	}
+
+	visited = nil
+	Inspect(program, func(node Node) bool {
+		if ident, ok := node.(*Identifier); ok {
+			visited = append(visited, ident.Value)
+		}
+		_, isFunction := node.(*FunctionLiteral)
+		return !isFunction
+	})
+	if strings.Join(visited, ",") != "x" {
+		t.Errorf("function body not skipped. got: %q", strings.Join(visited, ","))
+	}
+}
@@ -0,0 +1,88 @@
+package ast
+
+import (
+	"fmt"
+	"interpreters/token"
+	"io"
+	"reflect"
+	"strings"
+)
+
+var (
+	tokenType    = reflect.TypeOf(token.Token{})
+	commentsType = reflect.TypeOf([]*Comment{})
+)
+
+// Fprint writes node to w as an indented tree for debugging, one node per
+// line with its position and its scalar fields, e.g.
+//
+//	ExpressionStatement 1:1
+//	  Expression: InfixExpression 1:1 Operator="+"
+//	    Left: IntegerLiteral 1:1 Value=1
+//	    Right: Identifier 1:5 Value="x"
+func Fprint(w io.Writer, node Node) error {
+	p := &printer{w: w}
+	p.print("", reflect.ValueOf(node), 0)
+	return p.err
+}
+
+type printer struct {
+	w   io.Writer
+	err error
+}
+
+// print writes the node or struct held by v, after label, and its children
+// one level deeper. Tokens are left out as the fields they were parsed
+// into say the same.
+func (p *printer) print(label string, v reflect.Value, depth int) {
+	for v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+
+	line := label + v.Type().Name()
+	if node, ok := v.Addr().Interface().(Node); ok {
+		if pos := node.Pos(); pos.IsValid() {
+			line += " " + pos.String()
+		}
+	}
+
+	var children []int
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		value := v.Field(i)
+
+		switch {
+		case field.PkgPath != "", field.Type == tokenType, field.Type == commentsType:
+		case value.Kind() == reflect.String || value.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.String:
+			line += fmt.Sprintf(" %s=%q", field.Name, value.Interface())
+		case value.Kind() == reflect.Int64 || value.Kind() == reflect.Float64 || value.Kind() == reflect.Bool:
+			line += fmt.Sprintf(" %s=%v", field.Name, value.Interface())
+		default:
+			children = append(children, i)
+		}
+	}
+	p.writeLine(depth, line)
+
+	for _, i := range children {
+		name := v.Type().Field(i).Name
+		value := v.Field(i)
+
+		if value.Kind() != reflect.Slice {
+			p.print(name+": ", value, depth+1)
+			continue
+		}
+		for j := 0; j < value.Len(); j++ {
+			p.print(fmt.Sprintf("%s[%d]: ", name, j), value.Index(j), depth+1)
+		}
+	}
+}
+
+func (p *printer) writeLine(depth int, line string) {
+	if p.err != nil {
+		return
+	}
+	_, p.err = io.WriteString(p.w, strings.Repeat("  ", depth)+line+"\n")
+}
@@ -0,0 +1,44 @@
+package ast
+
+import "reflect"
+
+// Inspect calls f for node and then, if f returns true, for each node
+// below it in source order
+func Inspect(node Node, f func(Node) bool) {
+	inspect(reflect.ValueOf(node), f)
+}
+
+// inspect walks the nodes held by v, which may be a node, a slice of nodes
+// or a struct such as a HashPair holding nodes
+func inspect(v reflect.Value, f func(Node) bool) {
+	for v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return
+		}
+		if node, ok := v.Interface().(Node); ok && !f(node) {
+			return
+		}
+		inspect(v.Elem(), f)
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			inspect(v.Index(i), f)
+		}
+	case reflect.Struct:
+		if v.Type() == tokenType {
+			return
+		}
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath == "" && v.Type().Field(i).Type != commentsType {
+				inspect(v.Field(i), f)
+			}
+		}
+	}
+}
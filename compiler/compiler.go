@@ -0,0 +1,764 @@
+package compiler
+
+import (
+	"fmt"
+	"interpreters/ast"
+	"interpreters/code"
+	"interpreters/object"
+	"interpreters/token"
+	"strings"
+)
+
+// Compiler lowers an ast.Program to bytecode for the vm
+type Compiler struct {
+	constants []object.Object
+
+	symbolTable *SymbolTable
+
+	scopes     []CompilationScope
+	scopeIndex int
+
+	// pos is the position of the node being compiled, recorded in the
+	// source map for every instruction emitted on its behalf
+	pos token.Position
+	// err is the first operand found too large for its instruction, such
+	// as the index of the 257th local of a function, reported once the
+	// program is compiled
+	err error
+}
+
+// CompilationScope holds the instructions of the function being compiled
+type CompilationScope struct {
+	instructions        code.Instructions
+	sourceMap           code.SourceMap
+	lastInstruction     EmittedInstruction
+	previousInstruction EmittedInstruction
+	// loops holds the loops enclosing the code being compiled, innermost last
+	loops []*loop
+}
+
+// loop tracks the jump targets of break and continue statements
+type loop struct {
+	// start is where continue jumps to
+	start int
+	// breaks holds the positions of the jumps emitted for break statements,
+	// patched to the end of the loop once it is known
+	breaks []int
+}
+
+type EmittedInstruction struct {
+	Opcode   code.Opcode
+	Position int
+}
+
+// Bytecode is the output of the compiler and the input of the vm
+type Bytecode struct {
+	Instructions code.Instructions
+	Constants    []object.Object
+	SourceMap    code.SourceMap
+	// GlobalNames holds the name of each global slot, by index
+	GlobalNames []string
+}
+
+func New() *Compiler {
+	symbolTable := NewSymbolTable()
+	for i, def := range object.Builtins {
+		symbolTable.DefineBuiltin(i, def.Name)
+	}
+
+	return &Compiler{
+		constants:   []object.Object{},
+		symbolTable: symbolTable,
+		scopes:      []CompilationScope{{}},
+	}
+}
+
+// NewWithState creates a compiler that keeps the globals and constants of a
+// previous compilation, as the REPL does between inputs
+func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
+	compiler := New()
+	compiler.symbolTable = s
+	compiler.constants = constants
+	return compiler
+}
+
+func (c *Compiler) Compile(node ast.Node) error {
+	pos := c.pos
+	c.pos = node.Pos()
+	defer func() { c.pos = pos }()
+
+	switch node := node.(type) {
+	case *ast.Program:
+		for _, s := range node.Statements {
+			if err := c.Compile(s); err != nil {
+				return err
+			}
+		}
+
+	case *ast.ExpressionStatement:
+		if err := c.Compile(node.Expression); err != nil {
+			return err
+		}
+		c.emit(code.OpPop)
+
+	case *ast.BlockStatement:
+		for _, s := range node.Statements {
+			if err := c.Compile(s); err != nil {
+				return err
+			}
+		}
+
+	case *ast.LetStatement:
+		// the value is compiled first so that `let x = x + 1` reads the x
+		// of an enclosing scope, as it does in the evaluator
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		symbol := c.symbolTable.Define(node.Name.Value)
+		if symbol.Scope == GlobalScope {
+			c.emit(code.OpSetGlobal, symbol.Index)
+		} else {
+			c.emit(code.OpSetLocal, symbol.Index)
+		}
+
+	case *ast.ReturnStatement:
+		if err := c.Compile(node.ReturnValue); err != nil {
+			return err
+		}
+		c.emit(code.OpReturnValue)
+
+	case *ast.Identifier:
+		symbol, ok := c.symbolTable.Resolve(node.Value)
+		if !ok {
+			// the evaluator only complains about unknown identifiers when
+			// they are evaluated, so they become globals that the vm
+			// reports as not found if they are still unset when read
+			symbol = c.symbolTable.DefineGlobal(node.Value)
+		}
+		c.loadSymbol(symbol)
+
+	case *ast.InfixExpression:
+		if node.Operator == "&&" || node.Operator == "||" {
+			return c.compileLogicalExpression(node)
+		}
+
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+
+		op, ok := infixOperators[node.Operator]
+		if !ok {
+			return fmt.Errorf("unknown operator %s", node.Operator)
+		}
+		c.emit(op)
+
+	case *ast.AssignExpression:
+		if err := c.compileAssignment(node); err != nil {
+			return err
+		}
+
+	case *ast.PrefixExpression:
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+
+		switch node.Operator {
+		case "!":
+			c.emit(code.OpBang)
+		case "-":
+			c.emit(code.OpMinus)
+		default:
+			return fmt.Errorf("unknown operator %s", node.Operator)
+		}
+
+	case *ast.IfExpression:
+		if err := c.Compile(node.Condition); err != nil {
+			return err
+		}
+
+		// the jump offsets are patched once the branches are compiled
+		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+		if err := c.Compile(node.Consequence); err != nil {
+			return err
+		}
+		if c.lastInstructionIs(code.OpPop) {
+			c.removeLastPop()
+		} else {
+			c.emit(code.OpNull)
+		}
+
+		jumpPos := c.emit(code.OpJump, 9999)
+		c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+
+		if node.Alternative == nil {
+			c.emit(code.OpNull)
+		} else {
+			if err := c.Compile(node.Alternative); err != nil {
+				return err
+			}
+			if c.lastInstructionIs(code.OpPop) {
+				c.removeLastPop()
+			} else {
+				c.emit(code.OpNull)
+			}
+		}
+
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+
+	case *ast.WhileStatement:
+		c.emit(code.OpLoop)
+		loopStart := len(c.currentInstructions())
+
+		if err := c.Compile(node.Condition); err != nil {
+			return err
+		}
+		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+		if err := c.compileLoopBody(node.Body, loopStart); err != nil {
+			return err
+		}
+		c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+		c.emit(code.OpEndLoop)
+
+		// loops evaluate to null, like an if without an else
+		c.emit(code.OpNull)
+		c.emit(code.OpPop)
+
+	case *ast.ForStatement:
+		if err := c.Compile(node.Iterable); err != nil {
+			return err
+		}
+		c.emit(code.OpIter)
+
+		// the iterator stays on the stack while the loop runs
+		c.emit(code.OpLoop)
+		loopStart := len(c.currentInstructions())
+		iterNextPos := c.emit(code.OpIterNext, 9999)
+
+		symbol := c.symbolTable.Define(node.Variable.Value)
+		if symbol.Scope == GlobalScope {
+			c.emit(code.OpSetGlobal, symbol.Index)
+		} else {
+			c.emit(code.OpSetLocal, symbol.Index)
+		}
+
+		if err := c.compileLoopBody(node.Body, loopStart); err != nil {
+			return err
+		}
+		c.changeOperand(iterNextPos, len(c.currentInstructions()))
+		c.emit(code.OpEndLoop)
+
+		c.emit(code.OpPop)
+		c.emit(code.OpNull)
+		c.emit(code.OpPop)
+
+	case *ast.BreakStatement:
+		loop := c.currentLoop()
+		if loop == nil {
+			return newError(node.Pos(), "break outside of a loop")
+		}
+		loop.breaks = append(loop.breaks, c.emit(code.OpLoopJump, 9999))
+
+	case *ast.ContinueStatement:
+		loop := c.currentLoop()
+		if loop == nil {
+			return newError(node.Pos(), "continue outside of a loop")
+		}
+		c.emit(code.OpLoopJump, loop.start)
+
+	case *ast.IntegerLiteral:
+		integer := &object.Integer{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(integer))
+
+	case *ast.FloatLiteral:
+		float := &object.Float{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(float))
+
+	case *ast.StringLiteral:
+		str := &object.String{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(str))
+
+	case *ast.InterpolatedString:
+		// empty text between the expressions is left out
+		parts := 0
+		for i, text := range node.Strings {
+			if text != "" {
+				c.emit(code.OpConstant, c.addConstant(&object.String{Value: text}))
+				parts++
+			}
+			if i < len(node.Expressions) {
+				if err := c.Compile(node.Expressions[i]); err != nil {
+					return err
+				}
+				parts++
+			}
+		}
+		c.emit(code.OpInterpolate, parts)
+
+	case *ast.Boolean:
+		if node.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			if err := c.Compile(el); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpArray, len(node.Elements))
+
+	case *ast.HashLiteral:
+		for _, pair := range node.Pairs {
+			if err := c.Compile(pair.Key); err != nil {
+				return err
+			}
+			if err := c.Compile(pair.Value); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpHash, len(node.Pairs)*2)
+
+	case *ast.IndexExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Index); err != nil {
+			return err
+		}
+		c.emit(code.OpIndex)
+
+	case *ast.SliceExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		for _, bound := range []ast.Expression{node.Start, node.Stop} {
+			if bound == nil {
+				c.emit(code.OpNull)
+				continue
+			}
+			if err := c.Compile(bound); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpSlice)
+
+	case *ast.FunctionLiteral:
+		c.enterScope()
+
+		// a function assigning to its own name reaches itself through the
+		// variable its let statement binds, so that it sees the new value
+		if node.Name != "" && !assigns(node.Body, node.Name) {
+			c.symbolTable.DefineFunctionName(node.Name)
+		}
+
+		for _, p := range node.Parameters {
+			c.symbolTable.Define(p.Value)
+		}
+		c.symbolTable.DeclareLater(definedNames(node.Body))
+
+		if err := c.Compile(node.Body); err != nil {
+			return err
+		}
+
+		if c.lastInstructionIs(code.OpPop) {
+			c.replaceLastPopWithReturn()
+		}
+		if !c.lastInstructionIs(code.OpReturnValue) {
+			c.emit(code.OpReturn)
+		}
+
+		freeSymbols := c.symbolTable.FreeSymbols
+		numLocals := c.symbolTable.NumDefinitions()
+		localNames := c.symbolTable.Names()
+		instructions, sourceMap := c.leaveScope()
+
+		freeNames := make([]string, len(freeSymbols))
+		for i, s := range freeSymbols {
+			freeNames[i] = s.Name
+		}
+
+		// captured variables are passed to the closure as cells so that
+		// it shares them with the scope that defined them
+		for _, s := range freeSymbols {
+			c.loadCell(s)
+		}
+
+		compiledFn := &object.CompiledFunction{
+			Instructions:  instructions,
+			NumLocals:     numLocals,
+			NumParameters: len(node.Parameters),
+			Name:          node.Name,
+			SourceMap:     sourceMap,
+			LocalNames:    localNames,
+			FreeNames:     freeNames,
+		}
+
+		fnIndex := c.addConstant(compiledFn)
+		c.emit(code.OpClosure, fnIndex, len(freeSymbols))
+
+	case *ast.CallExpression:
+		if err := c.Compile(node.Function); err != nil {
+			return err
+		}
+
+		for _, a := range node.Arguments {
+			if err := c.Compile(a); err != nil {
+				return err
+			}
+		}
+
+		c.emit(code.OpCall, len(node.Arguments))
+
+	default:
+		return fmt.Errorf("%s: cannot compile %T", node.Pos(), node)
+	}
+
+	return c.err
+}
+
+var infixOperators = map[string]code.Opcode{
+	"+":  code.OpAdd,
+	"-":  code.OpSub,
+	"*":  code.OpMul,
+	"/":  code.OpDiv,
+	"%":  code.OpMod,
+	">=": code.OpGreaterEqual,
+	"<=": code.OpLessEqual,
+	">":  code.OpGreaterThan,
+	"<":  code.OpLessThan,
+	"==": code.OpEqual,
+	"!=": code.OpNotEqual,
+}
+
+func (c *Compiler) Bytecode() *Bytecode {
+	return &Bytecode{
+		Instructions: c.currentInstructions(),
+		Constants:    c.constants,
+		SourceMap:    c.scopes[c.scopeIndex].sourceMap,
+		GlobalNames:  c.symbolTable.Names(),
+	}
+}
+
+func (c *Compiler) addConstant(obj object.Object) int {
+	c.constants = append(c.constants, obj)
+	return len(c.constants) - 1
+}
+
+func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	c.checkOperands(op, operands)
+	ins := code.Make(op, operands...)
+	pos := c.addInstruction(ins)
+
+	c.setLastInstruction(op, pos)
+
+	return pos
+}
+
+func (c *Compiler) addInstruction(ins []byte) int {
+	scope := &c.scopes[c.scopeIndex]
+	posNewInstruction := len(scope.instructions)
+	scope.instructions = append(scope.instructions, ins...)
+
+	if n := len(scope.sourceMap); n == 0 || scope.sourceMap[n-1].Pos != c.pos {
+		scope.sourceMap = append(scope.sourceMap, code.SourceMapping{Offset: posNewInstruction, Pos: c.pos})
+	}
+
+	return posNewInstruction
+}
+
+func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
+	scope := &c.scopes[c.scopeIndex]
+	scope.previousInstruction = scope.lastInstruction
+	scope.lastInstruction = EmittedInstruction{Opcode: op, Position: pos}
+}
+
+func (c *Compiler) currentInstructions() code.Instructions {
+	return c.scopes[c.scopeIndex].instructions
+}
+
+func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
+	if len(c.currentInstructions()) == 0 {
+		return false
+	}
+	return c.scopes[c.scopeIndex].lastInstruction.Opcode == op
+}
+
+func (c *Compiler) removeLastPop() {
+	scope := &c.scopes[c.scopeIndex]
+	last := scope.lastInstruction.Position
+
+	scope.instructions = scope.instructions[:last]
+	for len(scope.sourceMap) > 0 && scope.sourceMap[len(scope.sourceMap)-1].Offset >= last {
+		scope.sourceMap = scope.sourceMap[:len(scope.sourceMap)-1]
+	}
+	scope.lastInstruction = scope.previousInstruction
+}
+
+func (c *Compiler) replaceLastPopWithReturn() {
+	lastPos := c.scopes[c.scopeIndex].lastInstruction.Position
+	c.replaceInstruction(lastPos, code.Make(code.OpReturnValue))
+	c.scopes[c.scopeIndex].lastInstruction.Opcode = code.OpReturnValue
+}
+
+func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
+	ins := c.currentInstructions()
+	for i := 0; i < len(newInstruction); i++ {
+		ins[pos+i] = newInstruction[i]
+	}
+}
+
+// changeOperand rewrites the operand of the instruction at opPos, used to
+// patch jump targets
+func (c *Compiler) changeOperand(opPos int, operand int) {
+	op := code.Opcode(c.currentInstructions()[opPos])
+	c.checkOperands(op, []int{operand})
+	c.replaceInstruction(opPos, code.Make(op, operand))
+}
+
+// checkOperands records an error in c.err if an operand of op does not
+// fit in its width, which code.Make would silently truncate
+func (c *Compiler) checkOperands(op code.Opcode, operands []int) {
+	def, err := code.Lookup(byte(op))
+	if err != nil || c.err != nil {
+		return
+	}
+	for i, operand := range operands {
+		if i >= len(def.OperandWidths) {
+			break
+		}
+		if max := 1<<(8*uint(def.OperandWidths[i])) - 1; operand > max {
+			c.err = newError(c.pos, "program too large: operand %d of %s exceeds %d", operand, def.Name, max)
+			return
+		}
+	}
+}
+
+// newError returns an error about the program being compiled, positioned
+// like the runtime errors of the vm
+func newError(pos token.Position, format string, a ...interface{}) *object.Error {
+	return &object.Error{Message: fmt.Sprintf(format, a...), Pos: pos}
+}
+
+// compileAssignment compiles an assignment to a variable or to an index
+// expression, leaving the assigned value on the stack
+func (c *Compiler) compileAssignment(node *ast.AssignExpression) error {
+	var op code.Opcode
+	if operator := strings.TrimSuffix(node.Operator, "="); operator != "" {
+		var ok bool
+		if op, ok = infixOperators[operator]; !ok {
+			return fmt.Errorf("unknown operator %s", node.Operator)
+		}
+	}
+
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		symbol, ok := c.symbolTable.Resolve(target.Value)
+		if !ok {
+			// like reading an unknown identifier, assigning to one fails
+			// in the vm unless the global is set by then
+			symbol = c.symbolTable.DefineGlobal(target.Value)
+		}
+
+		if symbol.Scope == BuiltinScope {
+			return newError(node.Pos(), "cannot assign to builtin: %s", target.Value)
+		}
+
+		if op != 0 {
+			c.loadSymbol(symbol)
+		}
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		if op != 0 {
+			c.emit(op)
+		}
+
+		switch symbol.Scope {
+		case GlobalScope:
+			c.emit(code.OpAssignGlobal, symbol.Index)
+		case LocalScope:
+			c.emit(code.OpAssignLocal, symbol.Index)
+		case FreeScope:
+			c.emit(code.OpAssignFree, symbol.Index)
+		}
+
+	case *ast.IndexExpression:
+		if err := c.Compile(target.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(target.Index); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.emit(code.OpSetIndex, int(op))
+
+	default:
+		return newError(node.Pos(), "cannot assign to %s", node.Target)
+	}
+
+	return nil
+}
+
+// compileLogicalExpression compiles && and || to jumps that skip the right
+// operand when the left one decides the result, which is always a boolean:
+//
+//	left                          left
+//	OpJumpNotTruthy false         OpBang
+//	right                         OpJumpNotTruthy true
+//	OpJumpNotTruthy false         right
+//	OpTrue                        OpJumpNotTruthy false
+//	OpJump end                    true: OpTrue
+//	false: OpFalse                OpJump end
+//	end:                          false: OpFalse
+//	                              end:
+func (c *Compiler) compileLogicalExpression(node *ast.InfixExpression) error {
+	if err := c.Compile(node.Left); err != nil {
+		return err
+	}
+
+	jumpsToFalse := []int{}
+	jumpToTrue := -1
+	if node.Operator == "&&" {
+		jumpsToFalse = append(jumpsToFalse, c.emit(code.OpJumpNotTruthy, 9999))
+	} else {
+		c.emit(code.OpBang)
+		jumpToTrue = c.emit(code.OpJumpNotTruthy, 9999)
+	}
+
+	if err := c.Compile(node.Right); err != nil {
+		return err
+	}
+	jumpsToFalse = append(jumpsToFalse, c.emit(code.OpJumpNotTruthy, 9999))
+
+	if jumpToTrue != -1 {
+		c.changeOperand(jumpToTrue, len(c.currentInstructions()))
+	}
+	c.emit(code.OpTrue)
+	jumpToEnd := c.emit(code.OpJump, 9999)
+
+	for _, pos := range jumpsToFalse {
+		c.changeOperand(pos, len(c.currentInstructions()))
+	}
+	c.emit(code.OpFalse)
+
+	c.changeOperand(jumpToEnd, len(c.currentInstructions()))
+
+	return nil
+}
+
+// compileLoopBody compiles body followed by a jump back to start, and
+// points the break statements of body to the instruction after that jump,
+// where the caller emits OpEndLoop
+func (c *Compiler) compileLoopBody(body *ast.BlockStatement, start int) error {
+	l := &loop{start: start}
+	// the scope is looked up again after compiling body, which may grow
+	// c.scopes when it contains function literals
+	c.scopes[c.scopeIndex].loops = append(c.scopes[c.scopeIndex].loops, l)
+
+	if err := c.Compile(body); err != nil {
+		return err
+	}
+	c.emit(code.OpJump, start)
+
+	loops := c.scopes[c.scopeIndex].loops
+	c.scopes[c.scopeIndex].loops = loops[:len(loops)-1]
+	for _, pos := range l.breaks {
+		c.changeOperand(pos, len(c.currentInstructions()))
+	}
+
+	return nil
+}
+
+// definedNames returns the names that body defines with let and for
+// statements, leaving out the functions nested in it
+func definedNames(body *ast.BlockStatement) []string {
+	var names []string
+	ast.Inspect(body, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.LetStatement:
+			names = append(names, node.Name.Value)
+		case *ast.ForStatement:
+			names = append(names, node.Variable.Value)
+		case *ast.FunctionLiteral:
+			return false
+		}
+		return true
+	})
+	return names
+}
+
+// assigns reports whether body, or a function nested in it, assigns to
+// the variable name
+func assigns(body *ast.BlockStatement, name string) bool {
+	found := false
+	ast.Inspect(body, func(node ast.Node) bool {
+		if assign, ok := node.(*ast.AssignExpression); ok {
+			if ident, ok := assign.Target.(*ast.Identifier); ok && ident.Value == name {
+				found = true
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+// currentLoop returns the innermost loop of the current scope, or nil
+func (c *Compiler) currentLoop() *loop {
+	loops := c.scopes[c.scopeIndex].loops
+	if len(loops) == 0 {
+		return nil
+	}
+	return loops[len(loops)-1]
+}
+
+func (c *Compiler) enterScope() {
+	c.scopes = append(c.scopes, CompilationScope{})
+	c.scopeIndex++
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
+}
+
+func (c *Compiler) leaveScope() (code.Instructions, code.SourceMap) {
+	scope := c.scopes[c.scopeIndex]
+
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+	c.symbolTable = c.symbolTable.Outer
+
+	return scope.instructions, scope.sourceMap
+}
+
+func (c *Compiler) loadSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpGetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpGetLocal, s.Index)
+	case BuiltinScope:
+		c.emit(code.OpGetBuiltin, s.Index)
+	case FreeScope:
+		c.emit(code.OpGetFree, s.Index)
+	case FunctionScope:
+		c.emit(code.OpCurrentClosure)
+	}
+}
+
+// loadCell pushes the cell of a variable captured by a closure
+func (c *Compiler) loadCell(s Symbol) {
+	switch s.Scope {
+	case LocalScope:
+		c.emit(code.OpGetLocalCell, s.Index)
+	case FreeScope:
+		c.emit(code.OpGetFreeCell, s.Index)
+	default:
+		c.loadSymbol(s)
+	}
+}
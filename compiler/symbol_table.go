@@ -0,0 +1,155 @@
+package compiler
+
+type SymbolScope string
+
+const (
+	GlobalScope   SymbolScope = "GLOBAL"
+	LocalScope    SymbolScope = "LOCAL"
+	BuiltinScope  SymbolScope = "BUILTIN"
+	FreeScope     SymbolScope = "FREE"
+	FunctionScope SymbolScope = "FUNCTION"
+)
+
+type Symbol struct {
+	Name  string
+	Scope SymbolScope
+	Index int
+}
+
+// SymbolTable resolves identifiers to global slots, local slots, builtins
+// and free variables. Each function literal gets its own enclosed table.
+type SymbolTable struct {
+	Outer *SymbolTable
+
+	store map[string]Symbol
+	// names holds the name of each global or local slot, by index
+	names []string
+
+	FreeSymbols []Symbol
+
+	// later holds the names the function defines further on in its body.
+	// A function nested in it that refers to one of them gets the slot
+	// ahead of its definition, kept in ahead until then.
+	later map[string]bool
+	ahead map[string]Symbol
+}
+
+func NewSymbolTable() *SymbolTable {
+	return &SymbolTable{
+		store: make(map[string]Symbol),
+		later: make(map[string]bool),
+		ahead: make(map[string]Symbol),
+	}
+}
+
+func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	return s
+}
+
+// Define binds name to a slot of this table. Like `let` in the evaluator,
+// defining a name twice in the same scope reuses its slot.
+func (s *SymbolTable) Define(name string) Symbol {
+	scope := GlobalScope
+	if s.Outer != nil {
+		scope = LocalScope
+	}
+
+	if symbol, ok := s.store[name]; ok && symbol.Scope == scope {
+		return symbol
+	}
+	if symbol, ok := s.ahead[name]; ok {
+		delete(s.ahead, name)
+		s.store[name] = symbol
+		return symbol
+	}
+
+	symbol := Symbol{Name: name, Scope: scope, Index: len(s.names)}
+	s.store[name] = symbol
+	s.names = append(s.names, name)
+	return symbol
+}
+
+// DeclareLater records names that the function defines further on, so
+// that the functions nested in it resolve them to its slots, as the
+// evaluator does when they are called after the definition
+func (s *SymbolTable) DeclareLater(names []string) {
+	for _, name := range names {
+		s.later[name] = true
+	}
+}
+
+// DefineGlobal defines name in the outermost table
+func (s *SymbolTable) DefineGlobal(name string) Symbol {
+	if s.Outer != nil {
+		return s.Outer.DefineGlobal(name)
+	}
+	return s.Define(name)
+}
+
+func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
+	symbol := Symbol{Name: name, Scope: BuiltinScope, Index: index}
+	s.store[name] = symbol
+	return symbol
+}
+
+// DefineFunctionName lets a function refer to itself by name
+func (s *SymbolTable) DefineFunctionName(name string) Symbol {
+	symbol := Symbol{Name: name, Scope: FunctionScope, Index: 0}
+	s.store[name] = symbol
+	return symbol
+}
+
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	s.FreeSymbols = append(s.FreeSymbols, original)
+
+	symbol := Symbol{Name: original.Name, Scope: FreeScope, Index: len(s.FreeSymbols) - 1}
+	s.store[original.Name] = symbol
+	return symbol
+}
+
+func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
+	symbol, ok := s.store[name]
+	if ok || s.Outer == nil {
+		return symbol, ok
+	}
+
+	symbol, ok = s.Outer.resolveNested(name)
+	if !ok {
+		return symbol, ok
+	}
+
+	if symbol.Scope == GlobalScope || symbol.Scope == BuiltinScope {
+		return symbol, ok
+	}
+
+	return s.defineFree(symbol), true
+}
+
+// resolveNested resolves name for a function nested in this table's. A
+// name this function defines later gets its slot now, while the function
+// itself resolves it to an outer variable until it is defined.
+func (s *SymbolTable) resolveNested(name string) (Symbol, bool) {
+	if symbol, ok := s.store[name]; (ok && symbol.Scope == LocalScope) || !s.later[name] {
+		return s.Resolve(name)
+	}
+
+	symbol, ok := s.ahead[name]
+	if !ok {
+		symbol = Symbol{Name: name, Scope: LocalScope, Index: len(s.names)}
+		s.ahead[name] = symbol
+		s.names = append(s.names, name)
+	}
+	return symbol, true
+}
+
+// NumDefinitions returns the number of global or local slots in the table
+func (s *SymbolTable) NumDefinitions() int {
+	return len(s.names)
+}
+
+// Names returns the name of each global or local slot, by index
+func (s *SymbolTable) Names() []string {
+	return s.names
+}
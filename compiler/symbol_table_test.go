@@ -0,0 +1,107 @@
+package compiler
+
+import "testing"
+
+func TestDefine(t *testing.T) {
+	global := NewSymbolTable()
+
+	a := global.Define("a")
+	if a != (Symbol{Name: "a", Scope: GlobalScope, Index: 0}) {
+		t.Errorf("a wrong, got: %+v", a)
+	}
+
+	b := global.Define("b")
+	if b != (Symbol{Name: "b", Scope: GlobalScope, Index: 1}) {
+		t.Errorf("b wrong, got: %+v", b)
+	}
+
+	// redefining a name in the same scope keeps its slot
+	if again := global.Define("a"); again != a {
+		t.Errorf("redefined a wrong, got: %+v", again)
+	}
+
+	local := NewEnclosedSymbolTable(global)
+	c := local.Define("a")
+	if c != (Symbol{Name: "a", Scope: LocalScope, Index: 0}) {
+		t.Errorf("local a wrong, got: %+v", c)
+	}
+
+	if global.NumDefinitions() != 2 || local.NumDefinitions() != 1 {
+		t.Errorf("NumDefinitions wrong, got: %d and %d", global.NumDefinitions(), local.NumDefinitions())
+	}
+}
+
+func TestResolveFree(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+	global.DefineBuiltin(0, "len")
+
+	first := NewEnclosedSymbolTable(global)
+	first.Define("b")
+
+	second := NewEnclosedSymbolTable(first)
+	second.Define("c")
+
+	tests := []struct {
+		name     string
+		expected Symbol
+	}{
+		{"a", Symbol{Name: "a", Scope: GlobalScope, Index: 0}},
+		{"len", Symbol{Name: "len", Scope: BuiltinScope, Index: 0}},
+		{"b", Symbol{Name: "b", Scope: FreeScope, Index: 0}},
+		{"c", Symbol{Name: "c", Scope: LocalScope, Index: 0}},
+	}
+
+	for _, tt := range tests {
+		result, ok := second.Resolve(tt.name)
+		if !ok {
+			t.Errorf("name %s not resolvable", tt.name)
+			continue
+		}
+		if result != tt.expected {
+			t.Errorf("expected %s to resolve to %+v, got: %+v", tt.name, tt.expected, result)
+		}
+	}
+
+	if len(second.FreeSymbols) != 1 || second.FreeSymbols[0] != (Symbol{Name: "b", Scope: LocalScope, Index: 0}) {
+		t.Errorf("second.FreeSymbols wrong, got: %+v", second.FreeSymbols)
+	}
+
+	if _, ok := second.Resolve("d"); ok {
+		t.Errorf("name d resolved, but was never defined")
+	}
+
+	if d := second.DefineGlobal("d"); d != (Symbol{Name: "d", Scope: GlobalScope, Index: 1}) {
+		t.Errorf("DefineGlobal wrong, got: %+v", d)
+	}
+}
+
+func TestDeclareLater(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("h")
+
+	outer := NewEnclosedSymbolTable(global)
+	outer.Define("x")
+	outer.DeclareLater([]string{"h"})
+
+	// a nested function gets the slot of h before outer defines it
+	inner := NewEnclosedSymbolTable(outer)
+	h, ok := inner.Resolve("h")
+	if !ok || h != (Symbol{Name: "h", Scope: FreeScope, Index: 0}) {
+		t.Errorf("h wrong in the nested function, got: %+v", h)
+	}
+	if inner.FreeSymbols[0] != (Symbol{Name: "h", Scope: LocalScope, Index: 1}) {
+		t.Errorf("h captured from the wrong slot, got: %+v", inner.FreeSymbols[0])
+	}
+
+	// outer itself reads the global h until it defines its own
+	if h, _ := outer.Resolve("h"); h.Scope != GlobalScope {
+		t.Errorf("h wrong before its definition, got: %+v", h)
+	}
+	if h := outer.Define("h"); h != (Symbol{Name: "h", Scope: LocalScope, Index: 1}) {
+		t.Errorf("h defined in a new slot, got: %+v", h)
+	}
+	if outer.NumDefinitions() != 2 {
+		t.Errorf("NumDefinitions wrong, got: %d", outer.NumDefinitions())
+	}
+}
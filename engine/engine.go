@@ -0,0 +1,211 @@
+// Package engine runs Monkey programs with either the tree-walking
+// evaluator or the bytecode compiler and vm, behind one interface
+package engine
+
+import (
+	"context"
+	"fmt"
+	"interpreters/ast"
+	"interpreters/compiler"
+	"interpreters/evaluator"
+	"interpreters/object"
+	"interpreters/vm"
+	"sort"
+)
+
+// Engine evaluates programs, keeping the global definitions of one program
+// around for the next, as the REPL needs
+type Engine interface {
+	// Eval runs program under ctx and returns its value, nil if it has
+	// none. Runtime errors, including those raised when ctx is done or a
+	// limit is exceeded, are returned as *object.Error.
+	Eval(ctx context.Context, program *ast.Program) object.Object
+	// Define binds name to value in the global scope
+	Define(name string, value object.Object)
+	// Get returns the value of the global or builtin called name
+	Get(name string) (object.Object, bool)
+	// Globals returns the names of the globals that have been set, in
+	// sorted order
+	Globals() []string
+	// Call calls fn, a function or builtin returned by Eval or Get, with
+	// args under ctx. When Go code called by a running program calls back
+	// into Monkey, the call joins that evaluation and its limits instead.
+	// Runtime errors are returned as *object.Error.
+	Call(ctx context.Context, fn object.Object, args ...object.Object) object.Object
+	// SetLimits sets the limits of the evaluations that follow
+	SetLimits(limits object.Limits)
+}
+
+// New returns the engine called name: "eval" for the tree-walking
+// evaluator or "vm" for the bytecode vm
+func New(name string) (Engine, error) {
+	switch name {
+	case "eval":
+		return &evaluatorBackend{env: object.NewEnvironment()}, nil
+	case "vm":
+		symbolTable := compiler.NewSymbolTable()
+		for i, def := range object.Builtins {
+			symbolTable.DefineBuiltin(i, def.Name)
+		}
+		return &vmBackend{
+			symbolTable: symbolTable,
+			constants:   []object.Object{},
+			globals:     make([]object.Object, vm.GlobalsSize),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown engine %q, want eval or vm", name)
+	}
+}
+
+// session holds the limits of an engine and the state of the evaluation it
+// is running, if any
+type session struct {
+	limits object.Limits
+	state  *object.State
+}
+
+func (s *session) SetLimits(limits object.Limits) {
+	s.limits = limits
+}
+
+// begin starts an evaluation under ctx, or joins the one in progress when
+// Go code it called is calling back into Monkey. end must be called when
+// the evaluation is done.
+func (s *session) begin(ctx context.Context) (state *object.State, end func()) {
+	if s.state != nil {
+		return s.state, func() {}
+	}
+	s.state = object.NewState(ctx, s.limits)
+	return s.state, func() { s.state = nil }
+}
+
+// evaluatorBackend runs programs with the tree-walking evaluator
+type evaluatorBackend struct {
+	session
+	env *object.Environment
+}
+
+func (b *evaluatorBackend) Eval(ctx context.Context, program *ast.Program) object.Object {
+	state, end := b.begin(ctx)
+	defer end()
+
+	b.env.SetState(state)
+	return evaluator.Eval(program, b.env)
+}
+
+func (b *evaluatorBackend) Define(name string, value object.Object) {
+	b.env.Set(name, value)
+}
+
+func (b *evaluatorBackend) Get(name string) (object.Object, bool) {
+	if value, ok := b.env.Get(name); ok {
+		return value, true
+	}
+	if builtin := object.GetBuiltinByName(name); builtin != nil {
+		return builtin, true
+	}
+	return nil, false
+}
+
+func (b *evaluatorBackend) Globals() []string {
+	return b.env.Names()
+}
+
+func (b *evaluatorBackend) Call(ctx context.Context, fn object.Object, args ...object.Object) object.Object {
+	state, end := b.begin(ctx)
+	defer end()
+
+	return evaluator.ApplyFunction(fn, args, state)
+}
+
+// vmBackend compiles programs to bytecode and runs them on the vm
+type vmBackend struct {
+	session
+	symbolTable *compiler.SymbolTable
+	constants   []object.Object
+	globals     []object.Object
+}
+
+func (b *vmBackend) Eval(ctx context.Context, program *ast.Program) object.Object {
+	comp := compiler.NewWithState(b.symbolTable, b.constants)
+	if err := comp.Compile(program); err != nil {
+		if errObj, ok := err.(*object.Error); ok {
+			return errObj
+		}
+		return &object.Error{Message: err.Error()}
+	}
+
+	bytecode := comp.Bytecode()
+	b.constants = bytecode.Constants
+
+	state, end := b.begin(ctx)
+	defer end()
+
+	machine := vm.NewWithGlobalsState(bytecode, b.globals)
+	machine.SetState(state)
+	if err := machine.Run(); err != nil {
+		if errObj, ok := err.(*object.Error); ok {
+			return errObj
+		}
+		return &object.Error{Message: err.Error()}
+	}
+
+	return machine.LastPoppedStackElem()
+}
+
+func (b *vmBackend) Define(name string, value object.Object) {
+	symbol := b.symbolTable.Define(name)
+	b.globals[symbol.Index] = value
+}
+
+func (b *vmBackend) Get(name string) (object.Object, bool) {
+	symbol, ok := b.symbolTable.Resolve(name)
+	if !ok {
+		return nil, false
+	}
+
+	switch symbol.Scope {
+	case compiler.GlobalScope:
+		// unknown identifiers get a global slot that may never be set
+		value := b.globals[symbol.Index]
+		return value, value != nil
+	case compiler.BuiltinScope:
+		return object.Builtins[symbol.Index].Builtin, true
+	default:
+		return nil, false
+	}
+}
+
+func (b *vmBackend) Globals() []string {
+	var names []string
+	for i, name := range b.symbolTable.Names() {
+		// unknown identifiers get a global slot that may never be set
+		if b.globals[i] != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (b *vmBackend) Call(ctx context.Context, fn object.Object, args ...object.Object) object.Object {
+	bytecode := &compiler.Bytecode{
+		Constants:   b.constants,
+		GlobalNames: b.symbolTable.Names(),
+	}
+
+	state, end := b.begin(ctx)
+	defer end()
+
+	machine := vm.NewWithGlobalsState(bytecode, b.globals)
+	machine.SetState(state)
+	result, err := machine.Call(fn, args...)
+	if err != nil {
+		if errObj, ok := err.(*object.Error); ok {
+			return errObj
+		}
+		return &object.Error{Message: err.Error()}
+	}
+
+	return result
+}
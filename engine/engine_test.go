@@ -0,0 +1,54 @@
+package engine
+
+import (
+	"context"
+	"interpreters/ast"
+	"interpreters/lexer"
+	"interpreters/object"
+	"interpreters/parser"
+	"strings"
+	"testing"
+)
+
+func TestEngines(t *testing.T) {
+	for _, name := range []string{"eval", "vm"} {
+		e, err := New(name)
+		if err != nil {
+			t.Fatalf("New(%q) returned error: %s", name, err)
+		}
+
+		e.Define("argv", &object.Array{Elements: []object.Object{&object.String{Value: "script.mk"}}})
+		e.Eval(context.Background(), parse(t, "let double = fn(x) { x * 2 };"))
+
+		result := e.Eval(context.Background(), parse(t, "double(len(argv[0]))"))
+		integer, ok := result.(*object.Integer)
+		if !ok {
+			t.Fatalf("%s: object is not Integer. got=%T (%+v)", name, result, result)
+		}
+		if integer.Value != 18 {
+			t.Errorf("%s: wrong value. want=18, got=%d", name, integer.Value)
+		}
+
+		if _, ok := e.Eval(context.Background(), parse(t, "double(true)")).(*object.Error); !ok {
+			t.Errorf("%s: expected a runtime error", name)
+		}
+
+		e.Eval(context.Background(), parse(t, "let a = 1; missing"))
+		if globals := e.Globals(); strings.Join(globals, ",") != "a,argv,double" {
+			t.Errorf("%s: wrong globals. want=[a argv double], got=%v", name, globals)
+		}
+	}
+
+	if _, err := New("jit"); err == nil {
+		t.Errorf("expected an error for an unknown engine")
+	}
+}
+
+func parse(t *testing.T, input string) *ast.Program {
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	return program
+}
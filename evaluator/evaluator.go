@@ -4,31 +4,55 @@ import (
 	"fmt"
 	"interpreters/ast"
 	"interpreters/object"
+	"interpreters/token"
+	"strings"
 )
 
 var (
-	NULL  = &object.Null{}
-	TRUE  = &object.Boolean{Value: true}
-	FALSE = &object.Boolean{Value: false}
+	NULL  = object.NULL
+	TRUE  = object.TRUE
+	FALSE = object.FALSE
+
+	BREAK    = &object.Break{}
+	CONTINUE = &object.Continue{}
 )
 
+// Eval evaluates node in env, under the state of env. Errors raised while
+// evaluating node are tagged with the position of the innermost node that
+// produced them.
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	if err := env.State().Step(); err != nil {
+		err.Pos = node.Pos()
+		return err
+	}
+
+	result := eval(node, env)
+	if err, ok := result.(*object.Error); ok && !err.Pos.IsValid() {
+		err.Pos = node.Pos()
+	}
+	return result
+}
+
+func eval(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
 
+	case *ast.InterpolatedString:
+		return evalInterpolatedString(node, env)
+
 	case *ast.CallExpression:
 		function := Eval(node.Function, env)
-		if isError(function) {
+		if isUnwinding(function) {
 			return function
 		}
 		args := evalExpressions(node.Arguments, env)
-		if len(args) == 1 && isError(args[0]) {
+		if len(args) == 1 && isUnwinding(args[0]) {
 			return args[0]
 		}
 
-		return applyFunction(function, args)
+		return applyFunction(function, args, node.Pos(), env.State())
 
 	case *ast.FunctionLiteral:
 		return evalFunctionLiteral(node, env)
@@ -41,32 +65,70 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 
 	case *ast.IndexExpression:
 		left := Eval(node.Left, env)
-		if isError(left) {
+		if isUnwinding(left) {
 			return left
 		}
 
 		index := Eval(node.Index, env)
-		if isError(index) {
+		if isUnwinding(index) {
 			return index
 		}
 
 		return evalIndexExpression(left, index, env)
 
+	case *ast.SliceExpression:
+		left := Eval(node.Left, env)
+		if isUnwinding(left) {
+			return left
+		}
+
+		bounds := []object.Object{NULL, NULL}
+		for i, bound := range []ast.Expression{node.Start, node.Stop} {
+			if bound == nil {
+				continue
+			}
+			bounds[i] = Eval(bound, env)
+			if isUnwinding(bounds[i]) {
+				return bounds[i]
+			}
+		}
+
+		return allocate(object.Slice(left, bounds[0], bounds[1]), env)
+
 	// Expressions
 	case *ast.IntegerLiteral:
 		return &object.Integer{Value: node.Value}
 
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
+
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 
 	case *ast.PrefixExpression:
 		right := Eval(node.Right, env)
+		if isUnwinding(right) {
+			return right
+		}
 		return evalPrefixExpression(node.Operator, right)
 
 	case *ast.InfixExpression:
+		if node.Operator == "&&" || node.Operator == "||" {
+			return evalLogicalExpression(node, env)
+		}
+
 		left := Eval(node.Left, env)
+		if isUnwinding(left) {
+			return left
+		}
 		right := Eval(node.Right, env)
-		return evalInfixExpression(node.Operator, left, right)
+		if isUnwinding(right) {
+			return right
+		}
+		return evalInfixExpression(node.Operator, left, right, env)
+
+	case *ast.AssignExpression:
+		return evalAssignExpression(node, env)
 
 	case *ast.BlockStatement:
 		return evalBlockStatement(node, env)
@@ -74,56 +136,96 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.IfExpression:
 		return evalIfExpression(node, env)
 
+	case *ast.WhileStatement:
+		return evalWhileStatement(node, env)
+
+	case *ast.ForStatement:
+		return evalForStatement(node, env)
+
+	case *ast.BreakStatement:
+		return BREAK
+
+	case *ast.ContinueStatement:
+		return CONTINUE
+
 	case *ast.ReturnStatement:
 		val := Eval(node.ReturnValue, env)
+		if isUnwinding(val) {
+			return val
+		}
 		return &object.ReturnValue{Value: val}
 
 	case *ast.LetStatement:
 		val := Eval(node.Value, env)
-		if isError(val) {
+		if isUnwinding(val) {
 			return val
 		}
 		env.Set(node.Name.Value, val)
 	case *ast.ArrayLiteral:
 		elements := evalExpressions(node.Elements, env)
-		if len(elements) == 1 && isError(elements[0]) {
+		if len(elements) == 1 && isUnwinding(elements[0]) {
 			return elements[0]
 		}
-		return &object.Array{Elements: elements}
+		return allocate(&object.Array{Elements: elements}, env)
 
 	case *ast.HashLiteral:
-		hash := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+		hash := object.NewHash()
 
-		for key, value := range node.Pairs {
-			keyObj := Eval(key, env)
-			if isError(keyObj) {
+		for _, pair := range node.Pairs {
+			keyObj := Eval(pair.Key, env)
+			if isUnwinding(keyObj) {
 				return keyObj
 			}
 
-			valueObj := Eval(value, env)
-			if isError(valueObj) {
+			valueObj := Eval(pair.Value, env)
+			if isUnwinding(valueObj) {
 				return valueObj
 			}
 
-			hashKey, ok := keyObj.(object.Hashable)
-			if !ok {
+			if _, ok := keyObj.(object.Hashable); !ok {
 				return newError("unusable as hash key: %s", keyObj.Type())
 			}
-			hash.Pairs[hashKey.HashKey()] = object.HashPair{
-				Key:   keyObj,
-				Value: valueObj,
-			}
+			hash.Set(keyObj, valueObj)
 		}
-		return hash
+		return allocate(hash, env)
 	}
 
 	return nil
 }
 
+// evalInterpolatedString joins the text of the string with the Inspect
+// form of each embedded expression
+func evalInterpolatedString(node *ast.InterpolatedString, env *object.Environment) object.Object {
+	var out strings.Builder
+
+	out.WriteString(node.Strings[0])
+	for i, exp := range node.Expressions {
+		value := Eval(exp, env)
+		if isUnwinding(value) {
+			return value
+		}
+		out.WriteString(value.Inspect())
+		out.WriteString(node.Strings[i+1])
+	}
+
+	return allocate(&object.String{Value: out.String()}, env)
+}
+
+// allocate charges the size of obj to the evaluation running in env and
+// returns obj, or an error once the allocation budget is exhausted
+func allocate(obj object.Object, env *object.Environment) object.Object {
+	if err := env.State().Allocate(object.SizeOf(obj)); err != nil {
+		return err
+	}
+	return obj
+}
+
 func evalIndexExpression(left object.Object, index object.Object, env *object.Environment) object.Object {
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		return evalArrayIndexExpression(left, index)
+	case left.Type() == object.STRING_OBJ && index.Type() == object.INTEGER_OBJ:
+		return evalStringIndexExpression(left, index)
 	case left.Type() == object.HASH_OBJ:
 		return evalHashIndexExpression(left, index)
 	default:
@@ -131,11 +233,106 @@ func evalIndexExpression(left object.Object, index object.Object, env *object.En
 	}
 }
 
+// evalAssignExpression stores a value into a variable, updating it in the
+// environment that defines it, or into an element of an array or hash.
+// Compound operators such as += combine the current value with the new one
+// using the matching infix operator.
+func evalAssignExpression(node *ast.AssignExpression, env *object.Environment) object.Object {
+	operator := strings.TrimSuffix(node.Operator, "=")
+
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		var current object.Object
+		if operator != "" {
+			current = evalIdentifier(target, env)
+			if isUnwinding(current) {
+				return current
+			}
+		}
+
+		value := Eval(node.Value, env)
+		if isUnwinding(value) {
+			return value
+		}
+
+		if operator != "" {
+			value = evalInfixExpression(operator, current, value, env)
+			if isUnwinding(value) {
+				return value
+			}
+		}
+
+		if !env.Assign(target.Value, value) {
+			if _, ok := builtins[target.Value]; ok {
+				return newError("cannot assign to builtin: %s", target.Value)
+			}
+			return newError("identifier not found: " + target.Value)
+		}
+		return value
+
+	case *ast.IndexExpression:
+		left := Eval(target.Left, env)
+		if isUnwinding(left) {
+			return left
+		}
+
+		index := Eval(target.Index, env)
+		if isUnwinding(index) {
+			return index
+		}
+
+		value := Eval(node.Value, env)
+		if isUnwinding(value) {
+			return value
+		}
+
+		if operator != "" {
+			current := evalIndexExpression(left, index, env)
+			if isUnwinding(current) {
+				return current
+			}
+			value = evalInfixExpression(operator, current, value, env)
+			if isUnwinding(value) {
+				return value
+			}
+		}
+
+		return evalIndexAssignment(left, index, value)
+
+	default:
+		return newError("cannot assign to %s", node.Target.String())
+	}
+}
+
+func evalIndexAssignment(left object.Object, index object.Object, value object.Object) object.Object {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		array := left.(*object.Array)
+		i := index.(*object.Integer).Value
+		if i < 0 || i >= int64(len(array.Elements)) {
+			return newError("index out of range: %d", i)
+		}
+		array.Elements[i] = value
+		return value
+
+	case left.Type() == object.HASH_OBJ:
+		hash := left.(*object.Hash)
+		if _, ok := index.(object.Hashable); !ok {
+			return newError("unusable as hash key: %s", index.Type())
+		}
+		hash.Set(index, value)
+		return value
+
+	default:
+		return newError("index assignment not supported: %s", left.Type())
+	}
+}
+
 func evalHashIndexExpression(left object.Object, index object.Object) object.Object {
 	hash := left.(*object.Hash)
 	hashKeyObj, ok := index.(object.Hashable)
 	if !ok {
-		return newError("unusable as hash key: %s", index)
+		return newError("unusable as hash key: %s", index.Type())
 	}
 
 	pair, ok := hash.Pairs[hashKeyObj.HashKey()]
@@ -146,6 +343,16 @@ func evalHashIndexExpression(left object.Object, index object.Object) object.Obj
 	return pair.Value
 }
 
+// evalStringIndexExpression returns the character at index as a string
+func evalStringIndexExpression(left object.Object, index object.Object) object.Object {
+	char, ok := left.(*object.String).CharAt(index.(*object.Integer).Value)
+	if !ok {
+		return NULL
+	}
+
+	return char
+}
+
 func evalArrayIndexExpression(left object.Object, index object.Object) object.Object {
 	array, _ := left.(*object.Array)
 	i, _ := index.(*object.Integer)
@@ -157,14 +364,44 @@ func evalArrayIndexExpression(left object.Object, index object.Object) object.Ob
 	return array.Elements[i.Value]
 }
 
-func applyFunction(function object.Object, args []object.Object) object.Object {
+// ApplyFunction calls function, a Monkey function or a builtin, with args
+// under state and returns its result, for Go code calling back into Monkey
+func ApplyFunction(function object.Object, args []object.Object, state *object.State) object.Object {
+	return applyFunction(function, args, token.Position{}, state)
+}
+
+// applyFunction calls function with args under state, the state of the
+// caller. callSite is the position of the call expression, recorded in the
+// stack of any error the call returns.
+func applyFunction(function object.Object, args []object.Object, callSite token.Position, state *object.State) object.Object {
 	switch fn := function.(type) {
 	case *object.Function:
-		extendedEnv := extendFunctionEnv(fn, args)
-		evaluated := Eval(fn.Body, extendedEnv)
-		return unwrapReturnValue(evaluated)
+		if len(args) != len(fn.Parameters) {
+			return newError("wrong number of arguments: want=%d, got=%d", len(fn.Parameters), len(args))
+		}
+		if err := state.Enter(); err != nil {
+			return err
+		}
+		defer state.Leave()
+		if err := state.Allocate(object.CallSize); err != nil {
+			return err
+		}
+
+		extendedEnv := extendFunctionEnv(fn, args, state)
+		evaluated := unwrapReturnValue(Eval(fn.Body, extendedEnv))
+		if err, ok := evaluated.(*object.Error); ok {
+			name := fn.Name
+			if name == "" {
+				name = "<anonymous>"
+			}
+			err.Stack = append(err.Stack, object.Frame{Function: name, Pos: callSite})
+		}
+		return evaluated
 	case *object.Builtin:
-		return fn.Fn(args...)
+		apply := func(fn object.Object, args ...object.Object) object.Object {
+			return applyFunction(fn, args, callSite, state)
+		}
+		return fn.Call(apply, state, args...)
 	default:
 		return newError("not a function: %s", fn.Type())
 	}
@@ -177,8 +414,12 @@ func unwrapReturnValue(obj object.Object) object.Object {
 	return obj
 }
 
-func extendFunctionEnv(fn *object.Function, args []object.Object) *object.Environment {
+// extendFunctionEnv creates the environment of a call to fn. The call runs
+// under state rather than the state fn was defined under, which belongs to
+// an earlier evaluation if fn outlived it.
+func extendFunctionEnv(fn *object.Function, args []object.Object, state *object.State) *object.Environment {
 	env := object.NewEnclosedEnvironment(fn.Env)
+	env.SetState(state)
 	for i, param := range fn.Parameters {
 		env.Set(param.Value, args[i])
 	}
@@ -190,7 +431,7 @@ func evalExpressions(arguments []ast.Expression, env *object.Environment) []obje
 
 	for _, arg := range arguments {
 		evaluated := Eval(arg, env)
-		if isError(evaluated) {
+		if isUnwinding(evaluated) {
 			return []object.Object{evaluated}
 		}
 		results = append(results, evaluated)
@@ -199,11 +440,12 @@ func evalExpressions(arguments []ast.Expression, env *object.Environment) []obje
 }
 
 func evalFunctionLiteral(node *ast.FunctionLiteral, env *object.Environment) object.Object {
-	return &object.Function{
+	return allocate(&object.Function{
 		Parameters: node.Parameters,
 		Body:       node.Body,
 		Env:        env,
-	}
+		Name:       node.Name,
+	}, env)
 }
 
 func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
@@ -218,7 +460,21 @@ func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object
 }
 
 func isError(obj object.Object) bool {
-	return obj.Type() == object.ERROR_OBJ
+	return obj != nil && obj.Type() == object.ERROR_OBJ
+}
+
+// isUnwinding reports whether obj is an error, or a return, break or
+// continue leaving the function or loop body it was evaluated in. The
+// operands evaluated so far are then dropped, as in the vm.
+func isUnwinding(obj object.Object) bool {
+	if obj == nil {
+		return false
+	}
+	switch obj.Type() {
+	case object.ERROR_OBJ, object.RETURN_VALUE_OBJ, object.BREAK_OBJ, object.CONTINUE_OBJ:
+		return true
+	}
+	return false
 }
 
 func evalBlockStatement(node *ast.BlockStatement, env *object.Environment) object.Object {
@@ -227,17 +483,68 @@ func evalBlockStatement(node *ast.BlockStatement, env *object.Environment) objec
 	for _, statement := range node.Statements {
 		result = Eval(statement, env)
 
-		if result != nil {
-			if result.Type() == object.RETURN_VALUE_OBJ || result.Type() == object.ERROR_OBJ {
-				return result
-			}
+		if isUnwinding(result) {
+			return result
 		}
 	}
 	return result
 }
 
+// evalWhileStatement runs the loop body in the enclosing environment, as
+// blocks do not have a scope of their own. Loops evaluate to null.
+func evalWhileStatement(ws *ast.WhileStatement, env *object.Environment) object.Object {
+	for {
+		condition := Eval(ws.Condition, env)
+		if isUnwinding(condition) {
+			return condition
+		}
+		if !isTruthy(condition) {
+			return NULL
+		}
+
+		result := Eval(ws.Body, env)
+		if result == BREAK {
+			return NULL
+		}
+		if isError(result) || (result != nil && result.Type() == object.RETURN_VALUE_OBJ) {
+			return result
+		}
+	}
+}
+
+func evalForStatement(fs *ast.ForStatement, env *object.Environment) object.Object {
+	iterable := Eval(fs.Iterable, env)
+	if isUnwinding(iterable) {
+		return iterable
+	}
+
+	iterator, ok := object.NewIterator(iterable)
+	if !ok {
+		return newError("not iterable: %s", iterable.Type())
+	}
+
+	for {
+		element, ok := iterator.Next()
+		if !ok {
+			return NULL
+		}
+		env.Set(fs.Variable.Value, element)
+
+		result := Eval(fs.Body, env)
+		if result == BREAK {
+			return NULL
+		}
+		if isError(result) || (result != nil && result.Type() == object.RETURN_VALUE_OBJ) {
+			return result
+		}
+	}
+}
+
 func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
 	condition := Eval(ie.Condition, env)
+	if isUnwinding(condition) {
+		return condition
+	}
 	if isTruthy(condition) {
 		return Eval(ie.Consequence, env)
 	}
@@ -249,6 +556,28 @@ func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Obje
 	return NULL
 }
 
+// evalLogicalExpression evaluates && and || to a boolean, leaving out the
+// right operand when the left one decides the result
+func evalLogicalExpression(node *ast.InfixExpression, env *object.Environment) object.Object {
+	left := Eval(node.Left, env)
+	if isUnwinding(left) {
+		return left
+	}
+
+	if node.Operator == "&&" && !isTruthy(left) {
+		return FALSE
+	}
+	if node.Operator == "||" && isTruthy(left) {
+		return TRUE
+	}
+
+	right := Eval(node.Right, env)
+	if isUnwinding(right) {
+		return right
+	}
+	return nativeBoolToBooleanObject(isTruthy(right))
+}
+
 func isTruthy(obj object.Object) bool {
 	switch obj {
 	case NULL:
@@ -263,19 +592,25 @@ func isTruthy(obj object.Object) bool {
 
 }
 
-func evalInfixExpression(operator string, left object.Object, right object.Object) object.Object {
+func evalInfixExpression(operator string, left object.Object, right object.Object, env *object.Environment) object.Object {
+
+	if left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ {
+		return evalIntegerInfixExpression(operator, left, right)
+	}
+
+	// an integer used along with a float is promoted to a float
+	if isNumber(left) && isNumber(right) {
+		return evalFloatInfixExpression(operator, left, right)
+	}
 
 	if left.Type() != right.Type() {
 		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
 	}
 
 	if left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ {
-		return evalStringInfixExpression(operator, left, right)
+		return evalStringInfixExpression(operator, left, right, env)
 	}
 
-	if left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ {
-		return evalIntegerInfixExpression(operator, left, right)
-	}
 	switch operator {
 	case "==":
 		return nativeBoolToBooleanObject(left == right)
@@ -285,10 +620,16 @@ func evalInfixExpression(operator string, left object.Object, right object.Objec
 	return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 }
 
-func evalStringInfixExpression(operator string, left object.Object, right object.Object) object.Object {
+// evalStringInfixExpression charges the concatenation of two strings
+// before making it, as repeated concatenation grows strings quickly
+func evalStringInfixExpression(operator string, left object.Object, right object.Object, env *object.Environment) object.Object {
 	leftObj, rightObj := left.(*object.String), right.(*object.String)
 	switch operator {
 	case "+":
+		length := int64(len(leftObj.Value) + len(rightObj.Value))
+		if err := env.State().Allocate(object.StringSize(length)); err != nil {
+			return err
+		}
 		return &object.String{Value: leftObj.Value + rightObj.Value}
 	default:
 		return newError(fmt.Sprintf("operator not supported: %s %s %s ", leftObj.Type(), operator, rightObj.Type()))
@@ -303,13 +644,25 @@ func evalIntegerInfixExpression(operator string, left object.Object, right objec
 	case "+":
 		return &object.Integer{Value: leftObject.Value + rightObject.Value}
 	case "/":
+		if rightObject.Value == 0 {
+			return newError("division by zero")
+		}
 		return &object.Integer{Value: leftObject.Value / rightObject.Value}
 	case "*":
 		return &object.Integer{Value: leftObject.Value * rightObject.Value}
+	case "%":
+		if rightObject.Value == 0 {
+			return newError("division by zero")
+		}
+		return &object.Integer{Value: leftObject.Value % rightObject.Value}
 	case ">":
 		return nativeBoolToBooleanObject(leftObject.Value > rightObject.Value)
 	case "<":
 		return nativeBoolToBooleanObject(leftObject.Value < rightObject.Value)
+	case ">=":
+		return nativeBoolToBooleanObject(leftObject.Value >= rightObject.Value)
+	case "<=":
+		return nativeBoolToBooleanObject(leftObject.Value <= rightObject.Value)
 	case "==":
 		return nativeBoolToBooleanObject(leftObject.Value == rightObject.Value)
 	case "!=":
@@ -319,6 +672,49 @@ func evalIntegerInfixExpression(operator string, left object.Object, right objec
 	}
 }
 
+func evalFloatInfixExpression(operator string, left object.Object, right object.Object) object.Object {
+	leftValue, rightValue := toFloat(left), toFloat(right)
+	switch operator {
+	case "-":
+		return &object.Float{Value: leftValue - rightValue}
+	case "+":
+		return &object.Float{Value: leftValue + rightValue}
+	case "/":
+		if rightValue == 0 {
+			return newError("division by zero")
+		}
+		return &object.Float{Value: leftValue / rightValue}
+	case "*":
+		return &object.Float{Value: leftValue * rightValue}
+	case ">":
+		return nativeBoolToBooleanObject(leftValue > rightValue)
+	case "<":
+		return nativeBoolToBooleanObject(leftValue < rightValue)
+	case ">=":
+		return nativeBoolToBooleanObject(leftValue >= rightValue)
+	case "<=":
+		return nativeBoolToBooleanObject(leftValue <= rightValue)
+	case "==":
+		return nativeBoolToBooleanObject(leftValue == rightValue)
+	case "!=":
+		return nativeBoolToBooleanObject(leftValue != rightValue)
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+func isNumber(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.FLOAT_OBJ
+}
+
+// toFloat returns the value of an Integer or Float as a float64
+func toFloat(obj object.Object) float64 {
+	if integer, ok := obj.(*object.Integer); ok {
+		return float64(integer.Value)
+	}
+	return obj.(*object.Float).Value
+}
+
 func nativeBoolToBooleanObject(input bool) object.Object {
 	if input {
 		return TRUE
@@ -367,6 +763,9 @@ func evalBangOperator(operand object.Object) object.Object {
 }
 
 func evalMinusPrefixOperator(operand object.Object) object.Object {
+	if float, ok := operand.(*object.Float); ok {
+		return &object.Float{Value: -float.Value}
+	}
 	if operand.Type() != object.INTEGER_OBJ {
 		return newError("unknown operator: -%s", operand.Type())
 	}
@@ -1,9 +1,14 @@
 package evaluator
 
 import (
+	"context"
+	"errors"
+	"interpreters/ast"
+	"interpreters/compiler"
 	"interpreters/lexer"
 	"interpreters/object"
 	"interpreters/parser"
+	"interpreters/vm"
 	"testing"
 )
 
@@ -47,11 +52,79 @@ func TestEvalIntegerExpression(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		testIntegerObject(t, evaluated, tt.expected)
 	}
 }
 
+func TestEvalFloatExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"3.5", 3.5},
+		{"-2.5", -2.5},
+		{"1.5 + 1.5", 3.0},
+		{"1 + 0.5", 1.5},
+		{"10 / 4.0", 2.5},
+		{"10 / 4", 2},
+		{"0.1 * 3 > 0.3", true},
+		{"1 == 1.0", true},
+		{"2.5 < 2", false},
+		{"1.5 != 1.5", false},
+		{"let x = 1; x += 0.25; x", 1.25},
+		{"1 / 0", errors.New("division by zero")},
+		{"1.5 / 0", errors.New("division by zero")},
+		{"1.5 + true", errors.New("type mismatch: FLOAT + BOOLEAN")},
+		{"float(3)", 3.0},
+		{`float("2.5")`, 2.5},
+		{`float("abc")`, errors.New(`could not parse "abc" as float`)},
+		{"int(3.99)", 3},
+		{"int(-3.99)", -3},
+		{`int("42")`, 42},
+		{"round(2.5)", 3},
+		{"round(-2.5)", -3},
+		{"round(3.14159, 2)", 3.14},
+		{"floor(2.7)", 2},
+		{"floor(-2.2)", -3},
+		{"ceil(2.1)", 3},
+		{"ceil(5)", 5},
+		{`ceil("5")`, errors.New("argument to `ceil` not supported, got STRING_OBJ")},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case float64:
+			testFloatObject(t, evaluated, expected)
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case error:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("%q: wanted error, got: %T", tt.input, evaluated)
+			} else if errObj.Message != expected.Error() {
+				t.Errorf("%q: error message does not match. got: %s, want: %s", tt.input, errObj.Message, expected)
+			}
+		}
+	}
+}
+
+func testFloatObject(t *testing.T, obj object.Object, expected float64) bool {
+	result, ok := obj.(*object.Float)
+	if !ok {
+		t.Errorf("object is not Float, got: %T (%+v)", obj, obj)
+		return false
+	}
+	if result.Value != expected {
+		t.Errorf("object has wrong value. got: %g, want: %g", result.Value, expected)
+		return false
+	}
+	return true
+}
+
 func TestEvalBooleanExpression(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -88,11 +161,61 @@ func TestEvalBooleanExpression(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		testBooleanObject(t, evaluated, tt.expected)
 	}
 }
 
+func TestComparisonAndLogicalOperators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"1 <= 2", true},
+		{"2 <= 2", true},
+		{"3 <= 2", false},
+		{"1 >= 2", false},
+		{"2 >= 2", true},
+		{"2.5 >= 2", true},
+		{"1 <= 0.5", false},
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"6 % 3 == 0", true},
+		{"1 % 0", errors.New("division by zero")},
+		{"7.5 % 2", errors.New("unknown operator: FLOAT % INTEGER")},
+		{"true && true", true},
+		{"true && false", false},
+		{"1 && 0", true},
+		{"false || true", true},
+		{"false || false", false},
+		{"1 < 2 && 2 < 3", true},
+		{"1 > 2 || 2 > 3", false},
+		// the right operand is not evaluated when the left one decides
+		{"false && undefined", false},
+		{"true || 1 + true", true},
+		{"let x = 0; let f = fn() { x = 1; true }; false && f(); x", 0},
+		{"let x = 0; let f = fn() { x = 1; true }; true && f(); x", 1},
+		{"true && undefined", errors.New("identifier not found: undefined")},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case error:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("%q: wanted error, got: %T", tt.input, evaluated)
+			} else if errObj.Message != expected.Error() {
+				t.Errorf("%q: error message does not match. got: %s, want: %s", tt.input, errObj.Message, expected)
+			}
+		}
+	}
+}
+
 func TestBangOperator(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -106,7 +229,7 @@ func TestBangOperator(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		testBooleanObject(t, evaluated, tt.expected)
 	}
 }
@@ -140,7 +263,7 @@ func TestIfElseExpressions(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		integer, ok := tt.expected.(int)
 		if ok {
 			testIntegerObject(t, evaluated, int64(integer))
@@ -188,7 +311,7 @@ if (10 > 1) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		testIntegerObject(t, evaluated, tt.expected)
 	}
 }
@@ -214,10 +337,18 @@ func TestErrorHandling(t *testing.T) {
 			input:    "foobar",
 			expected: "identifier not found: foobar",
 		},
+		{
+			input:    "let f = fn(x) { x }; f();",
+			expected: "wrong number of arguments: want=1, got=0",
+		},
+		{
+			input:    "let f = fn(x) { x + true }; f(1) + 2;",
+			expected: "type mismatch: INTEGER + BOOLEAN",
+		},
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		errObj, ok := evaluated.(*object.Error)
 		if !ok {
 			t.Errorf("No error returned but we need errors!, got: %v, want: %v", evaluated, tt.expected)
@@ -252,7 +383,7 @@ func TestLetStatements(t *testing.T) {
 		},
 	}
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		testIntegerObject(t, evaluated, tt.expected)
 	}
 }
@@ -260,7 +391,7 @@ func TestLetStatements(t *testing.T) {
 func TestFunctionObject(t *testing.T) {
 	input := "fn(x) { x  + 10; }"
 
-	evaluated := testEval(input)
+	evaluated := testEval(t, input)
 	obj, ok := evaluated.(*object.Function)
 	if !ok {
 		t.Fatalf("could not evaluate at Function Object. got: %T, want: %T", evaluated, &object.Function{})
@@ -282,40 +413,44 @@ func TestFunctionApplication(t *testing.T) {
 		expected int64
 	}{
 		{
-			input:    "{let identity = fn(x) { x }; identity(5);",
+			input:    "let identity = fn(x) { x }; identity(5);",
 			expected: 5,
 		},
 		{
-			input:    "{let identity = fn(x) { return x }; identity(5);",
+			input:    "let identity = fn(x) { return x }; identity(5);",
 			expected: 5,
 		},
 		{
-			input:    "{let double = fn(x) { x * 2}; double(5);",
+			input:    "let double = fn(x) { x * 2}; double(5);",
 			expected: 10,
 		},
 		{
-			input:    "{let add = fn(x, y) { x + y}; add(5,5);",
+			input:    "let add = fn(x, y) { x + y}; add(5,5);",
 			expected: 10,
 		},
 		{
-			input:    "{let add = fn(x, y) { x + y}; add(5+5, add(5,5));",
+			input:    "let add = fn(x, y) { x + y}; add(5+5, add(5,5));",
 			expected: 20,
 		},
 		{
-			input:    "{fn(x, y) { x + y; }(5,5);",
+			input:    "fn(x, y) { x + y; }(5,5);",
 			expected: 10,
 		},
+		{
+			input:    "let f = fn() { let g = fn() { h() }; let h = fn() { 1 }; g() }; f()",
+			expected: 1,
+		},
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		testIntegerObject(t, evaluated, tt.expected)
 	}
 }
 
 func TestStringConcatenation(t *testing.T) {
 	input := `"Hello" + " " + "World!"`
-	evaluated := testEval(input)
+	evaluated := testEval(t, input)
 
 	str, ok := evaluated.(*object.String)
 	if !ok {
@@ -348,7 +483,7 @@ func TestBuiltinFunctions(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		switch expected := tt.expected.(type) {
 		case int:
 			testIntegerObject(t, evaluated, int64(expected))
@@ -364,10 +499,443 @@ func TestBuiltinFunctions(t *testing.T) {
 	}
 }
 
+func TestArrayBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"len([1, 2, 3])", "3"},
+		{`len({"a": 1})`, "1"},
+		{"len(true)", "error: argument to `len` not supported, got BOOLEAN"},
+		{"first([1, 2, 3])", "1"},
+		{"first([])", "null"},
+		{"first(1)", "error: argument to `first` must be ARRAY, got INTEGER"},
+		{"last([1, 2, 3])", "3"},
+		{"last([])", "null"},
+		{"rest([1, 2, 3])", "[2,3]"},
+		{"rest([])", "null"},
+		{"push([1], 2, 3)", "[1,2,3]"},
+		{"let a = [1]; push(a, 2); a", "[1]"},
+		{"push([1])", "error: wrong number of arguments. got=1 want=2 or more"},
+		{"pop([1, 2, 3])", "[1,2]"},
+		{"let a = [0, 1]; a[0] = a; a", "[[...],1]"},
+		{`let h = {"n": 1}; h["self"] = h; [h, h]`, "[{n : 1,self : {...}},{n : 1,self : {...}}]"},
+		{"let a = [0]; a[0] = a; let b = [0]; b[0] = b; [index_of([a], b), contains(a, a), a == b]", "[0,true,false]"},
+		{"pop([])", "null"},
+		{"concat([1], [], [2, 3])", "[1,2,3]"},
+		{"concat([1], 2)", "error: argument to `concat` must be ARRAY, got INTEGER"},
+		{"slice([1, 2, 3, 4], 1)", "[2,3,4]"},
+		{"slice([1, 2, 3, 4], 1, 3)", "[2,3]"},
+		{"slice([1, 2, 3, 4], -2)", "[3,4]"},
+		{"slice([1, 2, 3, 4], 3, 1)", "[]"},
+		{"slice([1, 2, 3, 4], 0, 10)", "[1,2,3,4]"},
+		{`slice([1], "a")`, "error: slice bounds must be INTEGER, got STRING_OBJ"},
+		{"reverse([1, 2, 3])", "[3,2,1]"},
+		{"contains([1, 2, 3], 2)", "true"},
+		{"contains([1, [2]], [2])", "true"},
+		{"contains([1, 2, 3], 2.0)", "true"},
+		{`contains(["a"], "b")`, "false"},
+		{`index_of([1, "a", true], true)`, "2"},
+		{"index_of([1, 2], 3)", "-1"},
+		{`join([1, "a", true], ", ")`, "1, a, true"},
+		{`join([], ",")`, ""},
+		{"join([1], 1)", "error: second argument to `join` must be STRING, got INTEGER"},
+		{"sort([3, 1.5, 2])", "[1.5,2,3]"},
+		{`sort(["b", "c", "a"])`, "[a,b,c]"},
+		{"let a = [2, 1]; sort(a); a", "[2,1]"},
+		{"sort([3, 1, 2], fn(a, b) { a > b })", "[3,2,1]"},
+		{"sort([[2, 1], [1, 2]], fn(a, b) { a[1] < b[1] })", "[[2,1],[1,2]]"},
+		{`sort([1, "a"])`, "error: cannot sort a mix of numbers and strings without a comparator"},
+		{"sort([[1]])", "error: cannot sort ARRAY without a comparator"},
+		{"sort([2, 1], fn(a, b) { 1 })", "error: comparator of `sort` must return BOOLEAN, got INTEGER"},
+		{"sort([2, 1], fn(a, b) { a + true })", "error: type mismatch: INTEGER + BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		actual := evaluated.Inspect()
+		if errObj, ok := evaluated.(*object.Error); ok {
+			actual = "error: " + errObj.Message
+		}
+		if actual != tt.expected {
+			t.Errorf("%q: wrong result. expected=%q, got=%q", tt.input, tt.expected, actual)
+		}
+	}
+}
+
+func TestHigherOrderBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"map([1, 2, 3], fn(x) { x * 2 })", "[2,4,6]"},
+		{"map(range(3), fn(x) { x + 1 })", "[1,2,3]"},
+		{`map("ab", fn(c) { c + c })`, "[aa,bb]"},
+		{"map([], fn(x) { x })", "[]"},
+		{`map(["a"], len)`, "[1]"},
+		{"map([1], 2)", "error: second argument to `map` must be a function, got INTEGER"},
+		{"map(1, fn(x) { x })", "error: argument to `map` must be iterable, got INTEGER"},
+		{"map([1, 2], fn(x) { x + true })", "error: type mismatch: INTEGER + BOOLEAN"},
+		{"map([1], fn(x, y) { x })", "error: wrong number of arguments: want=2, got=1"},
+		{"filter(range(6), fn(x) { x % 2 == 0 })", "[0,2,4]"},
+		{"filter([1, if (false) { 1 }, false, 0], fn(x) { x })", "[1,0]"},
+		{"reduce([1, 2, 3], fn(acc, x) { acc + x })", "6"},
+		{"reduce([1, 2, 3], fn(acc, x) { acc + x }, 10)", "16"},
+		{"reduce([], fn(acc, x) { acc + x })", "null"},
+		{"reduce([], fn(acc, x) { acc + x }, 0)", "0"},
+		{"let total = 0; each([1, 2, 3], fn(x) { total = total + x }); total", "6"},
+		{"each([1], fn(x) { x })", "null"},
+		{"any([1, 2, 3], fn(x) { x > 2 })", "true"},
+		{"any([], fn(x) { true })", "false"},
+		{"any([1, true], fn(x) { x + 1 > 1 })", "true"},
+		{"all([1, 2, 3], fn(x) { x > 0 })", "true"},
+		{"all([1, 2, 3], fn(x) { x > 1 })", "false"},
+		{"all([], fn(x) { false })", "true"},
+		{"find([1, 2, 3], fn(x) { x > 1 })", "2"},
+		{"find([1, 2, 3], fn(x) { x > 3 })", "null"},
+		{`zip([1, 2, 3], ["a", "b"])`, "[[1,a],[2,b]]"},
+		{"zip(range(2), [true, false], [3, 4])", "[[0,true,3],[1,false,4]]"},
+		{"zip()", "error: wrong number of arguments. got=0 want=1 or more"},
+		{`zip(range(5), "ab")`, "[[0,a],[1,b]]"},
+		{"len(zip(range(1000000000)))", "error: result of `zip` is too large"},
+		{"zip(range(9223372036854775800, 9223372036854775807, 5))", "[[9223372036854775800],[9223372036854775805]]"},
+		{"reduce(range(1, 4), fn(a, x) { a * x })", "6"},
+		{`group_by([1, 2, 3, 4], fn(x) { x % 2 == 0 })[true]`, "[2,4]"},
+		{`len(group_by(["a", "bb", "cc"], len))`, "2"},
+		{"group_by([1], fn(x) { [x] })", "error: unusable as hash key: ARRAY"},
+		{"flat_map([1, 2], fn(x) { [x, x * 10] })", "[1,10,2,20]"},
+		{"flat_map([1], fn(x) { x })", "error: function passed to `flat_map` must return ARRAY, got INTEGER"},
+		{"len(map(range(100000), fn(x) { x }))", "100000"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		actual := evaluated.Inspect()
+		if errObj, ok := evaluated.(*object.Error); ok {
+			actual = "error: " + errObj.Message
+		}
+		if actual != tt.expected {
+			t.Errorf("%q: wrong result. expected=%q, got=%q", tt.input, tt.expected, actual)
+		}
+	}
+}
+
+func TestHashBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`keys({"b": 1, "a": 2, 3: 3, 1: 4})`, "[b,a,3,1]"},
+		{`values({"b": 1, "a": 2})`, "[1,2]"},
+		{`items({"b": 1, "a": 2})`, "[[b,1],[a,2]]"},
+		{"keys({})", "[]"},
+		{"keys([1])", "error: argument to `keys` must be HASH, got ARRAY"},
+		{`has({"a": 1}, "a")`, "true"},
+		{`has({"a": 1}, "b")`, "false"},
+		{`has({"a": 1}, [])`, "error: unusable as hash key: ARRAY"},
+		{`delete({"a": 1, "b": 2}, "a")`, "{b : 2}"},
+		{`delete({"a": 1}, "b")`, "{a : 1}"},
+		{`let h = {"a": 1}; delete(h, "a"); h`, "{a : 1}"},
+		{`merge({"a": 1, "b": 2}, {"b": 3}, {"c": 4})`, "{a : 1,b : 3,c : 4}"},
+		{`merge({"b": 1}, {"a": 2, "b": 3})`, "{b : 3,a : 2}"},
+		{`let h = {"b": 1, "a": 2}; h["c"] = 3; h["b"] = 4; h`, "{b : 4,a : 2,c : 3}"},
+		{`group_by(["bb", "a", "cc"], len)`, "{2 : [bb,cc],1 : [a]}"},
+		{"merge()", "{}"},
+		{`merge({}, [])`, "error: argument to `merge` must be HASH, got ARRAY"},
+		{`len({"a": 1, "b": 2})`, "2"},
+		{`let out = ""; for (k in {"z": 1, "y": 2, "x": 3}) { out += k }; out`, "zyx"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		actual := evaluated.Inspect()
+		if errObj, ok := evaluated.(*object.Error); ok {
+			actual = "error: " + errObj.Message
+		}
+		if actual != tt.expected {
+			t.Errorf("%q: wrong result. expected=%q, got=%q", tt.input, tt.expected, actual)
+		}
+	}
+}
+
+func TestStringBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"hello"[1]`, "e"},
+		{`"hello"[5]`, "null"},
+		{`"hello"[-1]`, "null"},
+		{`"hello"[1:3]`, "el"},
+		{`"hello"[:-1]`, "hell"},
+		{`"hello"[3:]`, "lo"},
+		{`"hello"[:]`, "hello"},
+		{"[1, 2, 3][1:]", "[2,3]"},
+		{`let a = [1, 2]; let b = a[:]; b[0] = 3; a`, "[1,2]"},
+		{`"hello"[true:]`, "error: slice bounds must be INTEGER, got BOOLEAN"},
+		{`5[1:]`, "error: slice operator not supported: INTEGER"},
+		{`slice("hello", 1, 3)`, "el"},
+		{`split("a,b,,c", ",")`, "[a,b,,c]"},
+		{`split("  a b  c ")`, "[a,b,c]"},
+		{`split("a", 1)`, "error: argument to `split` must be STRING, got INTEGER"},
+		{`trim("  hi	")`, "hi"},
+		{`trim("xxhixx", "x")`, "hi"},
+		{`upper("Hi")`, "HI"},
+		{`lower("Hi")`, "hi"},
+		{`replace("a-b-c", "-", "+")`, "a+b+c"},
+		{`replace("abc", "", "-")`, "-a-b-c-"},
+		{`let s = repeat("a", 40000); replace(s, "a", s)`, "error: result of `replace` is too large"},
+		{`starts_with("monkey", "mon")`, "true"},
+		{`starts_with("monkey", "key")`, "false"},
+		{`ends_with("monkey", "key")`, "true"},
+		{`contains("monkey", "nk")`, "true"},
+		{`contains("monkey", "x")`, "false"},
+		{`contains("monkey", 1)`, "error: second argument to `contains` must be STRING, got INTEGER"},
+		{"contains()", "error: wrong number of arguments. got=0 want=2"},
+		{`repeat("ab", 3)`, "ababab"},
+		{`repeat("ab", -1)`, "error: count of `repeat` must not be negative"},
+		{`pad_left("7", 3)`, "  7"},
+		{`pad_left("7", 3, "0")`, "007"},
+		{`pad_right("ab", 5, "-=")`, "ab-=-"},
+		{`pad_right("long", 2)`, "long"},
+		{`pad_left("7", 3, "")`, "error: third argument to `pad_left` must be a non-empty STRING"},
+		{`repeat("ab", 4611686018427387904)`, "error: result of `repeat` is too large"},
+		{`repeat("a", 100000000000)`, "error: result of `repeat` is too large"},
+		{`pad_left("a", 4611686018427387904)`, "error: result of `pad_left` is too large"},
+		{`pad_right("a", 100000000000, "xy")`, "error: result of `pad_right` is too large"},
+		{`chars("abc")`, "[a,b,c]"},
+		{`format("%s is %d years, %v%%", "Tom", 30, [1])`, "Tom is 30 years, [1]%"},
+		{`format("%d", "x")`, "error: %d needs an INTEGER, got STRING_OBJ"},
+		{`format("%s %s", "x")`, "error: not enough values for format: got 1"},
+		{`format("%s", "x", "y")`, "error: too many values for format: got 2, used 1"},
+		{`format("%x", 1)`, "error: unknown format verb %x"},
+		{`format("100%")`, "error: format ends with a lone %"},
+		{`len("héllo")`, "5"},
+		{`bytes_len("héllo")`, "6"},
+		{`"héllo"[1]`, "é"},
+		{`"日本語"[2]`, "語"},
+		{`"日本語"[3]`, "null"},
+		{`"héllo"[1:3]`, "él"},
+		{`"héllo"[-2:]`, "lo"},
+		{`chars("añb")`, "[a,ñ,b]"},
+		{`pad_left("é", 3, "·")`, "··é"},
+		{`let café = "☕"; café`, "☕"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		actual := evaluated.Inspect()
+		if errObj, ok := evaluated.(*object.Error); ok {
+			actual = "error: " + errObj.Message
+		}
+		if actual != tt.expected {
+			t.Errorf("%q: wrong result. expected=%q, got=%q", tt.input, tt.expected, actual)
+		}
+	}
+}
+
+func TestStringInterpolation(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let name = "Ann"; "Hello ${name}!"`, "Hello Ann!"},
+		{`let items = [1, 2]; "${len(items)} items: ${items}"`, "2 items: [1,2]"},
+		{`"${1 + 1.5} ${true} ${if (false) { 1 }} ${{"a": 1}}"`, "2.5 true null {a : 1}"},
+		{`"${1}${2}"`, "12"},
+		{`"a ${"b ${"c"} d"} e"`, "a b c d e"},
+		{`"${ {"k": "v"}["k"] }"`, "v"},
+		{`"\${name}"`, "${name}"},
+		{"`${name}`", "${name}"},
+		{`let f = fn(x) { "<${x}>" }; map([1, 2], f)`, "[<1>,<2>]"},
+		{`"a ${1 + true} b"`, "error: type mismatch: INTEGER + BOOLEAN"},
+		{`"${missing}"`, "error: identifier not found: missing"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		actual := evaluated.Inspect()
+		if errObj, ok := evaluated.(*object.Error); ok {
+			actual = "error: " + errObj.Message
+		}
+		if actual != tt.expected {
+			t.Errorf("%q: wrong result. expected=%q, got=%q", tt.input, tt.expected, actual)
+		}
+	}
+}
+
+func TestLoops(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{
+			input:    "let i = 0; while (i < 5) { let i = i + 1; }; i",
+			expected: 5,
+		},
+		{
+			input:    "while (false) { 1 }",
+			expected: nil,
+		},
+		{
+			input:    "let i = 0; while (true) { let i = i + 1; if (i == 3) { break; } }; i",
+			expected: 3,
+		},
+		{
+			input:    "let s = 0; for (x in [1, 2, 3, 4]) { if (x == 2) { continue; } let s = s + x; }; s",
+			expected: 8,
+		},
+		{
+			input:    "let s = 0; for (i in range(5)) { let s = s + i; }; s",
+			expected: 10,
+		},
+		{
+			input:    "let s = 0; for (i in range(10, 0, -2)) { let s = s + i; }; s",
+			expected: 30,
+		},
+		{
+			input:    "let n = 0; for (i in range(9223372036854775800, 9223372036854775807, 5)) { let n = n + 1; }; n",
+			expected: 2,
+		},
+		{
+			input:    "let n = 0; for (i in range(-9223372036854775800, -9223372036854775807, -5)) { let n = n + 1; }; n",
+			expected: 2,
+		},
+		{
+			input:    `let s = ""; for (k in {"b": 1, "a": 2}) { let s = s + k; }; s`,
+			expected: "ba",
+		},
+		{
+			input:    `let s = ""; for (c in "abc") { let s = c + s; }; s`,
+			expected: "cba",
+		},
+		{
+			input:    "let f = fn() { for (x in [1, 2, 3]) { if (x == 2) { return x * 10; } } }; f()",
+			expected: 20,
+		},
+		{
+			input:    "let n = 0; for (i in range(3)) { for (j in range(3)) { if (j == 1) { break; } let n = n + 1; } }; n",
+			expected: 3,
+		},
+		{
+			input:    "let s = 0; for (x in [1, 2, 3]) { s = s + (1 + if (x == 1) { continue } else { x }) }; s",
+			expected: 7,
+		},
+		{
+			input:    "let s = 0; for (x in [1, 2, 3]) { let y = if (x == 2) { continue } else { x }; s = s + y }; s",
+			expected: 4,
+		},
+		{
+			input:    "let r = []; for (x in [1, 2, 3]) { r = push(r, if (x == 2) { continue } else { x }) }; r[0] + r[1]",
+			expected: 4,
+		},
+		{
+			input:    "let s = 0; for (x in [1, 2, 3]) { s = s + if (x == 2) { break } else { x } }; s",
+			expected: 1,
+		},
+		{
+			input:    "let i = 0; let s = 0; while (i < 3) { i = i + 1; s = s + -if (i == 2) { continue } else { i } }; s",
+			expected: -4,
+		},
+		{
+			input:    `let s = 0; for (x in [1, 2, 3]) { let h = {"x": if (x == 2) { continue } else { x }}; s = s + h["x"] }; s`,
+			expected: 4,
+		},
+		{
+			input:    "let f = fn() { 1 + if (true) { return 5 } }; f()",
+			expected: 5,
+		},
+		{
+			input:    "for (x in 5) { x }",
+			expected: errors.New("not iterable: INTEGER"),
+		},
+		{
+			input:    "while (1 + true) { 1 }",
+			expected: errors.New("type mismatch: INTEGER + BOOLEAN"),
+		},
+		{
+			input:    "range(1, 2, 0)",
+			expected: errors.New("step of `range` must not be zero"),
+		},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok {
+				t.Errorf("%q: object is not String. got: %T (%+v)", tt.input, evaluated, evaluated)
+			} else if str.Value != expected {
+				t.Errorf("%q: String has wrong value. want: %q, got: %q", tt.input, expected, str.Value)
+			}
+		case error:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("%q: wanted error, got: %T", tt.input, evaluated)
+			} else if errObj.Message != expected.Error() {
+				t.Errorf("%q: error message does not match. got: %s, want: %s", tt.input, errObj.Message, expected)
+			}
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestAssignExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let x = 1; x = 2; x", 2},
+		{"let x = 1; x = x + 1", 2},
+		{"let a = 0; let b = 0; a = b = 3; a + b", 6},
+		{"let x = 10; x += 5; x -= 3; x *= 2; x /= 4; x", 6},
+		{"let count = 0; let inc = fn() { count += 1 }; inc(); inc(); count", 2},
+		{"let x = 1; let f = fn() { let x = 5; x = 6; x }; f() + x", 7},
+		{"let counter = fn() { let n = 0; fn() { n = n + 1 } }; let c = counter(); c(); c()", 2},
+		{"let arr = [1, 2, 3]; arr[1] = 20; arr[1] + arr[2]", 23},
+		{"let arr = [1, 2, 3]; arr[2] *= 3; arr[2]", 9},
+		{`let h = {"a": 1}; h["b"] = 2; h["a"] += h["b"]; h["a"]`, 3},
+		{`let s = "a"; s += "b"; s`, "ab"},
+		{"x = 1", errors.New("identifier not found: x")},
+		{"len = 1", errors.New("cannot assign to builtin: len")},
+		{"let x = 1; x += true", errors.New("type mismatch: INTEGER + BOOLEAN")},
+		{"[1, 2][2] = 1", errors.New("index out of range: 2")},
+		{"let a = [1]; a[-1] += 1", errors.New("type mismatch: NULL + INTEGER")},
+		{`{"a": 1}[[]] = 1`, errors.New("unusable as hash key: ARRAY")},
+		{`"abc"[0] = "x"`, errors.New("index assignment not supported: STRING_OBJ")},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok {
+				t.Errorf("%q: object is not String. got: %T (%+v)", tt.input, evaluated, evaluated)
+			} else if str.Value != expected {
+				t.Errorf("%q: String has wrong value. want: %q, got: %q", tt.input, expected, str.Value)
+			}
+		case error:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("%q: wanted error, got: %T", tt.input, evaluated)
+			} else if errObj.Message != expected.Error() {
+				t.Errorf("%q: error message does not match. got: %s, want: %s", tt.input, errObj.Message, expected)
+			}
+		}
+	}
+}
+
 func TestArrayLiterals(t *testing.T) {
 	input := "[1, 2 * 2, 3 + 3]"
 
-	evaluated := testEval(input)
+	evaluated := testEval(t, input)
 	result, ok := evaluated.(*object.Array)
 	if !ok {
 		t.Fatalf("object is not array. got %T, +%v", evaluated, evaluated)
@@ -392,7 +960,7 @@ two: 1 + 1,
 true: 5,
 	}`
 
-	evaluated := testEval(input)
+	evaluated := testEval(t, input)
 	result, ok := evaluated.(*object.Hash)
 	if !ok {
 		t.Fatalf("evaluated is not ast.HashLiteral, got: %T (%+v)", evaluated, evaluated)
@@ -437,7 +1005,7 @@ func TestHashIndexExpressions(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		integer, ok := tt.expected.(int)
 
 		if ok {
@@ -448,14 +1016,50 @@ func TestHashIndexExpressions(t *testing.T) {
 	}
 }
 
-func testEval(input string) object.Object {
+// testEval evaluates input, and runs it on the vm too so that every table
+// of this file checks that the engines agree
+func testEval(t *testing.T, input string) object.Object {
+	t.Helper()
+
 	l := lexer.New(input)
 	p := parser.New(l)
 	env := object.NewEnvironment()
 
 	program := p.ParseProgram()
 
-	return Eval(program, env)
+	evaluated := Eval(program, env)
+	if len(p.Errors()) == 0 {
+		if actual := runVM(program); describe(actual) != describe(evaluated) {
+			t.Errorf("%q: evaluator and vm disagree. evaluator: %s, vm: %s", input, describe(evaluated), describe(actual))
+		}
+	}
+	return evaluated
+}
+
+// runVM compiles and runs program, returning its value or its error
+func runVM(program *ast.Program) object.Object {
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		return &object.Error{Message: err.Error()}
+	}
+
+	machine := vm.New(comp.Bytecode())
+	if err := machine.Run(); err != nil {
+		return err.(*object.Error)
+	}
+	return machine.LastPoppedStackElem()
+}
+
+// describe returns the type and Inspect form of obj, except for functions
+// which the engines represent differently
+func describe(obj object.Object) string {
+	switch obj.(type) {
+	case nil:
+		return "<nil>"
+	case *object.Function, *object.Closure:
+		return "function"
+	}
+	return string(obj.Type()) + " " + obj.Inspect()
 }
 
 func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
@@ -498,3 +1102,91 @@ func testBooleanObject(t *testing.T, obj object.Object, expected bool) bool {
 
 	return true
 }
+
+func TestErrorStack(t *testing.T) {
+	input := `let inner = fn(x) {
+  x + true
+};
+let outer = fn(x) {
+  inner(x)
+};
+fn() { outer(1) }();`
+
+	evaluated := testEval(t, input)
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("expected object.Error, got: %T (%+v)", evaluated, evaluated)
+	}
+
+	if errObj.Pos.String() != "2:3" {
+		t.Errorf("errObj.Pos wrong. want: %s, got: %s", "2:3", errObj.Pos)
+	}
+
+	expected := []struct {
+		function string
+		pos      string
+	}{
+		{"inner", "5:3"},
+		{"outer", "7:8"},
+		{"<anonymous>", "7:1"},
+	}
+
+	if len(errObj.Stack) != len(expected) {
+		t.Fatalf("len(errObj.Stack) wrong. want: %d, got: %d", len(expected), len(errObj.Stack))
+	}
+
+	for i, frame := range expected {
+		if errObj.Stack[i].Function != frame.function {
+			t.Errorf("errObj.Stack[%d].Function wrong. want: %s, got: %s", i, frame.function, errObj.Stack[i].Function)
+		}
+		if errObj.Stack[i].Pos.String() != frame.pos {
+			t.Errorf("errObj.Stack[%d].Pos wrong. want: %s, got: %s", i, frame.pos, errObj.Stack[i].Pos)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		input    string
+		ctx      context.Context
+		limits   object.Limits
+		cause    error
+		expected string
+	}{
+		{"while (true) {}", context.Background(), object.Limits{MaxSteps: 1000}, object.ErrStepLimit, "step limit exceeded: 1000 steps"},
+		{"let f = fn(n) { f(n + 1) }; f(0)", context.Background(), object.Limits{MaxDepth: 50}, object.ErrDepthLimit, "maximum call depth exceeded: 50 calls"},
+		{"let f = fn() { f() }; f()", context.Background(), object.Limits{}, object.ErrDepthLimit, "maximum call depth exceeded: 1000 calls"},
+		{`let s = ""; while (true) { s += "abcdefgh" }`, context.Background(), object.Limits{MaxAllocation: 1 << 16}, object.ErrAllocationLimit, "allocation limit exceeded: 65536 bytes"},
+		{"while (true) { [1, 2, 3] }", context.Background(), object.Limits{MaxAllocation: 1 << 16}, object.ErrAllocationLimit, "allocation limit exceeded: 65536 bytes"},
+		{`let s = "abcdefgh"; while (true) { s = s + s }`, context.Background(), object.Limits{MaxAllocation: 1 << 16}, object.ErrAllocationLimit, "allocation limit exceeded: 65536 bytes"},
+		{`repeat("a", 1000000)`, context.Background(), object.Limits{MaxAllocation: 1 << 16}, object.ErrAllocationLimit, "allocation limit exceeded: 65536 bytes"},
+		{`pad_left("a", 1000000)`, context.Background(), object.Limits{MaxAllocation: 1 << 16}, object.ErrAllocationLimit, "allocation limit exceeded: 65536 bytes"},
+		{"len(zip(range(10000000)))", context.Background(), object.Limits{MaxSteps: 1000, MaxAllocation: 1 << 16}, object.ErrAllocationLimit, "allocation limit exceeded: 65536 bytes"},
+		{"len(map(range(1000000000), float))", context.Background(), object.Limits{MaxSteps: 1000}, object.ErrStepLimit, "step limit exceeded: 1000 steps"},
+		{"reduce(range(1000000000), fn(a, x) { a + x })", context.Background(), object.Limits{MaxSteps: 1000}, object.ErrStepLimit, "step limit exceeded: 1000 steps"},
+		{`let s = repeat("a", 1000); replace(s, "a", s)`, context.Background(), object.Limits{MaxAllocation: 1 << 16}, object.ErrAllocationLimit, "allocation limit exceeded: 65536 bytes"},
+		{`join([1, 2, 3], repeat("-", 40000))`, context.Background(), object.Limits{MaxAllocation: 1 << 16}, object.ErrAllocationLimit, "allocation limit exceeded: 65536 bytes"},
+		{"let a = [1, 2, 3, 4, 5, 6, 7, 8]; let b = concat(a, a, a, a, a, a, a, a); let c = concat(b, b, b, b, b, b, b, b); concat(c, c, c, c, c, c, c, c)", context.Background(), object.Limits{MaxAllocation: 1 << 16}, object.ErrAllocationLimit, "allocation limit exceeded: 65536 bytes"},
+		{"while (true) {}", canceled, object.Limits{}, context.Canceled, "evaluation stopped: context canceled"},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		env := object.NewEnvironment()
+		env.SetState(object.NewState(tt.ctx, tt.limits))
+
+		err, ok := Eval(program, env).(*object.Error)
+		if !ok {
+			t.Fatalf("%q: no error object returned", tt.input)
+		}
+		if err.Message != tt.expected {
+			t.Errorf("%q: wrong error message. expected=%q, got=%q", tt.input, tt.expected, err.Message)
+		}
+		if !errors.Is(err, tt.cause) {
+			t.Errorf("%q: wrong cause. expected=%v, got=%v", tt.input, tt.cause, err.Cause)
+		}
+	}
+}
@@ -1,50 +1,118 @@
 package lexer
 
 import (
+	"fmt"
 	"interpreters/token"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 type Lexer struct {
 	input string
-	// current character being read
-	ch byte
-	// current character position in input string
+	// name of the file being lexed, used in token positions
+	filename string
+	// current character being read, a Unicode code point
+	ch rune
+	// byte offset of the current character in input
 	position int
-	// position after current char
+	// byte offset of the character after the current one
 	readPosition int
+	// line and column of the current character, counting columns in
+	// characters rather than bytes
+	line   int
+	column int
+	// templates holds the depth of braces opened within each ${...} of
+	// a string being lexed, innermost last
+	templates []int
+	// keepComments makes NextToken return COMMENT tokens
+	keepComments bool
 }
 
 // New creates and returns a new instance of Lexer
 func New(input string) *Lexer {
+	return NewFile("", input)
+}
+
+// NewFile creates a Lexer whose token positions refer to the given file name
+func NewFile(filename string, input string) *Lexer {
 	l := &Lexer{
-		input: input,
+		input:    input,
+		filename: filename,
+		line:     1,
 	}
 	l.readChar()
+
+	// a `#!/usr/bin/env monkey` line lets scripts be executed directly
+	if strings.HasPrefix(input, "#!") {
+		for l.ch != '\n' && l.ch != 0 {
+			l.readChar()
+		}
+	}
+
 	return l
 }
 
+// KeepComments makes NextToken return comments as COMMENT tokens instead of
+// skipping them, for tools such as formatters that need to keep them
+func (l *Lexer) KeepComments() {
+	l.keepComments = true
+}
+
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
+	}
+	l.position = l.readPosition
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
-		l.ch = l.input[l.readPosition]
+		// invalid UTF-8 reads as utf8.RuneError, one byte at a time
+		var width int
+		l.ch, width = utf8.DecodeRuneInString(l.input[l.readPosition:])
+		l.readPosition += width
 	}
-	l.position = l.readPosition
-	l.readPosition++
+	l.column++
 }
 
-func (l *Lexer) peekChar() byte {
+// pos returns the position of the current character
+func (l *Lexer) pos() token.Position {
+	return token.Position{
+		Filename: l.filename,
+		Offset:   l.position,
+		Line:     l.line,
+		Column:   l.column,
+	}
+}
+
+func (l *Lexer) peekChar() rune {
 	if l.readPosition >= len(l.input) {
 		return 0
 	}
-	return l.input[l.readPosition]
+	ch, _ := utf8.DecodeRuneInString(l.input[l.readPosition:])
+	return ch
 }
 
 // NextToken returns the token.Token struct for the character being read by our lexer
 func (l *Lexer) NextToken() token.Token {
-	var tok token.Token
+	for {
+		l.skipWhitespace()
 
-	l.skipWhitespace()
+		pos := l.pos()
+		tok := l.readToken()
+		tok.Pos = pos
+		tok.End = l.pos()
+
+		if tok.Type != token.COMMENT || l.keepComments {
+			return tok
+		}
+	}
+}
+
+func (l *Lexer) readToken() token.Token {
+	var tok token.Token
 
 	switch l.ch {
 	case '=':
@@ -63,17 +131,36 @@ func (l *Lexer) NextToken() token.Token {
 	case ')':
 		tok = newToken(token.RPAREN, l.ch)
 	case '{':
+		if n := len(l.templates); n > 0 {
+			l.templates[n-1]++
+		}
 		tok = newToken(token.LBRACE, l.ch)
 	case '}':
+		if n := len(l.templates); n > 0 {
+			if l.templates[n-1] == 0 {
+				// the } closes a ${ and the string carries on
+				l.templates = l.templates[:n-1]
+				return l.readString(token.TEMPLATE_TAIL, token.TEMPLATE_MIDDLE)
+			}
+			l.templates[n-1]--
+		}
 		tok = newToken(token.RBRACE, l.ch)
 	case '[':
 		tok = newToken(token.LBRACKET, l.ch)
 	case ']':
 		tok = newToken(token.RBRACKET, l.ch)
 	case '+':
-		tok = newToken(token.PLUS, l.ch)
+		if l.peekChar() == '=' {
+			tok = l.newTwoCharToken(token.PLUS_ASSIGN)
+		} else {
+			tok = newToken(token.PLUS, l.ch)
+		}
 	case '-':
-		tok = newToken(token.MINUS, l.ch)
+		if l.peekChar() == '=' {
+			tok = l.newTwoCharToken(token.MINUS_ASSIGN)
+		} else {
+			tok = newToken(token.MINUS, l.ch)
+		}
 	case ':':
 		tok = newToken(token.COLON, l.ch)
 	case '!':
@@ -87,21 +174,60 @@ func (l *Lexer) NextToken() token.Token {
 			tok = newToken(token.BANG, l.ch)
 		}
 	case '*':
-		tok = newToken(token.ASTERISK, l.ch)
+		if l.peekChar() == '=' {
+			tok = l.newTwoCharToken(token.ASTERISK_ASSIGN)
+		} else {
+			tok = newToken(token.ASTERISK, l.ch)
+		}
 	case '/':
-		tok = newToken(token.SLASH, l.ch)
+		if l.peekChar() == '/' {
+			return l.readLineComment()
+		}
+		if l.peekChar() == '*' {
+			return l.readBlockComment()
+		}
+		if l.peekChar() == '=' {
+			tok = l.newTwoCharToken(token.SLASH_ASSIGN)
+		} else {
+			tok = newToken(token.SLASH, l.ch)
+		}
 	case '<':
-		tok = newToken(token.LT, l.ch)
+		if l.peekChar() == '=' {
+			tok = l.newTwoCharToken(token.LT_EQ)
+		} else {
+			tok = newToken(token.LT, l.ch)
+		}
 	case '>':
-		tok = newToken(token.GT, l.ch)
+		if l.peekChar() == '=' {
+			tok = l.newTwoCharToken(token.GT_EQ)
+		} else {
+			tok = newToken(token.GT, l.ch)
+		}
+	case '%':
+		tok = newToken(token.PERCENT, l.ch)
+	case '&':
+		if l.peekChar() == '&' {
+			tok = l.newTwoCharToken(token.AND)
+		} else {
+			tok = newToken(token.ILLEGAL, l.ch)
+		}
+	case '|':
+		if l.peekChar() == '|' {
+			tok = l.newTwoCharToken(token.OR)
+		} else {
+			tok = newToken(token.ILLEGAL, l.ch)
+		}
 	case ',':
 		tok = newToken(token.COMMA, l.ch)
 	case '"':
-		tok.Literal = l.readString()
-		tok.Type = token.STRING
+		return l.readString(token.STRING, token.TEMPLATE_HEAD)
+	case '`':
+		return l.readRawString()
 	case 0:
+		// the position is left at the end of the input so that EOF is stable
 		tok.Literal = ""
 		tok.Type = token.EOF
+		return tok
 	default:
 		if isLetter(l.ch) {
 			tok.Literal = l.readIdentifier()
@@ -109,11 +235,10 @@ func (l *Lexer) NextToken() token.Token {
 			return tok
 		}
 		if isDigit(l.ch) {
-			tok.Type = token.INT
-			tok.Literal = l.readNumber()
+			tok.Literal, tok.Type = l.readNumber()
 			return tok
 		}
-		tok = newToken(token.ILLEGAL, l.ch)
+		tok = token.Token{Type: token.ILLEGAL, Literal: l.input[l.position:l.readPosition]}
 	}
 
 	l.readChar()
@@ -128,12 +253,22 @@ func (l *Lexer) readIdentifier() string {
 	return l.input[start:l.position]
 }
 
-func (l *Lexer) readNumber() string {
+// readNumber reads an integer, or a float when the digits are followed by a
+// dot and more digits
+func (l *Lexer) readNumber() (string, token.Type) {
 	start := l.position
 	for isDigit(l.ch) {
 		l.readChar()
 	}
-	return l.input[start:l.position]
+	if l.ch != '.' || !isDigit(l.peekChar()) {
+		return l.input[start:l.position], token.INT
+	}
+
+	l.readChar()
+	for isDigit(l.ch) {
+		l.readChar()
+	}
+	return l.input[start:l.position], token.FLOAT
 }
 
 func (l *Lexer) skipWhitespace() {
@@ -142,25 +277,179 @@ func (l *Lexer) skipWhitespace() {
 	}
 }
 
-func (l *Lexer) readString() string {
+// readString reads the text of a double quoted string from the opening
+// quote, or from the } that ends an embedded expression, and decodes its
+// escape sequences. The text is a token of type end if it runs to the
+// closing quote, or of type embed if it runs to the ${ of an expression.
+// A string may not span lines; use \n or a raw string instead. On a
+// malformed string it returns an ERROR token, after skipping to the end
+// of the text or of the line.
+func (l *Lexer) readString(end token.Type, embed token.Type) token.Token {
+	var out strings.Builder
+	var message string
+
+	l.readChar()
+	tokenType := end
+	for l.ch != '"' {
+		if l.ch == 0 || l.ch == '\n' {
+			return token.Token{Type: token.ERROR, Literal: "unterminated string"}
+		}
+		if l.ch == '$' && l.peekChar() == '{' {
+			l.readChar()
+			l.templates = append(l.templates, 0)
+			tokenType = embed
+			break
+		}
+		if l.ch != '\\' {
+			out.WriteRune(l.ch)
+			l.readChar()
+			continue
+		}
+
+		l.readChar()
+		ch, err := l.readEscape()
+		if err != "" && message == "" {
+			message = err
+		}
+		out.WriteRune(ch)
+	}
+	l.readChar()
+
+	if message != "" {
+		return token.Token{Type: token.ERROR, Literal: message}
+	}
+	return token.Token{Type: tokenType, Literal: out.String()}
+}
+
+// readEscape decodes the escape sequence whose backslash has just been
+// read, leaving the lexer on the character after it. It returns an error
+// message if the sequence is not valid.
+func (l *Lexer) readEscape() (rune, string) {
+	ch := l.ch
+	switch ch {
+	case '"', '\\', '$':
+		l.readChar()
+		return ch, ""
+	case 'n':
+		l.readChar()
+		return '\n', ""
+	case 't':
+		l.readChar()
+		return '\t', ""
+	case 'r':
+		l.readChar()
+		return '\r', ""
+	case 'u':
+		return l.readUnicodeEscape()
+	case 0, '\n':
+		// leave the end of the string for readString to report
+		return 0, ""
+	}
+
+	l.readChar()
+	return 0, fmt.Sprintf("unknown escape sequence \\%c", ch)
+}
+
+// readUnicodeEscape decodes \u{...}, one to six hex digits naming a code
+// point
+func (l *Lexer) readUnicodeEscape() (rune, string) {
+	const malformed = "malformed escape sequence: want \\u{...} with 1 to 6 hex digits"
+
+	l.readChar()
+	if l.ch != '{' {
+		return 0, malformed
+	}
 	l.readChar()
 
 	start := l.position
-	for l.ch != 0 && l.ch != '"' {
+	for isHexDigit(l.ch) {
 		l.readChar()
 	}
-	return l.input[start:l.position]
+	digits := l.input[start:l.position]
+	if l.ch != '}' || len(digits) == 0 || len(digits) > 6 {
+		return 0, malformed
+	}
+	l.readChar()
+
+	value, _ := strconv.ParseUint(digits, 16, 32)
+	if !utf8.ValidRune(rune(value)) {
+		return 0, fmt.Sprintf("invalid code point \\u{%s}", digits)
+	}
+	return rune(value), ""
+}
+
+// readRawString reads a string between backticks, which may span lines and
+// has neither escape sequences nor embedded expressions
+func (l *Lexer) readRawString() token.Token {
+	l.readChar()
+
+	start := l.position
+	for l.ch != '`' {
+		if l.ch == 0 {
+			return token.Token{Type: token.ERROR, Literal: "unterminated raw string"}
+		}
+		l.readChar()
+	}
+	literal := l.input[start:l.position]
+	l.readChar()
+
+	return token.Token{Type: token.STRING, Literal: literal}
 }
 
-func isLetter(ch byte) bool {
-	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
+// readLineComment reads a // comment up to the end of the line
+func (l *Lexer) readLineComment() token.Token {
+	start := l.position
+	for l.ch != '\n' && l.ch != 0 {
+		l.readChar()
+	}
+	return token.Token{Type: token.COMMENT, Literal: l.input[start:l.position]}
 }
 
-func isDigit(ch byte) bool {
+// readBlockComment reads a /* */ comment, which may span lines but does
+// not nest
+func (l *Lexer) readBlockComment() token.Token {
+	start := l.position
+	l.readChar()
+	l.readChar()
+
+	for l.ch != '*' || l.peekChar() != '/' {
+		if l.ch == 0 {
+			return token.Token{Type: token.ERROR, Literal: "unterminated comment"}
+		}
+		l.readChar()
+	}
+	l.readChar()
+	l.readChar()
+
+	return token.Token{Type: token.COMMENT, Literal: l.input[start:l.position]}
+}
+
+// isLetter reports whether ch may appear in an identifier: any Unicode
+// letter or an underscore
+func isLetter(ch rune) bool {
+	return unicode.IsLetter(ch) || ch == '_'
+}
+
+func isDigit(ch rune) bool {
 	return '0' <= ch && ch <= '9'
 }
 
-func newToken(tokenType token.Type, ch byte) token.Token {
+func isHexDigit(ch rune) bool {
+	return isDigit(ch) || 'a' <= ch && ch <= 'f' || 'A' <= ch && ch <= 'F'
+}
+
+// newTwoCharToken reads the current and the next character as a single
+// token, e.g. +=
+func (l *Lexer) newTwoCharToken(tokenType token.Type) token.Token {
+	ch := l.ch
+	l.readChar()
+	return token.Token{
+		Type:    tokenType,
+		Literal: string(ch) + string(l.ch),
+	}
+}
+
+func newToken(tokenType token.Type, ch rune) token.Token {
 	return token.Token{
 		Type:    tokenType,
 		Literal: string(ch),
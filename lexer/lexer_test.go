@@ -14,7 +14,7 @@ let add = fn(x, y) {
 x + y;
 };
 let result = add(five, ten);
-!-/*5;
+!-/ *5;
 5 < 10 > 5;
 if (5 < 10) {
 return true;
@@ -250,14 +250,20 @@ return false;
 			token.SEMICOLON, ";",
 		},
 		{
-			token.EOF, "",
+			token.STRING, "foobar",
 		},
 		{
-			token.STRING, "foobar",
+			token.SEMICOLON, ";",
 		},
 		{
 			token.STRING, "foo bar",
 		},
+		{
+			token.SEMICOLON, ";",
+		},
+		{
+			token.EOF, "",
+		},
 	}
 
 	l := New(input)
@@ -273,3 +279,403 @@ return false;
 		}
 	}
 }
+
+func TestAssignmentOperators(t *testing.T) {
+	input := `x += 1; x -= 2; x *= 3; x /= 4; x = 5`
+
+	tests := []struct {
+		expectedType    token.Type
+		expectedLiteral string
+	}{
+		{token.IDENT, "x"},
+		{token.PLUS_ASSIGN, "+="},
+		{token.INT, "1"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.MINUS_ASSIGN, "-="},
+		{token.INT, "2"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.ASTERISK_ASSIGN, "*="},
+		{token.INT, "3"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.SLASH_ASSIGN, "/="},
+		{token.INT, "4"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.ASSIGN, "="},
+		{token.INT, "5"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestComparisonAndLogicalOperators(t *testing.T) {
+	input := `a <= b >= c && d || e % f & |`
+
+	tests := []struct {
+		expectedType    token.Type
+		expectedLiteral string
+	}{
+		{token.IDENT, "a"},
+		{token.LT_EQ, "<="},
+		{token.IDENT, "b"},
+		{token.GT_EQ, ">="},
+		{token.IDENT, "c"},
+		{token.AND, "&&"},
+		{token.IDENT, "d"},
+		{token.OR, "||"},
+		{token.IDENT, "e"},
+		{token.PERCENT, "%"},
+		{token.IDENT, "f"},
+		{token.ILLEGAL, "&"},
+		{token.ILLEGAL, "|"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	input := `3.14 10 0.5 7.`
+
+	tests := []struct {
+		expectedType    token.Type
+		expectedLiteral string
+	}{
+		{token.FLOAT, "3.14"},
+		{token.INT, "10"},
+		{token.FLOAT, "0.5"},
+		{token.INT, "7"},
+		{token.ILLEGAL, "."},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestTokenPositions(t *testing.T) {
+	input := `let x = 5;
+  add(x, "hi")`
+
+	tests := []struct {
+		expectedType   token.Type
+		expectedLine   int
+		expectedColumn int
+		expectedOffset int
+		expectedEnd    int
+	}{
+		{token.LET, 1, 1, 0, 3},
+		{token.IDENT, 1, 5, 4, 5},
+		{token.ASSIGN, 1, 7, 6, 7},
+		{token.INT, 1, 9, 8, 9},
+		{token.SEMICOLON, 1, 10, 9, 10},
+		{token.IDENT, 2, 3, 13, 16},
+		{token.LPAREN, 2, 6, 16, 17},
+		{token.IDENT, 2, 7, 17, 18},
+		{token.COMMA, 2, 8, 18, 19},
+		{token.STRING, 2, 10, 20, 24},
+		{token.RPAREN, 2, 14, 24, 25},
+		{token.EOF, 2, 15, 25, 25},
+	}
+
+	l := NewFile("test.mk", input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Pos.Filename != "test.mk" {
+			t.Errorf("tests[%d] - filename wrong. expected=%q, got=%q", i, "test.mk", tok.Pos.Filename)
+		}
+
+		if tok.Pos.Line != tt.expectedLine || tok.Pos.Column != tt.expectedColumn {
+			t.Errorf("tests[%d] - position wrong. expected=%d:%d, got=%d:%d", i, tt.expectedLine, tt.expectedColumn, tok.Pos.Line, tok.Pos.Column)
+		}
+
+		if tok.Pos.Offset != tt.expectedOffset || tok.End.Offset != tt.expectedEnd {
+			t.Errorf("tests[%d] - span wrong. expected=[%d,%d), got=[%d,%d)", i, tt.expectedOffset, tt.expectedEnd, tok.Pos.Offset, tok.End.Offset)
+		}
+	}
+}
+
+func TestShebangLine(t *testing.T) {
+	input := "#!/usr/bin/env monkey\nlet x = 1;"
+
+	l := New(input)
+	tok := l.NextToken()
+	if tok.Type != token.LET {
+		t.Fatalf("tokentype wrong. expected=%q, got=%q", token.LET, tok.Type)
+	}
+	if tok.Pos.Line != 2 || tok.Pos.Column != 1 {
+		t.Fatalf("position wrong. expected=2:1, got=%s", tok.Pos)
+	}
+}
+
+func TestUnicode(t *testing.T) {
+	input := "let héllo = \"wörld 🌍\";\n日本 € \xff"
+
+	tests := []struct {
+		expectedType    token.Type
+		expectedLiteral string
+		expectedColumn  int
+		expectedOffset  int
+	}{
+		{token.LET, "let", 1, 0},
+		{token.IDENT, "héllo", 5, 4},
+		{token.ASSIGN, "=", 11, 11},
+		{token.STRING, "wörld 🌍", 13, 13},
+		{token.SEMICOLON, ";", 22, 26},
+		{token.IDENT, "日本", 1, 28},
+		{token.ILLEGAL, "€", 4, 35},
+		{token.ILLEGAL, "\xff", 6, 39},
+		{token.EOF, "", 7, 40},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+
+		if tok.Pos.Column != tt.expectedColumn || tok.Pos.Offset != tt.expectedOffset {
+			t.Errorf("tests[%d] - position wrong. expected column %d at offset %d, got column %d at offset %d",
+				i, tt.expectedColumn, tt.expectedOffset, tok.Pos.Column, tok.Pos.Offset)
+		}
+	}
+}
+
+func TestStrings(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedType    token.Type
+		expectedLiteral string
+	}{
+		{`"hello"`, token.STRING, "hello"},
+		{`""`, token.STRING, ""},
+		{`"say \"hi\""`, token.STRING, `say "hi"`},
+		{`"a\\b"`, token.STRING, `a\b`},
+		{`"\${x}"`, token.STRING, "${x}"},
+		{`"a\nb\tc\rd"`, token.STRING, "a\nb\tc\rd"},
+		{`"\u{41}\u{e9}\u{1F30D}"`, token.STRING, "Aé🌍"},
+		{"`raw \\n ${x}`", token.STRING, `raw \n ${x}`},
+		{"`two\nlines`", token.STRING, "two\nlines"},
+		{`"no end`, token.ERROR, "unterminated string"},
+		{"\"no end\n\"", token.ERROR, "unterminated string"},
+		{`"ends in \"`, token.ERROR, "unterminated string"},
+		{"`no end", token.ERROR, "unterminated raw string"},
+		{`"\q"`, token.ERROR, `unknown escape sequence \q`},
+		{`"\u41"`, token.ERROR, `malformed escape sequence: want \u{...} with 1 to 6 hex digits`},
+		{`"\u{}"`, token.ERROR, `malformed escape sequence: want \u{...} with 1 to 6 hex digits`},
+		{`"\u{1234567}"`, token.ERROR, `malformed escape sequence: want \u{...} with 1 to 6 hex digits`},
+		{`"\u{D800}"`, token.ERROR, `invalid code point \u{D800}`},
+		{`"\u{110000}"`, token.ERROR, `invalid code point \u{110000}`},
+	}
+
+	for i, tt := range tests {
+		tok := New(tt.input).NextToken()
+		if tok.Type != tt.expectedType {
+			t.Errorf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Errorf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestStringErrorRecovery(t *testing.T) {
+	input := "let a = \"\\q\"; let b = \"open\nlet c = `x\ny`;"
+
+	tests := []struct {
+		expectedType   token.Type
+		expectedLine   int
+		expectedColumn int
+	}{
+		{token.LET, 1, 1},
+		{token.IDENT, 1, 5},
+		{token.ASSIGN, 1, 7},
+		{token.ERROR, 1, 9},
+		{token.SEMICOLON, 1, 13},
+		{token.LET, 1, 15},
+		{token.IDENT, 1, 19},
+		{token.ASSIGN, 1, 21},
+		{token.ERROR, 1, 23},
+		{token.LET, 2, 1},
+		{token.IDENT, 2, 5},
+		{token.ASSIGN, 2, 7},
+		{token.STRING, 2, 9},
+		{token.SEMICOLON, 3, 3},
+		{token.EOF, 3, 4},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Pos.Line != tt.expectedLine || tok.Pos.Column != tt.expectedColumn {
+			t.Errorf("tests[%d] - position wrong. expected %d:%d, got %s", i, tt.expectedLine, tt.expectedColumn, tok.Pos)
+		}
+	}
+}
+
+func TestStringInterpolation(t *testing.T) {
+	input := `"a ${x} b ${ {"k": "${y}"}["k"] } c" "${z}"`
+
+	tests := []struct {
+		expectedType    token.Type
+		expectedLiteral string
+	}{
+		{token.TEMPLATE_HEAD, "a "},
+		{token.IDENT, "x"},
+		{token.TEMPLATE_MIDDLE, " b "},
+		{token.LBRACE, "{"},
+		{token.STRING, "k"},
+		{token.COLON, ":"},
+		{token.TEMPLATE_HEAD, ""},
+		{token.IDENT, "y"},
+		{token.TEMPLATE_TAIL, ""},
+		{token.RBRACE, "}"},
+		{token.LBRACKET, "["},
+		{token.STRING, "k"},
+		{token.RBRACKET, "]"},
+		{token.TEMPLATE_TAIL, " c"},
+		{token.TEMPLATE_HEAD, ""},
+		{token.IDENT, "z"},
+		{token.TEMPLATE_TAIL, ""},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// leading
+let x = 1; // trailing
+x /= 2 /* inline */ / 3;
+/* spans
+lines */ x`
+
+	tests := []struct {
+		expectedType    token.Type
+		expectedLiteral string
+		expectedLine    int
+	}{
+		{token.COMMENT, "// leading", 1},
+		{token.LET, "let", 2},
+		{token.IDENT, "x", 2},
+		{token.ASSIGN, "=", 2},
+		{token.INT, "1", 2},
+		{token.SEMICOLON, ";", 2},
+		{token.COMMENT, "// trailing", 2},
+		{token.IDENT, "x", 3},
+		{token.SLASH_ASSIGN, "/=", 3},
+		{token.INT, "2", 3},
+		{token.COMMENT, "/* inline */", 3},
+		{token.SLASH, "/", 3},
+		{token.INT, "3", 3},
+		{token.SEMICOLON, ";", 3},
+		{token.COMMENT, "/* spans\nlines */", 4},
+		{token.IDENT, "x", 5},
+		{token.EOF, "", 5},
+	}
+
+	l := New(input)
+	l.KeepComments()
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+		if tok.Pos.Line != tt.expectedLine {
+			t.Errorf("tests[%d] - line wrong. expected=%d, got=%d", i, tt.expectedLine, tok.Pos.Line)
+		}
+	}
+
+	// without KeepComments the comments are skipped
+	l = New(input)
+	for i, tt := range tests {
+		if tt.expectedType == token.COMMENT {
+			continue
+		}
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+	}
+}
+
+func TestUnterminatedComment(t *testing.T) {
+	l := New("1 /* no end")
+	l.NextToken()
+
+	tok := l.NextToken()
+	if tok.Type != token.ERROR || tok.Literal != "unterminated comment" {
+		t.Fatalf("wrong token. expected ERROR %q, got %s %q", "unterminated comment", tok.Type, tok.Literal)
+	}
+	if tok.Pos.Column != 3 {
+		t.Errorf("wrong column. expected=3, got=%d", tok.Pos.Column)
+	}
+	if tok := l.NextToken(); tok.Type != token.EOF {
+		t.Errorf("expected EOF after the comment, got %s", tok.Type)
+	}
+}
@@ -1,17 +1,97 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"interpreters/engine"
+	"interpreters/lexer"
+	"interpreters/object"
+	"interpreters/parser"
 	"interpreters/repl"
+	"io/ioutil"
 	"os"
 	"os/user"
 )
 
+var (
+	engineName = flag.String("engine", "eval", "use 'eval' for the tree-walking evaluator or 'vm' for the bytecode vm")
+	initScript = flag.String("init", "", "run `script.mk` before the interactive prompt starts")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [flags] [run] [script.mk [args...]]\n\n", os.Args[0])
+	fmt.Fprintln(os.Stderr, "Without a script, an interactive prompt is started.")
+	fmt.Fprintln(os.Stderr, "The script sees its own path and args in the `argv` array.")
+	fmt.Fprintln(os.Stderr, "\nflags:")
+	flag.PrintDefaults()
+}
+
 func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) > 0 && args[0] == "run" {
+		args = args[1:]
+		if len(args) == 0 {
+			usage()
+			os.Exit(2)
+		}
+	}
+
+	// `monkey script.mk` is what a `#!/usr/bin/env monkey` line runs
+	if len(args) > 0 {
+		os.Exit(run(args[0], args[1:]))
+	}
+
 	u, err := user.Current()
 	if err != nil {
 		panic(err)
 	}
 	fmt.Printf("Hello %s! This is the Monkey Programming Language\n", u.Username)
-	repl.Start(os.Stdin, os.Stdout)
+	if err := repl.Start(os.Stdin, os.Stdout, *engineName, *initScript); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// run executes the script at path and returns the process exit code: 0 on
+// success, 1 on a runtime error and 2 when the script cannot be read or
+// parsed
+func run(path string, args []string) int {
+	source, err := ioutil.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	l := lexer.NewFile(path, string(source))
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		for _, msg := range p.Errors() {
+			fmt.Fprintln(os.Stderr, msg)
+		}
+		return 2
+	}
+
+	e, err := engine.New(*engineName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	argv := &object.Array{Elements: []object.Object{&object.String{Value: path}}}
+	for _, arg := range args {
+		argv.Elements = append(argv.Elements, &object.String{Value: arg})
+	}
+	e.Define("argv", argv)
+
+	if err, ok := e.Eval(context.Background(), program).(*object.Error); ok {
+		fmt.Fprintln(os.Stderr, err.Traceback())
+		return 1
+	}
+
+	return 0
 }
@@ -0,0 +1,167 @@
+package monkey
+
+import (
+	"context"
+	"fmt"
+	"interpreters/object"
+	"sort"
+)
+
+// toObject converts a Go value to a Monkey object. Go functions become
+// builtins; they must have one of these signatures:
+//
+//	func(ctx context.Context, args ...interface{}) (interface{}, error)
+//	func(args ...interface{}) (interface{}, error)
+//	func(args ...interface{}) interface{}
+//	func(args ...object.Object) object.Object
+func (i *Interpreter) toObject(value interface{}) (object.Object, error) {
+	switch value := value.(type) {
+	case nil:
+		return object.NULL, nil
+	case object.Object:
+		return value, nil
+	case int64:
+		return &object.Integer{Value: value}, nil
+	case int:
+		return &object.Integer{Value: int64(value)}, nil
+	case float64:
+		return &object.Float{Value: value}, nil
+	case string:
+		return &object.String{Value: value}, nil
+	case bool:
+		if value {
+			return object.TRUE, nil
+		}
+		return object.FALSE, nil
+
+	case []interface{}:
+		elements := make([]object.Object, len(value))
+		for n, element := range value {
+			obj, err := i.toObject(element)
+			if err != nil {
+				return nil, err
+			}
+			elements[n] = obj
+		}
+		return &object.Array{Elements: elements}, nil
+
+	case map[string]interface{}:
+		// Go maps have no order, so keys are set in sorted order
+		keys := make([]string, 0, len(value))
+		for k := range value {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		hash := object.NewHash()
+		for _, k := range keys {
+			obj, err := i.toObject(value[k])
+			if err != nil {
+				return nil, err
+			}
+			hash.Set(&object.String{Value: k}, obj)
+		}
+		return hash, nil
+
+	case func(ctx context.Context, args ...interface{}) (interface{}, error):
+		return i.wrapFunction(value), nil
+	case func(args ...interface{}) (interface{}, error):
+		return i.wrapFunction(func(ctx context.Context, args ...interface{}) (interface{}, error) {
+			return value(args...)
+		}), nil
+	case func(args ...interface{}) interface{}:
+		return i.wrapFunction(func(ctx context.Context, args ...interface{}) (interface{}, error) {
+			return value(args...), nil
+		}), nil
+	case func(args ...object.Object) object.Object:
+		return &object.Builtin{Fn: value}, nil
+	case object.BuiltinFunction:
+		return &object.Builtin{Fn: value}, nil
+
+	default:
+		return nil, fmt.Errorf("cannot convert %T to a Monkey value", value)
+	}
+}
+
+// wrapFunction turns a Go function into a builtin converting its arguments
+// to Go and its result back to Monkey. fn is passed the context of the Eval
+// or call that runs it. An error returned by fn becomes a Monkey runtime
+// error.
+func (i *Interpreter) wrapFunction(fn func(ctx context.Context, args ...interface{}) (interface{}, error)) *object.Builtin {
+	return &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		values := make([]interface{}, len(args))
+		for n, arg := range args {
+			values[n] = i.fromObject(arg)
+		}
+
+		result, err := fn(i.ctx, values...)
+		if err != nil {
+			return &object.Error{Message: err.Error()}
+		}
+
+		obj, err := i.toObject(result)
+		if err != nil {
+			return &object.Error{Message: err.Error()}
+		}
+		return obj
+	}}
+}
+
+// fromObject converts a Monkey object to a Go value. Hash keys that are not
+// strings are converted with their Inspect form. Functions become
+// func(ctx context.Context, args ...interface{}) (interface{}, error)
+// calling back into the interpreter under ctx, and objects without a Go
+// counterpart are returned as is.
+func (i *Interpreter) fromObject(obj object.Object) interface{} {
+	return i.convert(obj, map[object.Object]interface{}{})
+}
+
+// convert implements fromObject. Arrays and hashes are converted once, in
+// converted, so that those shared or holding themselves keep their shape
+// instead of being copied forever.
+func (i *Interpreter) convert(obj object.Object, converted map[object.Object]interface{}) interface{} {
+	if value, ok := converted[obj]; ok {
+		return value
+	}
+
+	switch obj := obj.(type) {
+	case nil, *object.Null:
+		return nil
+	case *object.Integer:
+		return obj.Value
+	case *object.Float:
+		return obj.Value
+	case *object.String:
+		return obj.Value
+	case *object.Boolean:
+		return obj.Value
+
+	case *object.Array:
+		values := make([]interface{}, len(obj.Elements))
+		converted[obj] = values
+		for n, element := range obj.Elements {
+			values[n] = i.convert(element, converted)
+		}
+		return values
+
+	case *object.Hash:
+		values := make(map[string]interface{}, len(obj.Pairs))
+		converted[obj] = values
+		for _, pair := range obj.Pairs {
+			key := pair.Key.Inspect()
+			if str, ok := pair.Key.(*object.String); ok {
+				key = str.Value
+			}
+			values[key] = i.convert(pair.Value, converted)
+		}
+		return values
+
+	case *object.Function, *object.Closure, *object.Builtin:
+		return func(ctx context.Context, args ...interface{}) (interface{}, error) {
+			return i.call(ctx, obj, args)
+		}
+
+	default:
+		return obj
+	}
+}
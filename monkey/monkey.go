@@ -0,0 +1,155 @@
+// Package monkey embeds the Monkey interpreter in Go programs.
+//
+//	interp := monkey.New()
+//	interp.SetGlobal("limit", int64(10))
+//	result, err := interp.Eval(ctx, "limit * 2")
+//
+// Values passed in and out are converted between Go and Monkey: int64,
+// float64, string, bool, nil, []interface{}, map[string]interface{} and
+// functions. An Interpreter is not safe for concurrent use.
+//
+// Scripts are stopped with an *object.Error when the context passed to
+// Eval is done or when they exceed the interpreter's Limits; errors.Is
+// tells which, e.g. errors.Is(err, object.ErrStepLimit).
+package monkey
+
+import (
+	"context"
+	"fmt"
+	"interpreters/engine"
+	"interpreters/lexer"
+	"interpreters/object"
+	"interpreters/parser"
+	"strings"
+)
+
+// Interpreter evaluates Monkey source, keeping its globals from one call to
+// the next
+type Interpreter struct {
+	engine engine.Engine
+	// ctx is the context of the Eval or call in progress, passed to the Go
+	// functions that take one
+	ctx context.Context
+}
+
+// New returns an interpreter using the tree-walking evaluator
+func New() *Interpreter {
+	interp, err := NewWithEngine("eval")
+	if err != nil {
+		panic(err)
+	}
+	return interp
+}
+
+// NewWithEngine returns an interpreter using the named engine, "eval" or "vm"
+func NewWithEngine(name string) (*Interpreter, error) {
+	e, err := engine.New(name)
+	if err != nil {
+		return nil, err
+	}
+	return &Interpreter{engine: e}, nil
+}
+
+// Limits bounds the resources used by each call to Eval or CallFunction
+type Limits = object.Limits
+
+// SetLimits sets the limits of the evaluations that follow. Zero fields
+// are unlimited, except MaxDepth which defaults to object.DefaultMaxDepth.
+func (i *Interpreter) SetLimits(limits Limits) {
+	i.engine.SetLimits(limits)
+}
+
+// ParseError is returned by Eval when the source does not parse. It holds
+// every syntax error found.
+type ParseError struct {
+	Errors []*parser.ParseError
+}
+
+func (e *ParseError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// Eval parses and evaluates source and returns the value of its last
+// statement converted to Go. Runtime errors are returned as *object.Error
+// and syntax errors as *ParseError.
+func (i *Interpreter) Eval(ctx context.Context, source string) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.ParseErrors()) != 0 {
+		return nil, &ParseError{Errors: p.ParseErrors()}
+	}
+
+	defer i.enter(ctx)()
+	result := i.engine.Eval(ctx, program)
+	if err, ok := result.(*object.Error); ok {
+		return nil, err
+	}
+
+	return i.fromObject(result), nil
+}
+
+// SetGlobal binds name to value, converted to a Monkey object
+func (i *Interpreter) SetGlobal(name string, value interface{}) error {
+	obj, err := i.toObject(value)
+	if err != nil {
+		return fmt.Errorf("global %s: %s", name, err)
+	}
+	i.engine.Define(name, obj)
+	return nil
+}
+
+// GetGlobal returns the value bound to name converted to Go, or false if
+// name is not bound
+func (i *Interpreter) GetGlobal(name string) (interface{}, bool) {
+	obj, ok := i.engine.Get(name)
+	if !ok {
+		return nil, false
+	}
+	return i.fromObject(obj), true
+}
+
+// CallFunction calls the Monkey function bound to name with args converted
+// to Monkey objects, and returns its result converted to Go. The call is
+// stopped like Eval when ctx is done.
+func (i *Interpreter) CallFunction(ctx context.Context, name string, args ...interface{}) (interface{}, error) {
+	fn, ok := i.engine.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("identifier not found: %s", name)
+	}
+	return i.call(ctx, fn, args)
+}
+
+func (i *Interpreter) call(ctx context.Context, fn object.Object, args []interface{}) (interface{}, error) {
+	objects := make([]object.Object, len(args))
+	for n, arg := range args {
+		obj, err := i.toObject(arg)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %s", n, err)
+		}
+		objects[n] = obj
+	}
+
+	defer i.enter(ctx)()
+	result := i.engine.Call(ctx, fn, objects...)
+	if err, ok := result.(*object.Error); ok {
+		return nil, err
+	}
+
+	return i.fromObject(result), nil
+}
+
+// enter makes ctx the context of the Go functions called until the
+// returned function restores the previous one
+func (i *Interpreter) enter(ctx context.Context) func() {
+	outer := i.ctx
+	i.ctx = ctx
+	return func() { i.ctx = outer }
+}
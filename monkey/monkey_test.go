@@ -0,0 +1,290 @@
+package monkey
+
+import (
+	"context"
+	"errors"
+	"interpreters/object"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var engines = []string{"eval", "vm"}
+
+func newInterpreter(t *testing.T, name string) *Interpreter {
+	interp, err := NewWithEngine(name)
+	if err != nil {
+		t.Fatalf("NewWithEngine(%q) returned error: %s", name, err)
+	}
+	return interp
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"1 + 2", int64(3)},
+		{"1.5 * 2", 3.0},
+		{`"mon" + "key"`, "monkey"},
+		{"1 < 2", true},
+		{"let x = 1;", nil},
+		{"[1, \"two\", [true]]", []interface{}{int64(1), "two", []interface{}{true}}},
+		{`{"a": 1, 2: "b"}`, map[string]interface{}{"a": int64(1), "2": "b"}},
+	}
+
+	for _, name := range engines {
+		for _, tt := range tests {
+			result, err := newInterpreter(t, name).Eval(context.Background(), tt.input)
+			if err != nil {
+				t.Fatalf("%s: Eval(%q) returned error: %s", name, tt.input, err)
+			}
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("%s: Eval(%q) wrong. want=%#v, got=%#v", name, tt.input, tt.expected, result)
+			}
+		}
+	}
+}
+
+func TestEvalSelfContaining(t *testing.T) {
+	for _, name := range engines {
+		interp := newInterpreter(t, name)
+
+		result, err := interp.Eval(context.Background(), "let a = [1, 0]; a[1] = a; a")
+		if err != nil {
+			t.Fatalf("%s: Eval returned error: %s", name, err)
+		}
+		array := result.([]interface{})
+		if inner, ok := array[1].([]interface{}); !ok || &inner[0] != &array[0] {
+			t.Errorf("%s: array does not hold itself", name)
+		}
+
+		result, err = interp.Eval(context.Background(), `let h = {"n": 1}; h["self"] = h; h`)
+		if err != nil {
+			t.Fatalf("%s: Eval returned error: %s", name, err)
+		}
+		hash := result.(map[string]interface{})
+		if reflect.ValueOf(hash["self"]).Pointer() != reflect.ValueOf(hash).Pointer() {
+			t.Errorf("%s: hash does not hold itself", name)
+		}
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	for _, name := range engines {
+		interp := newInterpreter(t, name)
+
+		_, err := interp.Eval(context.Background(), "let = 1;")
+		if _, ok := err.(*ParseError); !ok {
+			t.Errorf("%s: expected *ParseError. got=%T (%v)", name, err, err)
+		}
+
+		_, err = interp.Eval(context.Background(), "1 + true")
+		runtimeErr, ok := err.(*object.Error)
+		if !ok {
+			t.Fatalf("%s: expected *object.Error. got=%T (%v)", name, err, err)
+		}
+		if runtimeErr.Message != "type mismatch: INTEGER + BOOLEAN" {
+			t.Errorf("%s: wrong message. got=%q", name, runtimeErr.Message)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := interp.Eval(ctx, "1"); err != context.Canceled {
+			t.Errorf("%s: expected context.Canceled. got=%v", name, err)
+		}
+	}
+}
+
+func TestGlobals(t *testing.T) {
+	for _, name := range engines {
+		interp := newInterpreter(t, name)
+
+		globals := map[string]interface{}{
+			"count":  int64(2),
+			"ratio":  0.5,
+			"name":   "monkey",
+			"debug":  true,
+			"items":  []interface{}{int64(1), int64(2)},
+			"config": map[string]interface{}{"depth": int64(3)},
+		}
+		for k, v := range globals {
+			if err := interp.SetGlobal(k, v); err != nil {
+				t.Fatalf("%s: SetGlobal(%q) returned error: %s", name, k, err)
+			}
+		}
+
+		result, err := interp.Eval(context.Background(),
+			`let total = count * config["depth"] + items[1]; if (debug) { name } else { ratio }`)
+		if err != nil {
+			t.Fatalf("%s: Eval returned error: %s", name, err)
+		}
+		if result != "monkey" {
+			t.Errorf("%s: wrong result. got=%#v", name, result)
+		}
+
+		total, ok := interp.GetGlobal("total")
+		if !ok || total != int64(8) {
+			t.Errorf("%s: wrong total. got=%#v (%t)", name, total, ok)
+		}
+
+		if _, ok := interp.GetGlobal("missing"); ok {
+			t.Errorf("%s: expected missing global to be unbound", name)
+		}
+
+		if err := interp.SetGlobal("ch", make(chan int)); err == nil {
+			t.Errorf("%s: expected an error for an unsupported type", name)
+		}
+	}
+}
+
+func TestCallFunction(t *testing.T) {
+	for _, name := range engines {
+		interp := newInterpreter(t, name)
+
+		_, err := interp.Eval(context.Background(), `
+let add = fn(a, b) { a + b };
+let makeAdder = fn(x) { fn(y) { x + y } };
+`)
+		if err != nil {
+			t.Fatalf("%s: Eval returned error: %s", name, err)
+		}
+
+		result, err := interp.CallFunction(context.Background(), "add", 2, int64(3))
+		if err != nil || result != int64(5) {
+			t.Errorf("%s: add(2, 3) wrong. got=%#v, err=%v", name, result, err)
+		}
+
+		result, err = interp.CallFunction(context.Background(), "len", "four")
+		if err != nil || result != int64(4) {
+			t.Errorf("%s: len(\"four\") wrong. got=%#v, err=%v", name, result, err)
+		}
+
+		result, err = interp.CallFunction(context.Background(), "makeAdder", 10)
+		if err != nil {
+			t.Fatalf("%s: makeAdder(10) returned error: %s", name, err)
+		}
+		adder, ok := result.(func(ctx context.Context, args ...interface{}) (interface{}, error))
+		if !ok {
+			t.Fatalf("%s: expected a function. got=%T", name, result)
+		}
+		result, err = adder(context.Background(), 5)
+		if err != nil || result != int64(15) {
+			t.Errorf("%s: adder(5) wrong. got=%#v, err=%v", name, result, err)
+		}
+
+		if _, err := interp.CallFunction(context.Background(), "add", 1, "one"); err == nil {
+			t.Errorf("%s: expected a runtime error", name)
+		}
+		if _, err := interp.CallFunction(context.Background(), "nope"); err == nil {
+			t.Errorf("%s: expected an error for an unknown function", name)
+		}
+	}
+}
+
+func TestGoFunctions(t *testing.T) {
+	for _, name := range engines {
+		interp := newInterpreter(t, name)
+
+		interp.SetGlobal("greet", func(args ...interface{}) interface{} {
+			return "hello " + args[0].(string)
+		})
+		interp.SetGlobal("fail", func(args ...interface{}) (interface{}, error) {
+			return nil, errors.New("host failure")
+		})
+		interp.SetGlobal("apply", func(ctx context.Context, args ...interface{}) (interface{}, error) {
+			fn := args[0].(func(ctx context.Context, args ...interface{}) (interface{}, error))
+			return fn(ctx, args[1])
+		})
+		interp.SetGlobal("hasDeadline", func(ctx context.Context, args ...interface{}) (interface{}, error) {
+			_, ok := ctx.Deadline()
+			return ok, nil
+		})
+
+		result, err := interp.Eval(context.Background(), `greet("world")`)
+		if err != nil || result != "hello world" {
+			t.Errorf("%s: greet wrong. got=%#v, err=%v", name, result, err)
+		}
+
+		result, err = interp.Eval(context.Background(), `apply(fn(x) { x * 3 }, 4)`)
+		if err != nil || result != int64(12) {
+			t.Errorf("%s: apply wrong. got=%#v, err=%v", name, result, err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		result, err = interp.Eval(ctx, `hasDeadline()`)
+		cancel()
+		if err != nil || result != true {
+			t.Errorf("%s: Go function not passed the context of Eval. got=%#v, err=%v", name, result, err)
+		}
+
+		_, err = interp.Eval(context.Background(), `fail()`)
+		if runtimeErr, ok := err.(*object.Error); !ok || runtimeErr.Message != "host failure" {
+			t.Errorf("%s: expected host failure. got=%v", name, err)
+		}
+	}
+}
+
+func TestGoFunctionsSwallowingErrors(t *testing.T) {
+	for _, name := range engines {
+		interp := newInterpreter(t, name)
+		interp.SetLimits(Limits{MaxDepth: 50})
+		interp.SetGlobal("try", func(ctx context.Context, args ...interface{}) (interface{}, error) {
+			fn := args[0].(func(ctx context.Context, args ...interface{}) (interface{}, error))
+			fn(ctx)
+			return nil, nil
+		})
+
+		// the frames of the failed calls must not count towards the depth
+		result, err := interp.Eval(context.Background(), `
+let fail = fn() { let inner = fn() { 1 + "a" }; inner() };
+let ok = fn() { 1 };
+for (i in range(100)) { try(fail) }
+ok()
+`)
+		if err != nil || result != int64(1) {
+			t.Errorf("%s: wrong result. got=%#v, err=%v", name, result, err)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	for _, name := range engines {
+		interp := newInterpreter(t, name)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		_, err := interp.Eval(ctx, "while (true) {}")
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%s: expected context.DeadlineExceeded. got=%v", name, err)
+		}
+
+		_, err = interp.Eval(context.Background(), "let f = fn() { f() }; f()")
+		if !errors.Is(err, object.ErrDepthLimit) {
+			t.Errorf("%s: expected object.ErrDepthLimit. got=%v", name, err)
+		}
+
+		interp.SetLimits(Limits{MaxSteps: 500})
+		_, err = interp.Eval(context.Background(), "let loop = fn() { while (true) {} };")
+		if err != nil {
+			t.Fatalf("%s: Eval returned error: %s", name, err)
+		}
+		if _, err := interp.CallFunction(context.Background(), "loop"); !errors.Is(err, object.ErrStepLimit) {
+			t.Errorf("%s: expected object.ErrStepLimit. got=%v", name, err)
+		}
+
+		interp.SetLimits(Limits{})
+		ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+		_, err = interp.CallFunction(ctx, "loop")
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%s: expected context.DeadlineExceeded from CallFunction. got=%v", name, err)
+		}
+
+		// limits are per evaluation, so the budget is not used up
+		result, err := interp.Eval(context.Background(), "1 + 1")
+		if err != nil || result != int64(2) {
+			t.Errorf("%s: wrong result. got=%#v, err=%v", name, result, err)
+		}
+	}
+}
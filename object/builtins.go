@@ -0,0 +1,1495 @@
+package object
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Builtins lists the builtin functions in a fixed order. The compiler
+// refers to them by their index in this list.
+var Builtins = []struct {
+	Name    string
+	Builtin *Builtin
+}{
+	{
+		"len",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d want=%d", len(args), 1)
+			}
+
+			switch arg := args[0].(type) {
+			case *String:
+				return &Integer{Value: int64(arg.Len())}
+			case *Array:
+				return &Integer{Value: int64(len(arg.Elements))}
+			case *Hash:
+				return &Integer{Value: int64(len(arg.Pairs))}
+			default:
+				return newError("argument to `len` not supported, got %s", args[0].Type())
+			}
+		}},
+	},
+	{
+		"puts",
+		&Builtin{Fn: func(args ...Object) Object {
+			for _, arg := range args {
+				fmt.Println(arg.Inspect())
+			}
+			return NULL
+		}},
+	},
+	{
+		"range",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) < 1 || len(args) > 3 {
+				return newError("wrong number of arguments. got=%d want=1, 2 or 3", len(args))
+			}
+
+			bounds := make([]int64, len(args))
+			for i, arg := range args {
+				integer, ok := arg.(*Integer)
+				if !ok {
+					return newError("argument to `range` must be INTEGER, got %s", arg.Type())
+				}
+				bounds[i] = integer.Value
+			}
+
+			switch len(bounds) {
+			case 1:
+				return &Range{Start: 0, Stop: bounds[0], Step: 1}
+			case 2:
+				return &Range{Start: bounds[0], Stop: bounds[1], Step: 1}
+			}
+			if bounds[2] == 0 {
+				return newError("step of `range` must not be zero")
+			}
+			return &Range{Start: bounds[0], Stop: bounds[1], Step: bounds[2]}
+		}},
+	},
+	{
+		"float",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d want=%d", len(args), 1)
+			}
+
+			switch arg := args[0].(type) {
+			case *Integer:
+				return &Float{Value: float64(arg.Value)}
+			case *Float:
+				return arg
+			case *String:
+				value, err := strconv.ParseFloat(strings.TrimSpace(arg.Value), 64)
+				if err != nil {
+					return newError("could not parse %q as float", arg.Value)
+				}
+				return &Float{Value: value}
+			default:
+				return newError("argument to `float` not supported, got %s", args[0].Type())
+			}
+		}},
+	},
+	{
+		"int",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d want=%d", len(args), 1)
+			}
+
+			switch arg := args[0].(type) {
+			case *Integer:
+				return arg
+			case *Float:
+				// truncates towards zero
+				return &Integer{Value: int64(arg.Value)}
+			case *String:
+				value, err := strconv.ParseInt(strings.TrimSpace(arg.Value), 10, 64)
+				if err != nil {
+					return newError("could not parse %q as integer", arg.Value)
+				}
+				return &Integer{Value: value}
+			default:
+				return newError("argument to `int` not supported, got %s", args[0].Type())
+			}
+		}},
+	},
+	{
+		"round",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 && len(args) != 2 {
+				return newError("wrong number of arguments. got=%d want=1 or 2", len(args))
+			}
+
+			if len(args) == 1 {
+				return roundNumber("round", args[0], math.Round)
+			}
+
+			// round(x, digits) keeps the given number of decimal places
+			digits, ok := args[1].(*Integer)
+			if !ok {
+				return newError("second argument to `round` must be INTEGER, got %s", args[1].Type())
+			}
+			var value float64
+			switch arg := args[0].(type) {
+			case *Integer:
+				value = float64(arg.Value)
+			case *Float:
+				value = arg.Value
+			default:
+				return newError("argument to `round` not supported, got %s", args[0].Type())
+			}
+			scale := math.Pow(10, float64(digits.Value))
+			return &Float{Value: math.Round(value*scale) / scale}
+		}},
+	},
+	{
+		"floor",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d want=%d", len(args), 1)
+			}
+			return roundNumber("floor", args[0], math.Floor)
+		}},
+	},
+	{
+		"ceil",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d want=%d", len(args), 1)
+			}
+			return roundNumber("ceil", args[0], math.Ceil)
+		}},
+	},
+	{
+		"first",
+		&Builtin{Fn: func(args ...Object) Object {
+			array, err := arrayArgument("first", 1, args)
+			if err != nil {
+				return err
+			}
+			if len(array.Elements) == 0 {
+				return NULL
+			}
+			return array.Elements[0]
+		}},
+	},
+	{
+		"last",
+		&Builtin{Fn: func(args ...Object) Object {
+			array, err := arrayArgument("last", 1, args)
+			if err != nil {
+				return err
+			}
+			if len(array.Elements) == 0 {
+				return NULL
+			}
+			return array.Elements[len(array.Elements)-1]
+		}},
+	},
+	{
+		"rest",
+		&Builtin{Fn: func(args ...Object) Object {
+			array, err := arrayArgument("rest", 1, args)
+			if err != nil {
+				return err
+			}
+			if len(array.Elements) == 0 {
+				return NULL
+			}
+			return copyElements(array.Elements[1:])
+		}},
+	},
+	{
+		// push returns a new array with the other arguments appended
+		"push",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) < 2 {
+				return newError("wrong number of arguments. got=%d want=2 or more", len(args))
+			}
+			array, ok := args[0].(*Array)
+			if !ok {
+				return newError("argument to `push` must be ARRAY, got %s", args[0].Type())
+			}
+
+			elements := make([]Object, 0, len(array.Elements)+len(args)-1)
+			elements = append(elements, array.Elements...)
+			elements = append(elements, args[1:]...)
+			return &Array{Elements: elements}
+		}},
+	},
+	{
+		// pop returns a new array without the last element
+		"pop",
+		&Builtin{Fn: func(args ...Object) Object {
+			array, err := arrayArgument("pop", 1, args)
+			if err != nil {
+				return err
+			}
+			if len(array.Elements) == 0 {
+				return NULL
+			}
+			return copyElements(array.Elements[:len(array.Elements)-1])
+		}},
+	},
+	{
+		"concat",
+		&Builtin{
+			Fn: func(args ...Object) Object {
+				arrays, length, err := concatArguments(args)
+				if err != nil {
+					return err
+				}
+				elements := make([]Object, 0, length)
+				for _, array := range arrays {
+					elements = append(elements, array.Elements...)
+				}
+				return &Array{Elements: elements}
+			},
+			Size: func(args ...Object) int64 {
+				_, length, err := concatArguments(args)
+				if err != nil {
+					return 0
+				}
+				return headerSize + elementSize*length
+			},
+		},
+	},
+	{
+		// slice(array, start[, end]) returns the elements from start up to
+		// but not including end, like array[start:end]. It slices strings
+		// too.
+		"slice",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 2 && len(args) != 3 {
+				return newError("wrong number of arguments. got=%d want=2 or 3", len(args))
+			}
+
+			end := Object(NULL)
+			if len(args) == 3 {
+				end = args[2]
+			}
+			return Slice(args[0], args[1], end)
+		}},
+	},
+	{
+		"reverse",
+		&Builtin{Fn: func(args ...Object) Object {
+			array, err := arrayArgument("reverse", 1, args)
+			if err != nil {
+				return err
+			}
+
+			n := len(array.Elements)
+			elements := make([]Object, n)
+			for i, element := range array.Elements {
+				elements[n-1-i] = element
+			}
+			return &Array{Elements: elements}
+		}},
+	},
+	{
+		// contains tells whether an array has an element equal to its
+		// second argument, or whether a string has it as a substring
+		"contains",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d want=%d", len(args), 2)
+			}
+			if str, ok := args[0].(*String); ok {
+				substr, ok := args[1].(*String)
+				if !ok {
+					return newError("second argument to `contains` must be STRING, got %s", args[1].Type())
+				}
+				return nativeBool(strings.Contains(str.Value, substr.Value))
+			}
+
+			array, err := arrayArgument("contains", 2, args)
+			if err != nil {
+				return err
+			}
+			return nativeBool(indexOf(array, args[1]) >= 0)
+		}},
+	},
+	{
+		// index_of returns the index of the first element equal to its
+		// second argument, or -1
+		"index_of",
+		&Builtin{Fn: func(args ...Object) Object {
+			array, err := arrayArgument("index_of", 2, args)
+			if err != nil {
+				return err
+			}
+			return &Integer{Value: int64(indexOf(array, args[1]))}
+		}},
+	},
+	{
+		"join",
+		&Builtin{
+			Fn: func(args ...Object) Object {
+				parts, separator, err := joinArguments(args)
+				if err != nil {
+					return err
+				}
+				if joinedLength(parts, separator) > maxLength {
+					return newError("result of `join` is too large")
+				}
+				return &String{Value: strings.Join(parts, separator)}
+			},
+			Size: func(args ...Object) int64 {
+				parts, separator, err := joinArguments(args)
+				if err != nil {
+					return 0
+				}
+				return stringSize(joinedLength(parts, separator))
+			},
+		},
+	},
+	{
+		// sort(array[, less]) returns a sorted copy of array. Without less,
+		// the elements must be all numbers or all strings. less(a, b) must
+		// return true when a sorts before b.
+		"sort",
+		&Builtin{HigherOrder: func(apply Apply, args ...Object) Object {
+			if len(args) != 1 && len(args) != 2 {
+				return newError("wrong number of arguments. got=%d want=1 or 2", len(args))
+			}
+			array, ok := args[0].(*Array)
+			if !ok {
+				return newError("argument to `sort` must be ARRAY, got %s", args[0].Type())
+			}
+
+			elements := copyElements(array.Elements).Elements
+			if len(args) == 1 {
+				if err := sortElements(elements); err != nil {
+					return err
+				}
+				return &Array{Elements: elements}
+			}
+
+			var err Object
+			sort.SliceStable(elements, func(i, j int) bool {
+				if err != nil {
+					return false
+				}
+				result := apply(args[1], elements[i], elements[j])
+				switch result := result.(type) {
+				case *Error:
+					err = result
+				case *Boolean:
+					return result.Value
+				default:
+					err = newError("comparator of `sort` must return BOOLEAN, got %s", result.Type())
+				}
+				return false
+			})
+			if err != nil {
+				return err
+			}
+			return &Array{Elements: elements}
+		}},
+	},
+	{
+		"map",
+		&Builtin{HigherOrder: func(apply Apply, args ...Object) Object {
+			elements, err := collectionArguments("map", args)
+			if err != nil {
+				return err
+			}
+
+			results := []Object{}
+			for element, ok := elements.Next(); ok; element, ok = elements.Next() {
+				result := apply(args[1], element)
+				if isError(result) {
+					return result
+				}
+				results = append(results, result)
+			}
+			return &Array{Elements: results}
+		}},
+	},
+	{
+		"filter",
+		&Builtin{HigherOrder: func(apply Apply, args ...Object) Object {
+			elements, err := collectionArguments("filter", args)
+			if err != nil {
+				return err
+			}
+
+			results := []Object{}
+			for element, ok := elements.Next(); ok; element, ok = elements.Next() {
+				result := apply(args[1], element)
+				if isError(result) {
+					return result
+				}
+				if isTruthy(result) {
+					results = append(results, element)
+				}
+			}
+			return &Array{Elements: results}
+		}},
+	},
+	{
+		// reduce(collection, fn[, initial]) folds the elements with
+		// fn(accumulator, element), starting from initial or else from the
+		// first element. Reducing nothing without initial gives null.
+		"reduce",
+		&Builtin{HigherOrder: func(apply Apply, args ...Object) Object {
+			if len(args) != 2 && len(args) != 3 {
+				return newError("wrong number of arguments. got=%d want=2 or 3", len(args))
+			}
+			elements, err := collectionArguments("reduce", args[:2])
+			if err != nil {
+				return err
+			}
+
+			var accumulator Object = NULL
+			if len(args) == 3 {
+				accumulator = args[2]
+			} else if first, ok := elements.Next(); ok {
+				accumulator = first
+			}
+
+			for element, ok := elements.Next(); ok; element, ok = elements.Next() {
+				accumulator = apply(args[1], accumulator, element)
+				if isError(accumulator) {
+					return accumulator
+				}
+			}
+			return accumulator
+		}},
+	},
+	{
+		"each",
+		&Builtin{HigherOrder: func(apply Apply, args ...Object) Object {
+			elements, err := collectionArguments("each", args)
+			if err != nil {
+				return err
+			}
+
+			for element, ok := elements.Next(); ok; element, ok = elements.Next() {
+				if result := apply(args[1], element); isError(result) {
+					return result
+				}
+			}
+			return NULL
+		}},
+	},
+	{
+		"any",
+		&Builtin{HigherOrder: func(apply Apply, args ...Object) Object {
+			elements, err := collectionArguments("any", args)
+			if err != nil {
+				return err
+			}
+
+			for element, ok := elements.Next(); ok; element, ok = elements.Next() {
+				result := apply(args[1], element)
+				if isError(result) {
+					return result
+				}
+				if isTruthy(result) {
+					return TRUE
+				}
+			}
+			return FALSE
+		}},
+	},
+	{
+		"all",
+		&Builtin{HigherOrder: func(apply Apply, args ...Object) Object {
+			elements, err := collectionArguments("all", args)
+			if err != nil {
+				return err
+			}
+
+			for element, ok := elements.Next(); ok; element, ok = elements.Next() {
+				result := apply(args[1], element)
+				if isError(result) {
+					return result
+				}
+				if !isTruthy(result) {
+					return FALSE
+				}
+			}
+			return TRUE
+		}},
+	},
+	{
+		// find returns the first element fn is true for, or null
+		"find",
+		&Builtin{HigherOrder: func(apply Apply, args ...Object) Object {
+			elements, err := collectionArguments("find", args)
+			if err != nil {
+				return err
+			}
+
+			for element, ok := elements.Next(); ok; element, ok = elements.Next() {
+				result := apply(args[1], element)
+				if isError(result) {
+					return result
+				}
+				if isTruthy(result) {
+					return element
+				}
+			}
+			return NULL
+		}},
+	},
+	{
+		// zip pairs up the elements of its arguments, stopping at the end
+		// of the shortest
+		"zip",
+		&Builtin{
+			Fn: func(args ...Object) Object {
+				shortest, err := zipArguments(args)
+				if err != nil {
+					return err
+				}
+				if zipSize(shortest, len(args)) > maxLength {
+					return newError("result of `zip` is too large")
+				}
+
+				collections := make([]*Iterator, len(args))
+				for i, arg := range args {
+					collections[i], _ = NewIterator(arg)
+				}
+				tuples := make([]Object, shortest)
+				for i := range tuples {
+					tuple := make([]Object, len(collections))
+					for j, elements := range collections {
+						tuple[j], _ = elements.Next()
+					}
+					tuples[i] = &Array{Elements: tuple}
+				}
+				return &Array{Elements: tuples}
+			},
+			Size: func(args ...Object) int64 {
+				shortest, err := zipArguments(args)
+				if err != nil {
+					return 0
+				}
+				if size := zipSize(shortest, len(args)); size <= maxLength {
+					return size
+				}
+				return 0
+			},
+		},
+	},
+	{
+		// group_by returns a hash from each value of fn to the elements
+		// it was returned for
+		"group_by",
+		&Builtin{HigherOrder: func(apply Apply, args ...Object) Object {
+			elements, err := collectionArguments("group_by", args)
+			if err != nil {
+				return err
+			}
+
+			groups := NewHash()
+			for element, ok := elements.Next(); ok; element, ok = elements.Next() {
+				key := apply(args[1], element)
+				if isError(key) {
+					return key
+				}
+				hashable, ok := key.(Hashable)
+				if !ok {
+					return newError("unusable as hash key: %s", key.Type())
+				}
+
+				pair, ok := groups.Pairs[hashable.HashKey()]
+				if !ok {
+					pair = HashPair{Key: key, Value: &Array{}}
+					groups.Set(key, pair.Value)
+				}
+				group := pair.Value.(*Array)
+				group.Elements = append(group.Elements, element)
+			}
+			return groups
+		}},
+	},
+	{
+		// flat_map concatenates the arrays fn returns
+		"flat_map",
+		&Builtin{HigherOrder: func(apply Apply, args ...Object) Object {
+			elements, err := collectionArguments("flat_map", args)
+			if err != nil {
+				return err
+			}
+
+			results := []Object{}
+			for element, ok := elements.Next(); ok; element, ok = elements.Next() {
+				result := apply(args[1], element)
+				if isError(result) {
+					return result
+				}
+				array, ok := result.(*Array)
+				if !ok {
+					return newError("function passed to `flat_map` must return ARRAY, got %s", result.Type())
+				}
+				results = append(results, array.Elements...)
+			}
+			return &Array{Elements: results}
+		}},
+	},
+	{
+		// keys returns the keys of a hash in insertion order
+		"keys",
+		&Builtin{Fn: func(args ...Object) Object {
+			hash, err := hashArgument("keys", 1, args)
+			if err != nil {
+				return err
+			}
+			return &Array{Elements: hash.Keys()}
+		}},
+	},
+	{
+		"values",
+		&Builtin{Fn: func(args ...Object) Object {
+			hash, err := hashArgument("values", 1, args)
+			if err != nil {
+				return err
+			}
+
+			pairs := hash.OrderedPairs()
+			values := make([]Object, len(pairs))
+			for i, pair := range pairs {
+				values[i] = pair.Value
+			}
+			return &Array{Elements: values}
+		}},
+	},
+	{
+		// items returns the [key, value] pairs of a hash
+		"items",
+		&Builtin{Fn: func(args ...Object) Object {
+			hash, err := hashArgument("items", 1, args)
+			if err != nil {
+				return err
+			}
+
+			pairs := hash.OrderedPairs()
+			items := make([]Object, len(pairs))
+			for i, pair := range pairs {
+				items[i] = &Array{Elements: []Object{pair.Key, pair.Value}}
+			}
+			return &Array{Elements: items}
+		}},
+	},
+	{
+		"has",
+		&Builtin{Fn: func(args ...Object) Object {
+			hash, err := hashArgument("has", 2, args)
+			if err != nil {
+				return err
+			}
+			key, ok := args[1].(Hashable)
+			if !ok {
+				return newError("unusable as hash key: %s", args[1].Type())
+			}
+
+			if _, ok := hash.Pairs[key.HashKey()]; ok {
+				return TRUE
+			}
+			return FALSE
+		}},
+	},
+	{
+		// delete returns a new hash without the given key
+		"delete",
+		&Builtin{Fn: func(args ...Object) Object {
+			hash, err := hashArgument("delete", 2, args)
+			if err != nil {
+				return err
+			}
+			if _, ok := args[1].(Hashable); !ok {
+				return newError("unusable as hash key: %s", args[1].Type())
+			}
+
+			result := copyHash(hash)
+			result.Delete(args[1])
+			return result
+		}},
+	},
+	{
+		// merge returns a new hash with the pairs of all its arguments,
+		// later hashes overriding the values of earlier ones
+		"merge",
+		&Builtin{Fn: func(args ...Object) Object {
+			result := NewHash()
+			for _, arg := range args {
+				hash, ok := arg.(*Hash)
+				if !ok {
+					return newError("argument to `merge` must be HASH, got %s", arg.Type())
+				}
+				for _, pair := range hash.OrderedPairs() {
+					result.Set(pair.Key, pair.Value)
+				}
+			}
+			return result
+		}},
+	},
+	{
+		// split(s[, sep]) splits s around sep, or around runs of white
+		// space without sep
+		"split",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 && len(args) != 2 {
+				return newError("wrong number of arguments. got=%d want=1 or 2", len(args))
+			}
+			strs, err := stringArguments("split", args)
+			if err != nil {
+				return err
+			}
+
+			var parts []string
+			if len(strs) == 1 {
+				parts = strings.Fields(strs[0])
+			} else {
+				parts = strings.Split(strs[0], strs[1])
+			}
+			return stringArray(parts)
+		}},
+	},
+	{
+		// trim(s[, cutset]) removes leading and trailing white space, or
+		// the characters in cutset
+		"trim",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 && len(args) != 2 {
+				return newError("wrong number of arguments. got=%d want=1 or 2", len(args))
+			}
+			strs, err := stringArguments("trim", args)
+			if err != nil {
+				return err
+			}
+
+			if len(strs) == 1 {
+				return &String{Value: strings.TrimSpace(strs[0])}
+			}
+			return &String{Value: strings.Trim(strs[0], strs[1])}
+		}},
+	},
+	{
+		"upper",
+		&Builtin{Fn: func(args ...Object) Object {
+			strs, err := stringArgumentsN("upper", 1, args)
+			if err != nil {
+				return err
+			}
+			return &String{Value: strings.ToUpper(strs[0])}
+		}},
+	},
+	{
+		"lower",
+		&Builtin{Fn: func(args ...Object) Object {
+			strs, err := stringArgumentsN("lower", 1, args)
+			if err != nil {
+				return err
+			}
+			return &String{Value: strings.ToLower(strs[0])}
+		}},
+	},
+	{
+		// replace(s, old, new) replaces every occurrence of old
+		"replace",
+		&Builtin{
+			Fn: func(args ...Object) Object {
+				strs, err := stringArgumentsN("replace", 3, args)
+				if err != nil {
+					return err
+				}
+				if replacedLength(strs[0], strs[1], strs[2]) > maxLength {
+					return newError("result of `replace` is too large")
+				}
+				return &String{Value: strings.ReplaceAll(strs[0], strs[1], strs[2])}
+			},
+			Size: func(args ...Object) int64 {
+				strs, err := stringArgumentsN("replace", 3, args)
+				if err != nil {
+					return 0
+				}
+				return stringSize(replacedLength(strs[0], strs[1], strs[2]))
+			},
+		},
+	},
+	{
+		"starts_with",
+		&Builtin{Fn: func(args ...Object) Object {
+			strs, err := stringArgumentsN("starts_with", 2, args)
+			if err != nil {
+				return err
+			}
+			return nativeBool(strings.HasPrefix(strs[0], strs[1]))
+		}},
+	},
+	{
+		"ends_with",
+		&Builtin{Fn: func(args ...Object) Object {
+			strs, err := stringArgumentsN("ends_with", 2, args)
+			if err != nil {
+				return err
+			}
+			return nativeBool(strings.HasSuffix(strs[0], strs[1]))
+		}},
+	},
+	{
+		"repeat",
+		&Builtin{
+			Fn: func(args ...Object) Object {
+				str, count, err := repeatArguments(args)
+				if err != nil {
+					return err
+				}
+				if repeatedLength(int64(len(str.Value)), count) > maxLength {
+					return newError("result of `repeat` is too large")
+				}
+				return &String{Value: strings.Repeat(str.Value, int(count))}
+			},
+			Size: func(args ...Object) int64 {
+				str, count, err := repeatArguments(args)
+				if err != nil {
+					return 0
+				}
+				return stringSize(repeatedLength(int64(len(str.Value)), count))
+			},
+		},
+	},
+	{
+		// pad_left(s, width[, pad]) prepends pad, a space by default, until
+		// s is width characters long
+		"pad_left",
+		&Builtin{
+			Fn: func(args ...Object) Object {
+				return pad("pad_left", args, func(s, padding string) string { return padding + s })
+			},
+			Size: func(args ...Object) int64 {
+				return padSize("pad_left", args)
+			},
+		},
+	},
+	{
+		// pad_right(s, width[, pad]) appends pad, a space by default, until
+		// s is width characters long
+		"pad_right",
+		&Builtin{
+			Fn: func(args ...Object) Object {
+				return pad("pad_right", args, func(s, padding string) string { return s + padding })
+			},
+			Size: func(args ...Object) int64 {
+				return padSize("pad_right", args)
+			},
+		},
+	},
+	{
+		"chars",
+		&Builtin{Fn: func(args ...Object) Object {
+			strs, err := stringArgumentsN("chars", 1, args)
+			if err != nil {
+				return err
+			}
+
+			var chars []string
+			for _, r := range strs[0] {
+				chars = append(chars, string(r))
+			}
+			return stringArray(chars)
+		}},
+	},
+	{
+		// format(template, values...) substitutes values for the verbs of
+		// template: %d for integers, %s and %v for any value, and %% for a
+		// percent sign
+		"format",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) == 0 {
+				return newError("wrong number of arguments. got=0 want=1 or more")
+			}
+			template, ok := args[0].(*String)
+			if !ok {
+				return newError("argument to `format` must be STRING, got %s", args[0].Type())
+			}
+			return format(template.Value, args[1:])
+		}},
+	},
+	{
+		// bytes_len returns the size of a string in bytes of UTF-8, where
+		// len counts characters
+		"bytes_len",
+		&Builtin{Fn: func(args ...Object) Object {
+			strs, err := stringArgumentsN("bytes_len", 1, args)
+			if err != nil {
+				return err
+			}
+			return &Integer{Value: int64(len(strs[0]))}
+		}},
+	},
+}
+
+// roundNumber implements the builtin called name, turning a number into an
+// Integer with round
+func roundNumber(name string, arg Object, round func(float64) float64) Object {
+	switch arg := arg.(type) {
+	case *Integer:
+		return arg
+	case *Float:
+		return &Integer{Value: int64(round(arg.Value))}
+	default:
+		return newError("argument to `%s` not supported, got %s", name, arg.Type())
+	}
+}
+
+// arrayArgument checks that the builtin called name got n arguments and
+// returns the first, which must be an array
+func arrayArgument(name string, n int, args []Object) (*Array, *Error) {
+	if len(args) != n {
+		return nil, newError("wrong number of arguments. got=%d want=%d", len(args), n)
+	}
+	array, ok := args[0].(*Array)
+	if !ok {
+		return nil, newError("argument to `%s` must be ARRAY, got %s", name, args[0].Type())
+	}
+	return array, nil
+}
+
+// copyElements returns a new array holding elements, so that changes to
+// one array do not show in the other
+func copyElements(elements []Object) *Array {
+	copied := make([]Object, len(elements))
+	copy(copied, elements)
+	return &Array{Elements: copied}
+}
+
+// Slice returns left[start:end] for an array or a string, whose bounds
+// count characters rather than bytes. Null bounds default to the start and
+// the end, negative bounds count from the end, and bounds out of range are
+// clamped.
+func Slice(left, start, end Object) Object {
+	switch left := left.(type) {
+	case *Array:
+		from, to, err := sliceBounds(start, end, len(left.Elements))
+		if err != nil {
+			return err
+		}
+		return copyElements(left.Elements[from:to])
+	case *String:
+		runes := []rune(left.Value)
+		from, to, err := sliceBounds(start, end, len(runes))
+		if err != nil {
+			return err
+		}
+		return &String{Value: string(runes[from:to])}
+	default:
+		return newError("slice operator not supported: %s", left.Type())
+	}
+}
+
+// sliceBounds resolves the start and end bounds of a slice of a sequence
+// of length n
+func sliceBounds(start, end Object, n int) (int, int, *Error) {
+	bounds := []int{0, n}
+	for i, arg := range []Object{start, end} {
+		if arg == NULL {
+			continue
+		}
+		integer, ok := arg.(*Integer)
+		if !ok {
+			return 0, 0, newError("slice bounds must be INTEGER, got %s", arg.Type())
+		}
+
+		bound := integer.Value
+		if bound < 0 {
+			bound += int64(n)
+		}
+		if bound < 0 {
+			bound = 0
+		}
+		if bound > int64(n) {
+			bound = int64(n)
+		}
+		bounds[i] = int(bound)
+	}
+
+	if bounds[1] < bounds[0] {
+		bounds[1] = bounds[0]
+	}
+	return bounds[0], bounds[1], nil
+}
+
+// hashArgument checks that the builtin called name got n arguments and
+// returns the first, which must be a hash
+func hashArgument(name string, n int, args []Object) (*Hash, *Error) {
+	if len(args) != n {
+		return nil, newError("wrong number of arguments. got=%d want=%d", len(args), n)
+	}
+	hash, ok := args[0].(*Hash)
+	if !ok {
+		return nil, newError("argument to `%s` must be HASH, got %s", name, args[0].Type())
+	}
+	return hash, nil
+}
+
+func copyHash(hash *Hash) *Hash {
+	result := NewHash()
+	for _, pair := range hash.OrderedPairs() {
+		result.Set(pair.Key, pair.Value)
+	}
+	return result
+}
+
+func indexOf(array *Array, value Object) int {
+	for i, element := range array.Elements {
+		if Equal(element, value) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Equal reports whether a and b are equal values: numbers compare by value
+// whatever their type, strings by content, and arrays and hashes element
+// by element. Other objects are only equal to themselves.
+func Equal(a, b Object) bool {
+	return equal(a, b, nil)
+}
+
+// comparison is a pair of arrays or hashes being compared
+type comparison struct {
+	a, b Object
+}
+
+// equal implements Equal. Arrays and hashes that contain themselves lead
+// back to a comparison in comparing, which is taken to hold as nothing has
+// told them apart yet.
+func equal(a, b Object, comparing []comparison) bool {
+	switch a.(type) {
+	case *Array, *Hash:
+		for _, c := range comparing {
+			if c.a == a && c.b == b {
+				return true
+			}
+		}
+		comparing = append(comparing, comparison{a, b})
+	}
+
+	switch a := a.(type) {
+	case *Integer:
+		switch b := b.(type) {
+		case *Integer:
+			return a.Value == b.Value
+		case *Float:
+			return float64(a.Value) == b.Value
+		}
+	case *Float:
+		switch b := b.(type) {
+		case *Integer:
+			return a.Value == float64(b.Value)
+		case *Float:
+			return a.Value == b.Value
+		}
+	case *String:
+		if b, ok := b.(*String); ok {
+			return a.Value == b.Value
+		}
+	case *Array:
+		b, ok := b.(*Array)
+		if !ok || len(a.Elements) != len(b.Elements) {
+			return a == b
+		}
+		for i := range a.Elements {
+			if !equal(a.Elements[i], b.Elements[i], comparing) {
+				return false
+			}
+		}
+		return true
+	case *Hash:
+		b, ok := b.(*Hash)
+		if !ok || len(a.Pairs) != len(b.Pairs) {
+			return false
+		}
+		for key, pair := range a.Pairs {
+			other, ok := b.Pairs[key]
+			if !ok || !equal(pair.Value, other.Value, comparing) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+// sortElements sorts numbers or strings in ascending order
+func sortElements(elements []Object) *Error {
+	allNumbers, allStrings := true, true
+	for _, element := range elements {
+		switch element.(type) {
+		case *Integer, *Float:
+			allStrings = false
+		case *String:
+			allNumbers = false
+		default:
+			return newError("cannot sort %s without a comparator", element.Type())
+		}
+	}
+
+	switch {
+	case allNumbers:
+		sort.SliceStable(elements, func(i, j int) bool {
+			return numberValue(elements[i]) < numberValue(elements[j])
+		})
+	case allStrings:
+		sort.SliceStable(elements, func(i, j int) bool {
+			return elements[i].(*String).Value < elements[j].(*String).Value
+		})
+	default:
+		return newError("cannot sort a mix of numbers and strings without a comparator")
+	}
+	return nil
+}
+
+func numberValue(obj Object) float64 {
+	if integer, ok := obj.(*Integer); ok {
+		return float64(integer.Value)
+	}
+	return obj.(*Float).Value
+}
+
+// collectionArguments checks the arguments of the higher order builtin
+// called name, a collection and a function, and returns an iterator over
+// the elements of the collection
+func collectionArguments(name string, args []Object) (*Iterator, *Error) {
+	if len(args) != 2 {
+		return nil, newError("wrong number of arguments. got=%d want=%d", len(args), 2)
+	}
+	switch args[1].(type) {
+	case *Function, *Closure, *Builtin:
+	default:
+		return nil, newError("second argument to `%s` must be a function, got %s", name, args[1].Type())
+	}
+	iterator, ok := NewIterator(args[0])
+	if !ok {
+		return nil, newError("argument to `%s` must be iterable, got %s", name, args[0].Type())
+	}
+	return iterator, nil
+}
+
+// zipArguments checks the arguments of zip and returns the number of
+// elements of the shortest
+func zipArguments(args []Object) (int64, *Error) {
+	if len(args) == 0 {
+		return 0, newError("wrong number of arguments. got=0 want=1 or more")
+	}
+
+	var shortest int64 = -1
+	for _, arg := range args {
+		length, ok := lengthOf(arg)
+		if !ok {
+			return 0, newError("argument to `zip` must be iterable, got %s", arg.Type())
+		}
+		if shortest < 0 || length < shortest {
+			shortest = length
+		}
+	}
+	return shortest, nil
+}
+
+// zipSize returns the number of bytes zip allocates for count tuples of n
+// elements, or more than maxLength if that is too many
+func zipSize(count int64, n int) int64 {
+	tuple := headerSize + elementSize*(1+int64(n))
+	if count > maxLength/tuple {
+		return maxLength + 1
+	}
+	return headerSize + count*tuple
+}
+
+// lengthOf returns the number of elements iterating over obj yields, or
+// false if obj is not iterable
+func lengthOf(obj Object) (int64, bool) {
+	switch obj := obj.(type) {
+	case *Array:
+		return int64(len(obj.Elements)), true
+	case *Hash:
+		return int64(len(obj.Pairs)), true
+	case *String:
+		return int64(utf8.RuneCountInString(obj.Value)), true
+	case *Range:
+		return obj.length(), true
+	default:
+		return 0, false
+	}
+}
+
+// stringArguments returns the values of args, which must all be strings,
+// for the builtin called name
+func stringArguments(name string, args []Object) ([]string, *Error) {
+	strs := make([]string, len(args))
+	for i, arg := range args {
+		str, ok := arg.(*String)
+		if !ok {
+			return nil, newError("argument to `%s` must be STRING, got %s", name, arg.Type())
+		}
+		strs[i] = str.Value
+	}
+	return strs, nil
+}
+
+// stringArgumentsN is stringArguments for builtins taking n arguments
+func stringArgumentsN(name string, n int, args []Object) ([]string, *Error) {
+	if len(args) != n {
+		return nil, newError("wrong number of arguments. got=%d want=%d", len(args), n)
+	}
+	return stringArguments(name, args)
+}
+
+func stringArray(strs []string) *Array {
+	elements := make([]Object, len(strs))
+	for i, str := range strs {
+		elements[i] = &String{Value: str}
+	}
+	return &Array{Elements: elements}
+}
+
+// maxLength bounds the length in bytes of the strings built by repeat,
+// pad_left, pad_right, replace and join, and the size of the array built
+// by zip, whose arguments can ask for more memory than there is
+const maxLength = 1 << 30
+
+// stringSize is the Size of a builtin returning a string of length bytes,
+// which is not built if it is longer than maxLength
+func stringSize(length int64) int64 {
+	if length > maxLength {
+		return 0
+	}
+	return StringSize(length)
+}
+
+// repeatedLength returns the length of count copies of a string of length
+// n, or maxLength+1 if that is longer than maxLength
+func repeatedLength(n, count int64) int64 {
+	if n > 0 && count > maxLength/n {
+		return maxLength + 1
+	}
+	return n * count
+}
+
+// replacedLength returns the length of s with every occurrence of old
+// replaced by new, or more than maxLength if that is too long
+func replacedLength(s, old, new string) int64 {
+	count := int64(strings.Count(s, old))
+	if len(new) < len(old) {
+		return int64(len(s)) - count*int64(len(old)-len(new))
+	}
+	return int64(len(s)) + repeatedLength(int64(len(new)-len(old)), count)
+}
+
+// joinArguments returns the parts joined by join and its separator
+func joinArguments(args []Object) ([]string, string, *Error) {
+	array, err := arrayArgument("join", 2, args)
+	if err != nil {
+		return nil, "", err
+	}
+	separator, ok := args[1].(*String)
+	if !ok {
+		return nil, "", newError("second argument to `join` must be STRING, got %s", args[1].Type())
+	}
+
+	parts := make([]string, len(array.Elements))
+	for i, element := range array.Elements {
+		parts[i] = element.Inspect()
+	}
+	return parts, separator.Value, nil
+}
+
+// joinedLength returns the length of parts joined by separator, or more
+// than maxLength if that is too long
+func joinedLength(parts []string, separator string) int64 {
+	if len(parts) == 0 {
+		return 0
+	}
+	length := repeatedLength(int64(len(separator)), int64(len(parts)-1))
+	for _, part := range parts {
+		if length > maxLength {
+			break
+		}
+		length += int64(len(part))
+	}
+	return length
+}
+
+// concatArguments returns the arrays given to concat and the number of
+// elements they hold
+func concatArguments(args []Object) ([]*Array, int64, *Error) {
+	arrays := make([]*Array, len(args))
+	var length int64
+	for i, arg := range args {
+		array, ok := arg.(*Array)
+		if !ok {
+			return nil, 0, newError("argument to `concat` must be ARRAY, got %s", arg.Type())
+		}
+		arrays[i] = array
+		length += int64(len(array.Elements))
+	}
+	return arrays, length, nil
+}
+
+func repeatArguments(args []Object) (*String, int64, *Error) {
+	if len(args) != 2 {
+		return nil, 0, newError("wrong number of arguments. got=%d want=%d", len(args), 2)
+	}
+	str, ok := args[0].(*String)
+	if !ok {
+		return nil, 0, newError("argument to `repeat` must be STRING, got %s", args[0].Type())
+	}
+	count, ok := args[1].(*Integer)
+	if !ok {
+		return nil, 0, newError("second argument to `repeat` must be INTEGER, got %s", args[1].Type())
+	}
+	if count.Value < 0 {
+		return nil, 0, newError("count of `repeat` must not be negative")
+	}
+	return str, count.Value, nil
+}
+
+// pad implements pad_left and pad_right, adding padding to a string with
+// add
+func pad(name string, args []Object, add func(s, padding string) string) Object {
+	str, padding, missing, err := padArguments(name, args)
+	if err != nil {
+		return err
+	}
+	if missing <= 0 {
+		return str
+	}
+	if int64(len(str.Value))+paddingLength(padding, missing) > maxLength {
+		return newError("result of `%s` is too large", name)
+	}
+
+	runes := []rune(padding)
+	n := int64(len(runes))
+	return &String{Value: add(str.Value, strings.Repeat(padding, int(missing/n))+string(runes[:missing%n]))}
+}
+
+// padSize is the Size of pad_left and pad_right
+func padSize(name string, args []Object) int64 {
+	str, padding, missing, err := padArguments(name, args)
+	if err != nil || missing <= 0 {
+		return 0
+	}
+	return stringSize(int64(len(str.Value)) + paddingLength(padding, missing))
+}
+
+// padArguments returns the string to pad, the padding and the number of
+// characters missing from the string
+func padArguments(name string, args []Object) (*String, string, int64, *Error) {
+	if len(args) != 2 && len(args) != 3 {
+		return nil, "", 0, newError("wrong number of arguments. got=%d want=2 or 3", len(args))
+	}
+	str, ok := args[0].(*String)
+	if !ok {
+		return nil, "", 0, newError("argument to `%s` must be STRING, got %s", name, args[0].Type())
+	}
+	width, ok := args[1].(*Integer)
+	if !ok {
+		return nil, "", 0, newError("second argument to `%s` must be INTEGER, got %s", name, args[1].Type())
+	}
+	padding := " "
+	if len(args) == 3 {
+		p, ok := args[2].(*String)
+		if !ok || p.Value == "" {
+			return nil, "", 0, newError("third argument to `%s` must be a non-empty STRING", name)
+		}
+		padding = p.Value
+	}
+
+	return str, padding, width.Value - int64(utf8.RuneCountInString(str.Value)), nil
+}
+
+// paddingLength returns the length in bytes of the first n characters of
+// padding repeated, or more than maxLength if that is too long
+func paddingLength(padding string, n int64) int64 {
+	runes := []rune(padding)
+	count := int64(len(runes))
+	return repeatedLength(int64(len(padding)), n/count) + int64(len(string(runes[:n%count])))
+}
+
+// format implements the format builtin
+func format(template string, values []Object) Object {
+	var out strings.Builder
+
+	next := 0
+	for i := 0; i < len(template); i++ {
+		if template[i] != '%' {
+			out.WriteByte(template[i])
+			continue
+		}
+		if i+1 == len(template) {
+			return newError("format ends with a lone %%")
+		}
+		i++
+
+		verb := template[i]
+		if verb == '%' {
+			out.WriteByte('%')
+			continue
+		}
+		if verb != 'd' && verb != 's' && verb != 'v' {
+			return newError("unknown format verb %%%c", verb)
+		}
+		if next == len(values) {
+			return newError("not enough values for format: got %d", len(values))
+		}
+		value := values[next]
+		next++
+
+		if verb == 'd' {
+			integer, ok := value.(*Integer)
+			if !ok {
+				return newError("%%d needs an INTEGER, got %s", value.Type())
+			}
+			out.WriteString(strconv.FormatInt(integer.Value, 10))
+			continue
+		}
+		out.WriteString(value.Inspect())
+	}
+
+	if next != len(values) {
+		return newError("too many values for format: got %d, used %d", len(values), next)
+	}
+	return &String{Value: out.String()}
+}
+
+func nativeBool(value bool) *Boolean {
+	if value {
+		return TRUE
+	}
+	return FALSE
+}
+
+func isError(obj Object) bool {
+	_, ok := obj.(*Error)
+	return ok
+}
+
+// isTruthy follows the engines: everything but null and false is true
+func isTruthy(obj Object) bool {
+	return obj != NULL && obj != FALSE
+}
+
+// GetBuiltinByName returns the builtin called name or nil if there is none
+func GetBuiltinByName(name string) *Builtin {
+	for _, def := range Builtins {
+		if def.Name == name {
+			return def.Builtin
+		}
+	}
+	return nil
+}
+
+func newError(format string, a ...interface{}) *Error {
+	return &Error{Message: fmt.Sprintf(format, a...)}
+}
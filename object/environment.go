@@ -1,17 +1,36 @@
 package object
 
+import (
+	"context"
+	"sort"
+)
+
 type Environment struct {
 	store map[string]Object
 	outer *Environment
+	// state is the state of the evaluation running in the environment
+	state *State
 }
 
 func NewEnvironment() *Environment {
 	return &Environment{
 		store: map[string]Object{},
 		outer: nil,
+		state: NewState(context.Background(), Limits{}),
 	}
 }
 
+// State returns the state of the evaluation running in the environment
+func (e *Environment) State() *State {
+	return e.state
+}
+
+// SetState makes the evaluations that follow in the environment, and in
+// the environments it encloses, run under state
+func (e *Environment) SetState(state *State) {
+	e.state = state
+}
+
 func (e *Environment) Get(key string) (Object, bool) {
 	val, ok := e.store[key]
 	if !ok && e.outer != nil {
@@ -25,8 +44,36 @@ func (e *Environment) Set(key string, value Object) Object {
 	return value
 }
 
+// Assign updates key in the innermost environment that defines it and
+// reports whether there was one
+func (e *Environment) Assign(key string, value Object) bool {
+	if _, ok := e.store[key]; ok {
+		e.store[key] = value
+		return true
+	}
+	if e.outer != nil {
+		return e.outer.Assign(key, value)
+	}
+	return false
+}
+
+// Names returns the names bound in this environment, not its outer ones,
+// in sorted order
+func (e *Environment) Names() []string {
+	names := make([]string, 0, len(e.store))
+	for name := range e.store {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewEnclosedEnvironment creates an environment inside outer, running
+// under the state of outer
 func NewEnclosedEnvironment(outer *Environment) *Environment {
-	env := NewEnvironment()
-	env.outer = outer
-	return env
+	return &Environment{
+		store: map[string]Object{},
+		outer: outer,
+		state: outer.state,
+	}
 }
@@ -0,0 +1,103 @@
+package object
+
+import (
+	"fmt"
+	"math"
+)
+
+// Range is the sequence of integers from Start up to, but not including,
+// Stop, counting by Step. It is produced by the `range` builtin and only
+// computes its elements while being iterated.
+type Range struct {
+	Start int64
+	Stop  int64
+	Step  int64
+}
+
+func (r *Range) Type() ObjectType { return RANGE_OBJ }
+func (r *Range) Inspect() string {
+	if r.Step == 1 {
+		return fmt.Sprintf("range(%d, %d)", r.Start, r.Stop)
+	}
+	return fmt.Sprintf("range(%d, %d, %d)", r.Start, r.Stop, r.Step)
+}
+
+// length returns the number of integers in the range, at most
+// math.MaxInt64
+func (r *Range) length() int64 {
+	var n uint64
+	switch {
+	case r.Step > 0 && r.Start < r.Stop:
+		n = (uint64(r.Stop)-uint64(r.Start)-1)/uint64(r.Step) + 1
+	case r.Step < 0 && r.Start > r.Stop:
+		n = (uint64(r.Start)-uint64(r.Stop)-1)/uint64(-r.Step) + 1
+	}
+	if n > math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return int64(n)
+}
+
+// Iterator steps through the elements of an iterable object for for-in
+// loops. Arrays yield their elements, hashes their keys in insertion order,
+// strings their characters and ranges their integers.
+type Iterator struct {
+	next func() (Object, bool)
+}
+
+func (i *Iterator) Type() ObjectType { return ITERATOR_OBJ }
+func (i *Iterator) Inspect() string  { return "iterator" }
+
+// Next returns the next element, or false once the elements are exhausted
+func (i *Iterator) Next() (Object, bool) {
+	return i.next()
+}
+
+// NewIterator returns an iterator over obj, or false if obj is not iterable
+func NewIterator(obj Object) (*Iterator, bool) {
+	switch obj := obj.(type) {
+	case *Array:
+		return newSliceIterator(obj.Elements), true
+
+	case *Hash:
+		return newSliceIterator(obj.Keys()), true
+
+	case *String:
+		runes := []rune(obj.Value)
+		chars := make([]Object, len(runes))
+		for i, r := range runes {
+			chars[i] = &String{Value: string(r)}
+		}
+		return newSliceIterator(chars), true
+
+	case *Range:
+		current := obj.Start
+		return &Iterator{next: func() (Object, bool) {
+			if (obj.Step > 0 && current >= obj.Stop) || (obj.Step < 0 && current <= obj.Stop) {
+				return nil, false
+			}
+			value := &Integer{Value: current}
+			if (obj.Step > 0 && current > math.MaxInt64-obj.Step) || (obj.Step < 0 && current < math.MinInt64-obj.Step) {
+				// the next integer would overflow, so this is the last
+				current = obj.Stop
+			} else {
+				current += obj.Step
+			}
+			return value, true
+		}}, true
+
+	default:
+		return nil, false
+	}
+}
+
+func newSliceIterator(elements []Object) *Iterator {
+	i := 0
+	return &Iterator{next: func() (Object, bool) {
+		if i >= len(elements) {
+			return nil, false
+		}
+		i++
+		return elements[i-1], true
+	}}
+}
@@ -0,0 +1,138 @@
+package object
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// DefaultMaxDepth is the call depth allowed when Limits.MaxDepth is zero.
+// It keeps runaway recursion from overflowing the Go stack.
+const DefaultMaxDepth = 1000
+
+// contextCheckInterval is the number of steps between two checks of the
+// context, which are too slow to make on every step
+const contextCheckInterval = 256
+
+// The causes of the errors returned when a limit is exceeded. Errors from a
+// canceled or expired context have the context's error as their cause.
+var (
+	ErrStepLimit       = errors.New("step limit exceeded")
+	ErrDepthLimit      = errors.New("maximum call depth exceeded")
+	ErrAllocationLimit = errors.New("allocation limit exceeded")
+)
+
+// Limits bounds the resources a single evaluation may use. Zero fields are
+// unlimited, except MaxDepth which defaults to DefaultMaxDepth.
+type Limits struct {
+	// MaxSteps is the number of nodes the evaluator may evaluate, or of
+	// instructions the vm may execute
+	MaxSteps int64
+	// MaxDepth is the number of nested function calls
+	MaxDepth int
+	// MaxAllocation is a budget in bytes for the strings, arrays, hashes,
+	// functions and calls a program creates. Sizes are estimated, and
+	// memory freed by the garbage collector is not given back.
+	MaxAllocation int64
+}
+
+// State tracks the resources used by an evaluation and stops it, with an
+// *Error, once its context is done or one of its limits is exceeded
+type State struct {
+	ctx    context.Context
+	limits Limits
+
+	steps     int64
+	depth     int
+	allocated int64
+}
+
+// NewState returns the state of an evaluation running under ctx and limits
+func NewState(ctx context.Context, limits Limits) *State {
+	if limits.MaxDepth == 0 {
+		limits.MaxDepth = DefaultMaxDepth
+	}
+	return &State{ctx: ctx, limits: limits}
+}
+
+// Step records one evaluation step
+func (s *State) Step() *Error {
+	s.steps++
+	if s.limits.MaxSteps > 0 && s.steps > s.limits.MaxSteps {
+		return newLimitError(ErrStepLimit, "step limit exceeded: %d steps", s.limits.MaxSteps)
+	}
+	if s.steps%contextCheckInterval == 0 {
+		return s.Err()
+	}
+	return nil
+}
+
+// Err returns an error if the context of the evaluation is done
+func (s *State) Err() *Error {
+	if err := s.ctx.Err(); err != nil {
+		return newLimitError(err, "evaluation stopped: %s", err)
+	}
+	return nil
+}
+
+// Enter records a function call, to be undone by Leave when it returns
+func (s *State) Enter() *Error {
+	if s.depth >= s.limits.MaxDepth {
+		return newLimitError(ErrDepthLimit, "maximum call depth exceeded: %d calls", s.limits.MaxDepth)
+	}
+	s.depth++
+	return nil
+}
+
+// Leave records the return of a function call
+func (s *State) Leave() {
+	s.depth--
+}
+
+// Allocate records the allocation of size bytes. It is called before
+// allocating where the size is known up front, so that the budget stops an
+// allocation too large to be made; a refused allocation is not recorded.
+func (s *State) Allocate(size int64) *Error {
+	if s.limits.MaxAllocation > 0 && size > s.limits.MaxAllocation-s.allocated {
+		return newLimitError(ErrAllocationLimit, "allocation limit exceeded: %d bytes", s.limits.MaxAllocation)
+	}
+	s.allocated += size
+	return nil
+}
+
+// Sizes used to estimate allocations
+const (
+	CallSize    = 64
+	elementSize = 16
+	headerSize  = 32
+)
+
+// SizeOf estimates the number of bytes allocated to create obj, not
+// counting the objects it refers to. Values that need no allocation of
+// their own, such as integers and booleans, count as zero.
+func SizeOf(obj Object) int64 {
+	switch obj := obj.(type) {
+	case *String:
+		return StringSize(int64(len(obj.Value)))
+	case *Array:
+		return headerSize + elementSize*int64(len(obj.Elements))
+	case *Hash:
+		return headerSize + 2*elementSize*int64(len(obj.Pairs))
+	case *Function:
+		return headerSize
+	case *Closure:
+		return headerSize + elementSize*int64(len(obj.Free))
+	default:
+		return 0
+	}
+}
+
+// StringSize estimates the number of bytes allocated to create a string of
+// length bytes
+func StringSize(length int64) int64 {
+	return headerSize + length
+}
+
+func newLimitError(cause error, format string, a ...interface{}) *Error {
+	return &Error{Message: fmt.Sprintf(format, a...), Cause: cause}
+}
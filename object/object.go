@@ -5,7 +5,12 @@ import (
 	"fmt"
 	"hash/fnv"
 	"interpreters/ast"
+	"interpreters/code"
+	"interpreters/token"
+	"math"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 type ObjectType string
@@ -13,6 +18,7 @@ type BuiltinFunction func(args ...Object) Object
 
 const (
 	INTEGER_OBJ      = "INTEGER"
+	FLOAT_OBJ        = "FLOAT"
 	BOOLEAN_OBJ      = "BOOLEAN"
 	NULL_OBJ         = "NULL"
 	RETURN_VALUE_OBJ = "RETURN_VALUE"
@@ -22,6 +28,22 @@ const (
 	BUILTIN_OBJ      = "BUILTIN"
 	ARRAY_OBJ        = "ARRAY"
 	HASH_OBJ         = "OBJ"
+	RANGE_OBJ        = "RANGE"
+	ITERATOR_OBJ     = "ITERATOR"
+	BREAK_OBJ        = "BREAK"
+	CONTINUE_OBJ     = "CONTINUE"
+
+	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION_OBJ"
+	CLOSURE_OBJ           = "CLOSURE"
+	CELL_OBJ              = "CELL"
+)
+
+// NULL, TRUE and FALSE are shared by the evaluator, the vm and the builtins
+// so that objects can be compared by identity whichever produced them
+var (
+	NULL  = &Null{}
+	TRUE  = &Boolean{Value: true}
+	FALSE = &Boolean{Value: false}
 )
 
 type Object interface {
@@ -44,6 +66,26 @@ func (i *Integer) HashKey() HashKey {
 	return HashKey{Type: INTEGER_OBJ, Value: uint64(i.Value)}
 }
 
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+
+// Inspect always shows a decimal point or exponent, so that 2.0 does not
+// print like the integer 2
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if strings.ContainsAny(s, ".eIN") {
+		return s
+	}
+	return s + ".0"
+}
+
+func (f *Float) HashKey() HashKey {
+	return HashKey{Type: FLOAT_OBJ, Value: math.Float64bits(f.Value)}
+}
+
 type Boolean struct {
 	Value bool
 }
@@ -75,17 +117,77 @@ type ReturnValue struct {
 func (r *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 func (r *ReturnValue) Inspect() string  { return r.Value.Inspect() }
 
+// Break and Continue are produced by break and continue statements and
+// unwind the evaluation of a loop body, like ReturnValue does for functions
+type Break struct{}
+
+func (b *Break) Type() ObjectType { return BREAK_OBJ }
+func (b *Break) Inspect() string  { return "break" }
+
+type Continue struct{}
+
+func (c *Continue) Type() ObjectType { return CONTINUE_OBJ }
+func (c *Continue) Inspect() string  { return "continue" }
+
 type Error struct {
 	Message string
+	// Pos is where the error was raised
+	Pos token.Position
+	// Stack holds the function calls the error propagated through,
+	// innermost call first
+	Stack []Frame
+	// Cause is set on errors that stopped an evaluation before it
+	// finished, e.g. ErrStepLimit or context.DeadlineExceeded
+	Cause error
 }
 
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
 func (e *Error) Inspect() string  { return e.Message }
 
+// Error implements the error interface so that the vm can return runtime
+// errors from Run
+func (e *Error) Error() string { return e.Message }
+
+// Unwrap returns the cause of the error, so that errors.Is can tell the
+// limit that stopped an evaluation
+func (e *Error) Unwrap() error { return e.Cause }
+
+// Traceback renders the error along with the calls it propagated through,
+// most recent call last, e.g.
+//
+//	Traceback (most recent call last):
+//	  at 5:1, in <main>
+//	  at 2:3, in add
+//	error: type mismatch: INTEGER + BOOLEAN
+func (e *Error) Traceback() string {
+	var out bytes.Buffer
+
+	out.WriteString("Traceback (most recent call last):\n")
+	caller := "<main>"
+	for i := len(e.Stack) - 1; i >= 0; i-- {
+		out.WriteString(fmt.Sprintf("  at %s, in %s\n", e.Stack[i].Pos, caller))
+		caller = e.Stack[i].Function
+	}
+	out.WriteString(fmt.Sprintf("  at %s, in %s\n", e.Pos, caller))
+	out.WriteString("error: " + e.Message)
+
+	return out.String()
+}
+
+// Frame is a single function call in the stack of an Error
+type Frame struct {
+	// Function is the name of the called function or <anonymous>
+	Function string
+	// Pos is the position of the call expression
+	Pos token.Position
+}
+
 type Function struct {
 	Parameters []*ast.Identifier
 	Body       *ast.BlockStatement
 	Env        *Environment
+	// Name is the name the function was defined with, if any
+	Name string
 }
 
 func (f *Function) Type() ObjectType { return FUNCTION_OBJ }
@@ -115,6 +217,27 @@ type String struct {
 func (s *String) Type() ObjectType { return STRING_OBJ }
 func (s *String) Inspect() string  { return s.Value }
 
+// Len returns the length of the string in characters, that is Unicode code
+// points
+func (s *String) Len() int {
+	return utf8.RuneCountInString(s.Value)
+}
+
+// CharAt returns the character at index i, counting in code points, or
+// false if i is out of range
+func (s *String) CharAt(i int64) (*String, bool) {
+	if i < 0 {
+		return nil, false
+	}
+	for _, r := range s.Value {
+		if i == 0 {
+			return &String{Value: string(r)}, true
+		}
+		i--
+	}
+	return nil, false
+}
+
 func (s *String) HashKey() HashKey {
 	h := fnv.New64a()
 	h.Write([]byte(s.Value))
@@ -126,8 +249,61 @@ func (s *String) HashKey() HashKey {
 
 }
 
+// Apply calls fn, a function or builtin, with args on behalf of a builtin
+// and returns its result, or an *Error if the call failed
+type Apply func(fn Object, args ...Object) Object
+
+// HigherOrderFunction is a builtin that calls the functions it is passed
+// through apply, which runs them on the engine running the builtin
+type HigherOrderFunction func(apply Apply, args ...Object) Object
+
+// Builtin is a function implemented in Go. Only one of Fn and HigherOrder
+// is set.
 type Builtin struct {
-	Fn BuiltinFunction
+	Fn          BuiltinFunction
+	HigherOrder HigherOrderFunction
+	// Size, if set, returns the number of bytes a call with args allocates,
+	// for builtins whose arguments can make their result arbitrarily large.
+	// It is charged before the call rather than the size of the result.
+	Size func(args ...Object) int64
+}
+
+// Call calls the builtin with args, using apply for the functions that
+// higher order builtins call, and charges what it allocates to state.
+// Each function a higher order builtin calls counts as a step.
+func (b *Builtin) Call(apply Apply, state *State, args ...Object) Object {
+	if b.HigherOrder != nil {
+		apply = stepping(apply, state)
+	}
+	if b.Size != nil {
+		if err := state.Allocate(b.Size(args...)); err != nil {
+			return err
+		}
+		return b.call(apply, args)
+	}
+
+	result := b.call(apply, args)
+	if err := state.Allocate(SizeOf(result)); err != nil {
+		return err
+	}
+	return result
+}
+
+// stepping returns apply charging a step to state before each call
+func stepping(apply Apply, state *State) Apply {
+	return func(fn Object, args ...Object) Object {
+		if err := state.Step(); err != nil {
+			return err
+		}
+		return apply(fn, args...)
+	}
+}
+
+func (b *Builtin) call(apply Apply, args []Object) Object {
+	if b.HigherOrder != nil {
+		return b.HigherOrder(apply, args...)
+	}
+	return b.Fn(args...)
 }
 
 func (b *Builtin) Type() ObjectType { return BUILTIN_OBJ }
@@ -138,13 +314,23 @@ type Array struct {
 }
 
 func (al *Array) Type() ObjectType { return ARRAY_OBJ }
-func (al *Array) Inspect() string {
+func (al *Array) Inspect() string  { return al.inspect(nil) }
+
+// inspect returns the Inspect form of the array, an element of the arrays
+// and hashes in enclosing. Index assignment can make an array contain
+// itself, which is then shown as [...].
+func (al *Array) inspect(enclosing []Object) string {
+	if contains(enclosing, al) {
+		return "[...]"
+	}
+	enclosing = append(enclosing, al)
+
 	var out bytes.Buffer
 
 	out.WriteString("[")
 	elements := []string{}
 	for _, e := range al.Elements {
-		elements = append(elements, e.Inspect())
+		elements = append(elements, inspectElement(e, enclosing))
 	}
 	out.WriteString(strings.Join(elements, ","))
 	out.WriteString("]")
@@ -162,17 +348,78 @@ type HashPair struct {
 	Value Object
 }
 
+// Hash maps keys to values, remembering the order keys were first set in.
+// Pairs is for lookups; use NewHash, Set and Delete to build and change a
+// hash so that its order stays in step.
 type Hash struct {
 	Pairs map[HashKey]HashPair
+	// order lists the keys of Pairs in insertion order
+	order []HashKey
+}
+
+func NewHash() *Hash {
+	return &Hash{Pairs: make(map[HashKey]HashPair)}
+}
+
+// Set binds key, which must be Hashable, to value. A new key goes after
+// the existing ones, and an existing key keeps its place.
+func (h *Hash) Set(key Object, value Object) {
+	hashKey := key.(Hashable).HashKey()
+	if _, ok := h.Pairs[hashKey]; !ok {
+		h.order = append(h.order, hashKey)
+	}
+	h.Pairs[hashKey] = HashPair{Key: key, Value: value}
+}
+
+// Delete removes key, which must be Hashable, if it is set
+func (h *Hash) Delete(key Object) {
+	hashKey := key.(Hashable).HashKey()
+	if _, ok := h.Pairs[hashKey]; !ok {
+		return
+	}
+	delete(h.Pairs, hashKey)
+	for i, k := range h.order {
+		if k == hashKey {
+			h.order = append(h.order[:i:i], h.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// OrderedPairs returns the pairs of the hash in insertion order
+func (h *Hash) OrderedPairs() []HashPair {
+	pairs := make([]HashPair, len(h.order))
+	for i, key := range h.order {
+		pairs[i] = h.Pairs[key]
+	}
+	return pairs
+}
+
+// Keys returns the keys of the hash in insertion order
+func (h *Hash) Keys() []Object {
+	keys := make([]Object, len(h.order))
+	for i, key := range h.order {
+		keys[i] = h.Pairs[key].Key
+	}
+	return keys
 }
 
 func (h *Hash) Type() ObjectType { return HASH_OBJ }
-func (h *Hash) Inspect() string {
+func (h *Hash) Inspect() string  { return h.inspect(nil) }
+
+// inspect is Array.inspect for hashes, shown as {...} when they contain
+// themselves
+func (h *Hash) inspect(enclosing []Object) string {
+	if contains(enclosing, h) {
+		return "{...}"
+	}
+	enclosing = append(enclosing, h)
+
 	var out bytes.Buffer
 
 	pairs := []string{}
-	for _, pair := range h.Pairs {
-		pairs = append(pairs, fmt.Sprintf("%s : %s", pair.Key.Inspect(), pair.Key.Inspect()))
+	for _, pair := range h.OrderedPairs() {
+		pairs = append(pairs, fmt.Sprintf("%s : %s", pair.Key.Inspect(), inspectElement(pair.Value, enclosing)))
 	}
 	out.WriteString("{")
 	out.WriteString(strings.Join(pairs, ","))
@@ -180,3 +427,64 @@ func (h *Hash) Inspect() string {
 
 	return out.String()
 }
+
+// inspectElement returns the Inspect form of obj, held by the arrays and
+// hashes in enclosing
+func inspectElement(obj Object, enclosing []Object) string {
+	switch obj := obj.(type) {
+	case *Array:
+		return obj.inspect(enclosing)
+	case *Hash:
+		return obj.inspect(enclosing)
+	}
+	return obj.Inspect()
+}
+
+func contains(objects []Object, obj Object) bool {
+	for _, o := range objects {
+		if o == obj {
+			return true
+		}
+	}
+	return false
+}
+
+// CompiledFunction is a function literal compiled to bytecode by the compiler
+type CompiledFunction struct {
+	Instructions  code.Instructions
+	NumLocals     int
+	NumParameters int
+	// Name is the name the function was defined with, if any
+	Name string
+	// SourceMap gives the source position of each instruction
+	SourceMap code.SourceMap
+	// LocalNames and FreeNames name the local slots and the free variables
+	// by index, for error messages
+	LocalNames []string
+	FreeNames  []string
+}
+
+func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
+func (cf *CompiledFunction) Inspect() string {
+	return fmt.Sprintf("CompiledFunction[%p]", cf)
+}
+
+// Closure pairs a CompiledFunction with the free variables it captured
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []Object
+}
+
+func (c *Closure) Type() ObjectType { return CLOSURE_OBJ }
+func (c *Closure) Inspect() string {
+	return fmt.Sprintf("Closure[%p]", c)
+}
+
+// Cell boxes a variable captured by a closure so that the closure and the
+// scope that defined the variable share it. Cells never escape the vm.
+type Cell struct {
+	Value Object
+}
+
+func (c *Cell) Type() ObjectType { return CELL_OBJ }
+func (c *Cell) Inspect() string  { return c.Value.Inspect() }
@@ -1,6 +1,13 @@
 package object
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"interpreters/token"
+	"math"
+	"strings"
+	"testing"
+)
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "Hello World!"}
@@ -22,3 +29,110 @@ func TestStringHashKey(t *testing.T) {
 	}
 
 }
+
+func TestFloatInspect(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected string
+	}{
+		{2, "2.0"},
+		{0.25, "0.25"},
+		{-1.5, "-1.5"},
+		{1e21, "1e+21"},
+	}
+
+	for _, tt := range tests {
+		if actual := (&Float{Value: tt.value}).Inspect(); actual != tt.expected {
+			t.Errorf("Inspect() wrong. want: %q, got: %q", tt.expected, actual)
+		}
+	}
+}
+
+func TestHashOrder(t *testing.T) {
+	hash := NewHash()
+	for _, key := range []Object{
+		&String{Value: "b"}, &Integer{Value: 10}, TRUE, &Float{Value: 2.5}, &String{Value: "a"},
+	} {
+		hash.Set(key, &Integer{Value: 1})
+	}
+	hash.Set(&Integer{Value: 10}, &Integer{Value: 2})
+	hash.Delete(TRUE)
+	hash.Delete(&String{Value: "missing"})
+	hash.Set(TRUE, &Integer{Value: 3})
+
+	var keys []string
+	for _, key := range hash.Keys() {
+		keys = append(keys, key.Inspect())
+	}
+
+	expected := "b 10 2.5 a true"
+	if actual := strings.Join(keys, " "); actual != expected {
+		t.Errorf("Keys() wrong. want: %q, got: %q", expected, actual)
+	}
+
+	expectedInspect := "{b : 1,10 : 2,2.5 : 1,a : 1,true : 3}"
+	if actual := hash.Inspect(); actual != expectedInspect {
+		t.Errorf("Inspect() wrong. want: %q, got: %q", expectedInspect, actual)
+	}
+}
+
+func TestErrorTraceback(t *testing.T) {
+	err := &Error{
+		Message: "type mismatch: INTEGER + BOOLEAN",
+		Pos:     token.Position{Line: 2, Column: 14},
+		Stack: []Frame{
+			{Function: "inner", Pos: token.Position{Line: 4, Column: 10}},
+			{Function: "outer", Pos: token.Position{Line: 6, Column: 1}},
+		},
+	}
+
+	expected := `Traceback (most recent call last):
+  at 6:1, in <main>
+  at 4:10, in outer
+  at 2:14, in inner
+error: type mismatch: INTEGER + BOOLEAN`
+
+	if err.Traceback() != expected {
+		t.Errorf("err.Traceback() wrong. want:\n%s\ngot:\n%s", expected, err.Traceback())
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	state := NewState(context.Background(), Limits{MaxAllocation: 100})
+
+	if err := state.Allocate(math.MaxInt64); err == nil || !errors.Is(err, ErrAllocationLimit) {
+		t.Fatalf("expected ErrAllocationLimit, got: %v", err)
+	}
+	// the refused allocation is not charged
+	if err := state.Allocate(100); err != nil {
+		t.Fatalf("Allocate(100) returned error: %s", err)
+	}
+	if err := state.Allocate(1); err == nil {
+		t.Fatalf("expected the budget to be exhausted")
+	}
+}
+
+func TestContainersHoldingThemselves(t *testing.T) {
+	array := &Array{Elements: []Object{&Integer{Value: 1}, nil}}
+	array.Elements[1] = array
+	hash := NewHash()
+	hash.Set(&String{Value: "array"}, array)
+	hash.Set(&String{Value: "self"}, hash)
+
+	if actual := array.Inspect(); actual != "[1,[...]]" {
+		t.Errorf("array Inspect() wrong. got: %q", actual)
+	}
+	if actual := hash.Inspect(); actual != "{array : [1,[...]],self : {...}}" {
+		t.Errorf("hash Inspect() wrong. got: %q", actual)
+	}
+
+	other := &Array{Elements: []Object{&Integer{Value: 1}, nil}}
+	other.Elements[1] = other
+	if !Equal(array, other) {
+		t.Errorf("arrays holding themselves should be equal")
+	}
+	other.Elements[0] = &Integer{Value: 2}
+	if Equal(array, other) {
+		t.Errorf("arrays holding themselves should differ")
+	}
+}
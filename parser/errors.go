@@ -0,0 +1,30 @@
+package parser
+
+import (
+	"fmt"
+	"interpreters/token"
+	"strings"
+)
+
+// ParseError describes a single syntax error found by the parser
+type ParseError struct {
+	// Pos is where the offending token starts
+	Pos token.Position
+	// Expected holds the token types that would have been accepted, if known
+	Expected []token.Type
+	// Found is the token the parser got instead
+	Found   token.Token
+	Message string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Message)
+}
+
+func joinTypes(types []token.Type) string {
+	names := make([]string, len(types))
+	for i, t := range types {
+		names[i] = string(t)
+	}
+	return strings.Join(names, " or ")
+}
@@ -5,16 +5,20 @@ import (
 	"interpreters/ast"
 	"interpreters/lexer"
 	"interpreters/token"
+	"sort"
 	"strconv"
 )
 
 const (
 	_int = iota
 	LOWEST
+	ASSIGN      // = or +=
+	OR          // ||
+	AND         // &&
 	EQUALS      // ==
-	LESSGREATER // < or >
+	LESSGREATER // < > <= or >=
 	SUM         // +
-	PRODUCT     // *
+	PRODUCT     // * / or %
 	PREFIX      // -X or !X
 	CALL        // myFunction(X
 	INDEX
@@ -31,6 +35,18 @@ var precedences = map[token.Type]int{
 	token.SLASH:    PRODUCT,
 	token.ASTERISK: PRODUCT,
 	token.LBRACKET: INDEX,
+
+	token.ASSIGN:          ASSIGN,
+	token.PLUS_ASSIGN:     ASSIGN,
+	token.MINUS_ASSIGN:    ASSIGN,
+	token.ASTERISK_ASSIGN: ASSIGN,
+	token.SLASH_ASSIGN:    ASSIGN,
+
+	token.OR:      OR,
+	token.AND:     AND,
+	token.LT_EQ:   LESSGREATER,
+	token.GT_EQ:   LESSGREATER,
+	token.PERCENT: PRODUCT,
 }
 
 // prefix and infix parser functions are called depending on
@@ -44,17 +60,34 @@ type Parser struct {
 	l              *lexer.Lexer
 	curToken       token.Token
 	peekToken      token.Token
-	errors         []string
+	errors         []*ParseError
 	prefixParseFns map[token.Type]prefixParseFn
 	infixParseFns  map[token.Type]infixParseFn
+
+	// failures counts every error, including those suppressed while
+	// panicking, i.e. between the first error of a statement and the next
+	// synchronization point
+	failures  int
+	panicking bool
+	// closedBrace is the position of the last } that ended a block
+	closedBrace token.Position
+	// loopDepth counts the loops enclosing the current statement within
+	// the current function, to reject a stray break or continue
+	loopDepth int
+
+	// pending holds the COMMENT tokens read but not yet attached to a
+	// statement, and comments those attached so far
+	pending  []token.Token
+	comments []*ast.Comment
 }
 
 func New(l *lexer.Lexer) *Parser {
-	p := &Parser{l: l, errors: []string{}}
+	p := &Parser{l: l, errors: []*ParseError{}}
 
 	p.prefixParseFns = make(map[token.Type]prefixParseFn)
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
 	p.registerPrefix(token.TRUE, p.parseBoolean)
@@ -63,8 +96,10 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.IF, p.parseIfExpression)
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
+	p.registerPrefix(token.TEMPLATE_HEAD, p.parseInterpolatedString)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
 	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
+	p.registerPrefix(token.ERROR, p.parseLexerError)
 
 	p.infixParseFns = make(map[token.Type]infixParseFn)
 	p.registerInfix(token.EQ, p.parseInfixExpression)
@@ -75,8 +110,18 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.MINUS, p.parseInfixExpression)
 	p.registerInfix(token.SLASH, p.parseInfixExpression)
 	p.registerInfix(token.ASTERISK, p.parseInfixExpression)
+	p.registerInfix(token.PERCENT, p.parseInfixExpression)
+	p.registerInfix(token.LT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.GT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.AND, p.parseInfixExpression)
+	p.registerInfix(token.OR, p.parseInfixExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
+	p.registerInfix(token.ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.PLUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.MINUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.ASTERISK_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.SLASH_ASSIGN, p.parseAssignExpression)
 
 	// We call nextToken twice to set curToken and peekToken.
 	// We need to do this because we need to know peekToken ( the next token to be parsed) to make decisions while
@@ -87,13 +132,68 @@ func New(l *lexer.Lexer) *Parser {
 	return p
 }
 
+// Errors returns every syntax error as a "line:col: message" string
 func (p *Parser) Errors() []string {
+	messages := make([]string, len(p.errors))
+	for i, err := range p.errors {
+		messages[i] = err.Error()
+	}
+	return messages
+}
+
+// ParseErrors returns the syntax errors found by ParseProgram in source order
+func (p *Parser) ParseErrors() []*ParseError {
 	return p.errors
 }
 
+// addError records err unless the parser is still recovering from an
+// earlier error in the same statement, where it would only be noise
+func (p *Parser) addError(err *ParseError) {
+	p.failures++
+	if p.panicking {
+		return
+	}
+	p.panicking = true
+	p.errors = append(p.errors, err)
+}
+
+// synchronize skips the remainder of a statement that failed to parse so
+// that a single mistake is reported once. It stops on a semicolon or right
+// before `let`, `return`, a loop or a closing brace, skipping over nested
+// blocks.
+func (p *Parser) synchronize() {
+	depth := 0
+	for !p.curTokenIs(token.EOF) && !p.peekTokenIs(token.EOF) {
+		switch p.curToken.Type {
+		case token.LBRACE:
+			depth++
+		case token.RBRACE:
+			if depth > 0 {
+				depth--
+			}
+		}
+
+		if depth == 0 {
+			if p.curTokenIs(token.SEMICOLON) {
+				break
+			}
+			if p.peekTokenIs(token.LET) || p.peekTokenIs(token.RETURN) || p.peekTokenIs(token.RBRACE) ||
+				p.peekTokenIs(token.WHILE) || p.peekTokenIs(token.FOR) {
+				break
+			}
+		}
+		p.nextToken()
+	}
+	p.panicking = false
+}
+
 func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
 	p.peekToken = p.l.NextToken()
+	for p.peekToken.Type == token.COMMENT {
+		p.pending = append(p.pending, p.peekToken)
+		p.peekToken = p.l.NextToken()
+	}
 }
 
 func (p *Parser) ParseProgram() *ast.Program {
@@ -101,22 +201,77 @@ func (p *Parser) ParseProgram() *ast.Program {
 	program.Statements = []ast.Statement{}
 
 	for p.curToken.Type != token.EOF {
+		failures := p.failures
+		leading := p.leadingComments()
 		statement := p.parseStatement()
-		if statement != nil {
+		if p.failures > failures {
+			p.synchronize()
+			statement = nil
+		} else if statement != nil {
 			program.Statements = append(program.Statements, statement)
 		}
+		p.attachComments(statement, leading)
 		p.nextToken()
 	}
 
+	for _, tok := range p.pending {
+		p.comments = append(p.comments, &ast.Comment{Token: tok})
+	}
+	// the statements of a block take their comments before the statement
+	// containing the block does
+	sort.SliceStable(p.comments, func(i, j int) bool {
+		return p.comments[i].Token.Pos.Offset < p.comments[j].Token.Pos.Offset
+	})
+	program.Comments = p.comments
+
 	return program
 }
 
+// leadingComments takes the pending comments before the current token,
+// which belong to the statement starting at it, so that the statements
+// nested in it do not take them
+func (p *Parser) leadingComments() []token.Token {
+	n := 0
+	for n < len(p.pending) && p.pending[n].Pos.Offset < p.curToken.Pos.Offset {
+		n++
+	}
+	leading := p.pending[:n:n]
+	p.pending = p.pending[n:]
+	return leading
+}
+
+// attachComments attaches the leading comments of node, the statement
+// just parsed, and the pending comments inside it to it. For a nil node,
+// e.g. a statement that failed to parse, the leading comments go back to
+// pending.
+func (p *Parser) attachComments(node ast.Statement, leading []token.Token) {
+	if node == nil {
+		p.pending = append(leading, p.pending...)
+		return
+	}
+
+	n := 0
+	for n < len(p.pending) && p.pending[n].Pos.Offset < node.End().Offset {
+		n++
+	}
+	for _, tok := range append(leading, p.pending[:n]...) {
+		p.comments = append(p.comments, &ast.Comment{Token: tok, Node: node})
+	}
+	p.pending = p.pending[n:]
+}
+
 func (p *Parser) parseStatement() ast.Statement {
 	switch p.curToken.Type {
 	case token.LET:
 		return p.parseLetStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.WHILE:
+		return p.parseWhileStatement()
+	case token.FOR:
+		return p.parseForStatement()
+	case token.BREAK, token.CONTINUE:
+		return p.parseLoopControlStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
@@ -145,6 +300,9 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	p.nextToken()
 
 	letStatement.Value = p.parseExpression(LOWEST)
+	if fn, ok := letStatement.Value.(*ast.FunctionLiteral); ok {
+		fn.Name = letStatement.Name.Value
+	}
 
 	// skip all expressions
 	if p.peekTokenIs(token.SEMICOLON) {
@@ -167,6 +325,97 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return returnStatement
 }
 
+// parseWhileStatement parses while (x < 10) { ... }
+func (p *Parser) parseWhileStatement() ast.Statement {
+	statement := &ast.WhileStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	p.nextToken()
+
+	statement.Condition = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	statement.Body = p.parseLoopBody()
+
+	return statement
+}
+
+// parseForStatement parses for (x in iterable) { ... }
+func (p *Parser) parseForStatement() ast.Statement {
+	statement := &ast.ForStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	statement.Variable = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if !p.expectPeek(token.IN) {
+		return nil
+	}
+	p.nextToken()
+
+	statement.Iterable = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	statement.Body = p.parseLoopBody()
+
+	return statement
+}
+
+func (p *Parser) parseLoopBody() *ast.BlockStatement {
+	p.loopDepth++
+	body := p.parseBlockStatement()
+	p.loopDepth--
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return body
+}
+
+// parseLoopControlStatement parses break and continue, which are only
+// allowed inside a loop of the function being parsed
+func (p *Parser) parseLoopControlStatement() ast.Statement {
+	if p.loopDepth == 0 {
+		p.curError("%s outside of a loop", p.curToken.Literal)
+		return nil
+	}
+
+	var statement ast.Statement
+	if p.curTokenIs(token.BREAK) {
+		statement = &ast.BreakStatement{Token: p.curToken}
+	} else {
+		statement = &ast.ContinueStatement{Token: p.curToken}
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return statement
+}
+
 func (p *Parser) expectPeek(t token.Type) bool {
 	if p.peekTokenIs(t) {
 		p.nextToken()
@@ -184,9 +433,22 @@ func (p *Parser) peekTokenIs(t token.Type) bool {
 	return p.peekToken.Type == t
 }
 
-func (p *Parser) peekError(t token.Type) {
-	message := fmt.Sprintf("expected next token to be %s, got: %s", t, p.peekToken.Type)
-	p.errors = append(p.errors, message)
+func (p *Parser) peekError(expected ...token.Type) {
+	p.addError(&ParseError{
+		Pos:      p.peekToken.Pos,
+		Expected: expected,
+		Found:    p.peekToken,
+		Message:  fmt.Sprintf("expected next token to be %s, got: %s", joinTypes(expected), p.peekToken.Type),
+	})
+}
+
+// curError records an error about the current token
+func (p *Parser) curError(format string, a ...interface{}) {
+	p.addError(&ParseError{
+		Pos:     p.curToken.Pos,
+		Found:   p.curToken,
+		Message: fmt.Sprintf(format, a...),
+	})
 }
 
 func (p *Parser) registerPrefix(tokenType token.Type, fn prefixParseFn) {
@@ -243,8 +505,7 @@ func (p *Parser) parseBoolean() ast.Expression {
 
 	booleanValue, err := strconv.ParseBool(p.curToken.Literal)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %s as boolean", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.curError("could not parse %s as boolean", p.curToken.Literal)
 		return nil
 	}
 
@@ -259,8 +520,21 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 
 	value, err := strconv.ParseInt(lit.Token.Literal, 0, 64)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.curError("could not parse %q as integer", p.curToken.Literal)
+		return nil
+	}
+
+	lit.Value = value
+
+	return lit
+}
+
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	lit := &ast.FloatLiteral{Token: p.curToken}
+
+	value, err := strconv.ParseFloat(lit.Token.Literal, 64)
+	if err != nil {
+		p.curError("could not parse %q as float", p.curToken.Literal)
 		return nil
 	}
 
@@ -281,8 +555,7 @@ func (p *Parser) parseGroupedExpression() ast.Expression {
 }
 
 func (p *Parser) noPrefixParseFnError(t token.Type) {
-	msg := fmt.Sprintf("could not find any prefixParseFn for given token type: %s", t)
-	p.errors = append(p.errors, msg)
+	p.curError("could not find any prefixParseFn for given token type: %s", t)
 }
 
 func (p *Parser) parsePrefixExpression() ast.Expression {
@@ -314,6 +587,28 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	return expression
 }
 
+// parseAssignExpression parses x = 5 and arr[0] += 1. Assignment is right
+// associative, so a = b = 1 assigns 1 to both.
+func (p *Parser) parseAssignExpression(target ast.Expression) ast.Expression {
+	switch target.(type) {
+	case *ast.Identifier, *ast.IndexExpression:
+	default:
+		p.curError("cannot assign to %s", target.String())
+		return nil
+	}
+
+	expression := &ast.AssignExpression{
+		Token:    p.curToken,
+		Target:   target,
+		Operator: p.curToken.Literal,
+	}
+
+	p.nextToken()
+	expression.Value = p.parseExpression(ASSIGN - 1)
+
+	return expression
+}
+
 func (p *Parser) peekPrecedence() int {
 	if p, ok := precedences[p.peekToken.Type]; ok {
 		return p
@@ -370,13 +665,31 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	p.nextToken()
 
 	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
+		failures := p.failures
+		leading := p.leadingComments()
 		stmt := p.parseStatement()
-		if stmt != nil {
-			block.Statements = append(block.Statements, stmt)
+		if p.failures > failures {
+			p.attachComments(nil, leading)
+			// a failed expression can stop on the closing brace of this
+			// block, as opposed to one that closed a nested block
+			if p.curTokenIs(token.RBRACE) && p.curToken.Pos != p.closedBrace {
+				break
+			}
+			p.synchronize()
+		} else {
+			if stmt != nil {
+				block.Statements = append(block.Statements, stmt)
+			}
+			p.attachComments(stmt, leading)
 		}
 		p.nextToken()
 	}
 
+	if p.curTokenIs(token.RBRACE) {
+		block.Rbrace = p.curToken
+		p.closedBrace = p.curToken.Pos
+	}
+
 	return block
 }
 
@@ -394,7 +707,11 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 		return nil
 	}
 
+	// loops around the function literal do not extend into its body
+	loopDepth := p.loopDepth
+	p.loopDepth = 0
 	functionLiteral.Body = p.parseBlockStatement()
+	p.loopDepth = loopDepth
 
 	return functionLiteral
 }
@@ -428,9 +745,42 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	expression := &ast.CallExpression{Token: p.curToken, Function: function}
 	expression.Arguments = p.parseExpressionList(token.RPAREN)
+	if p.curTokenIs(token.RPAREN) {
+		expression.Rparen = p.curToken
+	}
 	return expression
 }
 
+// parseInterpolatedString parses a string with embedded expressions, from
+// its TEMPLATE_HEAD token to its TEMPLATE_TAIL token
+func (p *Parser) parseInterpolatedString() ast.Expression {
+	str := &ast.InterpolatedString{
+		Token:   p.curToken,
+		Strings: []string{p.curToken.Literal},
+	}
+
+	for !p.curTokenIs(token.TEMPLATE_TAIL) {
+		p.nextToken()
+		str.Expressions = append(str.Expressions, p.parseExpression(LOWEST))
+
+		if !p.peekTokenIs(token.TEMPLATE_MIDDLE) && !p.peekTokenIs(token.TEMPLATE_TAIL) {
+			p.peekError(token.RBRACE)
+			return nil
+		}
+		p.nextToken()
+		str.Strings = append(str.Strings, p.curToken.Literal)
+	}
+	str.Tail = p.curToken
+
+	return str
+}
+
+// parseLexerError reports the message of an ERROR token from the lexer
+func (p *Parser) parseLexerError() ast.Expression {
+	p.curError("%s", p.curToken.Literal)
+	return nil
+}
+
 func (p *Parser) parseStringLiteral() ast.Expression {
 	return &ast.StringLiteral{
 		Token: p.curToken,
@@ -441,6 +791,9 @@ func (p *Parser) parseStringLiteral() ast.Expression {
 func (p *Parser) parseArrayLiteral() ast.Expression {
 	arrayLiteral := &ast.ArrayLiteral{Token: p.curToken}
 	arrayLiteral.Elements = p.parseExpressionList(token.RBRACKET)
+	if p.curTokenIs(token.RBRACKET) {
+		arrayLiteral.Rbracket = p.curToken
+	}
 	return arrayLiteral
 }
 
@@ -461,27 +814,49 @@ func (p *Parser) parseExpressionList(end token.Type) []ast.Expression {
 		list = append(list, p.parseExpression(LOWEST))
 	}
 
-	if !p.expectPeek(end) {
+	if !p.peekTokenIs(end) {
+		p.peekError(token.COMMA, end)
 		return nil
 	}
+	p.nextToken()
 	return list
 }
 
+// parseIndexExpression parses left[index], or the slice left[start:end]
+// where both bounds are optional
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
-	indexExpression := &ast.IndexExpression{Token: p.curToken, Left: left}
+	lbracket := p.curToken
 
-	p.nextToken()
-	indexExpression.Index = p.parseExpression(LOWEST)
+	var index ast.Expression
+	if !p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		index = p.parseExpression(LOWEST)
+	}
+
+	if p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		slice := &ast.SliceExpression{Token: lbracket, Left: left, Start: index}
+		if !p.peekTokenIs(token.RBRACKET) {
+			p.nextToken()
+			slice.Stop = p.parseExpression(LOWEST)
+		}
+		if !p.expectPeek(token.RBRACKET) {
+			return nil
+		}
+		slice.Rbracket = p.curToken
+		return slice
+	}
 
+	indexExpression := &ast.IndexExpression{Token: lbracket, Left: left, Index: index}
 	if !p.expectPeek(token.RBRACKET) {
 		return nil
 	}
+	indexExpression.Rbracket = p.curToken
 	return indexExpression
 }
 
 func (p *Parser) parseHashLiteral() ast.Expression {
 	hash := &ast.HashLiteral{Token: p.curToken}
-	hash.Pairs = make(map[ast.Expression]ast.Expression)
 
 	for !p.peekTokenIs(token.RBRACE) {
 		p.nextToken()
@@ -493,7 +868,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 
 		p.nextToken()
 		value := p.parseExpression(LOWEST)
-		hash.Pairs[key] = value
+		hash.Pairs = append(hash.Pairs, ast.HashPair{Key: key, Value: value})
 
 		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
 			return nil
@@ -504,5 +879,6 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 	if !p.expectPeek(token.RBRACE) {
 		return nil
 	}
+	hash.Rbrace = p.curToken
 	return hash
 }
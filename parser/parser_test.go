@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"interpreters/ast"
 	"interpreters/lexer"
+	"interpreters/token"
 	"testing"
 )
 
@@ -131,6 +132,41 @@ func TestStringLiteralExpression(t *testing.T) {
 
 }
 
+func TestInterpolatedStringParsing(t *testing.T) {
+	input := `"Hello ${name}, ${x + y}!"`
+	l := lexer.New(input)
+	p := New(l)
+
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	str, ok := stmt.Expression.(*ast.InterpolatedString)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.InterpolatedString, got: %T", stmt.Expression)
+	}
+
+	expectedStrings := []string{"Hello ", ", ", "!"}
+	if len(str.Strings) != len(expectedStrings) {
+		t.Fatalf("wrong number of strings. want: %d, got: %d", len(expectedStrings), len(str.Strings))
+	}
+	for i, s := range expectedStrings {
+		if str.Strings[i] != s {
+			t.Errorf("str.Strings[%d] wrong. want: %q, got: %q", i, s, str.Strings[i])
+		}
+	}
+
+	if len(str.Expressions) != 2 {
+		t.Fatalf("wrong number of expressions. want: 2, got: %d", len(str.Expressions))
+	}
+	testIdentifier(t, str.Expressions[0], "name")
+	testInfixExpression(t, str.Expressions[1], "x", "+", "y")
+
+	if str.End().Offset != len(input) {
+		t.Errorf("str.End() wrong. want offset %d, got: %d", len(input), str.End().Offset)
+	}
+}
+
 func TestParsingArrayLiteral(t *testing.T) {
 	input := "[1, 2 * 2, 3 + 3]"
 	l := lexer.New(input)
@@ -191,6 +227,33 @@ func TestParsingIndexExpression(t *testing.T) {
 
 }
 
+func TestParsingSliceExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"a[1:2]", "(a[1:2])"},
+		{"a[:n - 1]", "(a[:(n - 1)])"},
+		{"a[1:]", "(a[1:])"},
+		{"a[:]", "(a[:])"},
+		{"a[1:][0]", "((a[1:])[0])"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		statement := program.Statements[0].(*ast.ExpressionStatement)
+		if _, ok := statement.Expression.(*ast.SliceExpression); !ok && tt.input != "a[1:][0]" {
+			t.Errorf("%q: expression is not *ast.SliceExpression, got: %T", tt.input, statement.Expression)
+		}
+		if actual := program.String(); actual != tt.expected {
+			t.Errorf("%q: wrong String(). want: %q, got: %q", tt.input, tt.expected, actual)
+		}
+	}
+}
+
 func TestParsingHashLiteralsStringKeys(t *testing.T) {
 	input := `{"one": 1, "two": 2, "three": 3}`
 	l := lexer.New(input)
@@ -213,19 +276,29 @@ func TestParsingHashLiteralsStringKeys(t *testing.T) {
 		t.Fatalf("statement.Expression is not ast.HashLiteral. got: %T", statement.Expression)
 	}
 
-	expected := map[string]int64{
-		"one":   1,
-		"two":   2,
-		"three": 3,
+	expected := []struct {
+		key   string
+		value int64
+	}{
+		{"one", 1},
+		{"two", 2},
+		{"three", 3},
+	}
+
+	if len(hashLiteral.Pairs) != len(expected) {
+		t.Fatalf("hashLiteral.Pairs has %d pairs, want: %d", len(hashLiteral.Pairs), len(expected))
 	}
 
-	for key, value := range hashLiteral.Pairs {
-		literal, ok := key.(*ast.StringLiteral)
+	// pairs keep their source order
+	for i, pair := range hashLiteral.Pairs {
+		literal, ok := pair.Key.(*ast.StringLiteral)
 		if !ok {
-			t.Fatalf("%s is not *ast.StringLiteral, got: %T", key, key)
+			t.Fatalf("%s is not *ast.StringLiteral, got: %T", pair.Key, pair.Key)
 		}
-		testIntegerLiteral(t, value, expected[literal.String()])
-
+		if literal.String() != expected[i].key {
+			t.Errorf("pair %d has key %q, want: %q", i, literal.String(), expected[i].key)
+		}
+		testIntegerLiteral(t, pair.Value, expected[i].value)
 	}
 }
 
@@ -324,6 +397,34 @@ func TestIntegerLiterals(t *testing.T) {
 
 }
 
+func TestFloatLiterals(t *testing.T) {
+	input := "3.14;"
+
+	l := lexer.New(input)
+	p := New(l)
+
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program has not enough statement, got: %d", len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not an expression statement, got: %T", program.Statements[0])
+	}
+
+	literal, ok := stmt.Expression.(*ast.FloatLiteral)
+	if !ok {
+		t.Fatalf("program.Statemet[0].Expression is not a FloatLiteral, got: %T", stmt.Expression)
+	}
+
+	if literal.Value != 3.14 {
+		t.Fatalf("literal.Value != %g, got: %g", 3.14, literal.Value)
+	}
+}
+
 func TestParsingPrefixExpressions(t *testing.T) {
 	prefixTests := []struct {
 		input    string
@@ -579,6 +680,30 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"a + add(b * c) + d",
 			"((a + add((b * c))) + d)",
 		},
+		{
+			"a || b && c == d",
+			"(a || (b && (c == d)))",
+		},
+		{
+			"a && b || c <= d + e % f",
+			"((a && b) || (c <= (d + (e % f))))",
+		},
+		{
+			"x = a >= b || !c",
+			"(x = ((a >= b) || (!c)))",
+		},
+		{
+			"x = y = 1 + 2",
+			"(x = (y = (1 + 2)))",
+		},
+		{
+			"a[i + 1] += b * 2",
+			"((a[(i + 1)]) += (b * 2))",
+		},
+		{
+			"x -= 1 == 2",
+			"(x -= (1 == 2))",
+		},
 	}
 
 	for _, tt := range tests {
@@ -646,6 +771,68 @@ func TestIfElseExpression(t *testing.T) {
 
 }
 
+func TestWhileStatement(t *testing.T) {
+	input := `while (x < y) { x; break; }`
+
+	l := lexer.New(input)
+	p := New(l)
+
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements is not %d, got %d", 1, len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.WhileStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not an *ast.WhileStatement, got: %T", program.Statements[0])
+	}
+
+	if !testInfixExpression(t, stmt.Condition, "x", "<", "y") {
+		return
+	}
+
+	if len(stmt.Body.Statements) != 2 {
+		t.Fatalf("stmt.Body.Statements is not %d, got: %d", 2, len(stmt.Body.Statements))
+	}
+
+	if _, ok := stmt.Body.Statements[1].(*ast.BreakStatement); !ok {
+		t.Fatalf("stmt.Body.Statements[1] is not an *ast.BreakStatement, got: %T", stmt.Body.Statements[1])
+	}
+}
+
+func TestForStatement(t *testing.T) {
+	input := `for (x in [1, 2]) { if (x) { continue; } }`
+
+	l := lexer.New(input)
+	p := New(l)
+
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements is not %d, got %d", 1, len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.ForStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not an *ast.ForStatement, got: %T", program.Statements[0])
+	}
+
+	if stmt.Variable.Value != "x" {
+		t.Errorf("stmt.Variable is not %q, got: %q", "x", stmt.Variable.Value)
+	}
+
+	if stmt.Iterable.String() != "[1,2]" {
+		t.Errorf("stmt.Iterable is not %q, got: %q", "[1,2]", stmt.Iterable.String())
+	}
+
+	if stmt.String() != "for(x in [1,2]) ifx continue;" {
+		t.Errorf("stmt.String() wrong, got: %q", stmt.String())
+	}
+}
+
 func TestFunctionLiteralParsing(t *testing.T) {
 	input := `fn(x, y) { x + y; }`
 	l := lexer.New(input)
@@ -790,3 +977,217 @@ func checkParserErrors(t *testing.T, p *Parser) {
 
 	t.FailNow()
 }
+
+func TestNodePositions(t *testing.T) {
+	input := `let add = fn(x, y) {
+  x + y
+};
+add(1, [2, 3][0]);`
+
+	l := lexer.NewFile("test.mk", input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	letStmt := program.Statements[0].(*ast.LetStatement)
+	fnLiteral := letStmt.Value.(*ast.FunctionLiteral)
+	body := fnLiteral.Body.Statements[0].(*ast.ExpressionStatement)
+	call := program.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.CallExpression)
+	index := call.Arguments[1].(*ast.IndexExpression)
+
+	tests := []struct {
+		node     ast.Node
+		expected string
+		end      string
+	}{
+		{letStmt, "test.mk:1:1", "test.mk:3:2"},
+		{fnLiteral, "test.mk:1:11", "test.mk:3:2"},
+		{body, "test.mk:2:3", "test.mk:2:8"},
+		{call, "test.mk:4:1", "test.mk:4:18"},
+		{index, "test.mk:4:8", "test.mk:4:17"},
+		{program, "test.mk:1:1", "test.mk:4:18"},
+	}
+
+	for _, tt := range tests {
+		if tt.node.Pos().String() != tt.expected {
+			t.Errorf("%s: Pos() wrong. want: %s, got: %s", tt.node.String(), tt.expected, tt.node.Pos())
+		}
+		if tt.node.End().String() != tt.end {
+			t.Errorf("%s: End() wrong. want: %s, got: %s", tt.node.String(), tt.end, tt.node.End())
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// add returns
+// the sum
+let add = fn(a, b) {
+  // inside
+  a + b /* trailing */
+};
+add(1, 2); // the answer
+// the end`
+
+	l := lexer.New(input)
+	l.KeepComments()
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Statements has %d len, want: 2", len(program.Statements))
+	}
+	let := program.Statements[0].(*ast.LetStatement)
+	body := let.Value.(*ast.FunctionLiteral).Body
+
+	tests := []struct {
+		text string
+		node ast.Node
+	}{
+		{"// add returns", let},
+		{"// the sum", let},
+		{"// inside", body.Statements[0]},
+		{"/* trailing */", let},
+		{"// the answer", nil},
+		{"// the end", nil},
+	}
+
+	if len(program.Comments) != len(tests) {
+		t.Fatalf("program.Comments has %d len, want: %d", len(program.Comments), len(tests))
+	}
+	for i, tt := range tests {
+		comment := program.Comments[i]
+		if comment.Token.Literal != tt.text {
+			t.Errorf("comments[%d] wrong. want: %q, got: %q", i, tt.text, comment.Token.Literal)
+		}
+		if comment.Node != tt.node {
+			t.Errorf("comments[%d] attached to the wrong node. want: %v, got: %v", i, tt.node, comment.Node)
+		}
+	}
+
+	if docs := program.CommentsOf(let); len(docs) != 3 {
+		t.Errorf("CommentsOf(let) has %d len, want: 3", len(docs))
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedErrors []string
+		expectedStmts  int
+	}{
+		{
+			"let x 5;\nlet = 10;\nlet 838383;",
+			[]string{
+				"1:7: expected next token to be =, got: INT",
+				"2:5: expected next token to be IDENT, got: =",
+				"3:5: expected next token to be IDENT, got: INT",
+			},
+			0,
+		},
+		{
+			"let x = 5 +;\nlet y = 1;",
+			[]string{"1:12: could not find any prefixParseFn for given token type: ;"},
+			1,
+		},
+		{
+			"add(1, 2 3);\nlet y = 2;",
+			[]string{"1:10: expected next token to be , or ), got: INT"},
+			1,
+		},
+		{
+			"let f = fn() {\n  if (a) { 1 + }\n  let = 2;\n};\nlet z = 1;",
+			[]string{
+				"2:16: could not find any prefixParseFn for given token type: }",
+				"3:7: expected next token to be IDENT, got: =",
+			},
+			1,
+		},
+		{
+			"fn(x { x }; let y = 2; return y;",
+			[]string{"1:6: expected next token to be ), got: {"},
+			2,
+		},
+		{
+			"break;\nwhile (true) { let f = fn() { continue; }; }",
+			[]string{
+				"1:1: break outside of a loop",
+				"2:31: continue outside of a loop",
+			},
+			0,
+		},
+		{
+			"1 = 2;\nf() += 1;\nx = 3;",
+			[]string{
+				"1:3: cannot assign to 1",
+				"2:5: cannot assign to f()",
+			},
+			1,
+		},
+		{
+			"for (x of xs) { x }\nlet y = 1;",
+			[]string{"1:8: expected next token to be IN, got: IDENT"},
+			1,
+		},
+		{
+			"let a = \"${1 2}\";\nlet b = \"${}\";\nlet c = 1;",
+			[]string{
+				"1:14: expected next token to be }, got: INT",
+				"2:12: could not find any prefixParseFn for given token type: TEMPLATE_TAIL",
+			},
+			1,
+		},
+		{
+			"let a = \"tab\\q\";\nlet b = \"open;\nlet c = 1;",
+			[]string{
+				"1:9: unknown escape sequence \\q",
+				"2:9: unterminated string",
+			},
+			1,
+		},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != len(tt.expectedErrors) {
+			t.Errorf("input %q: expected %d errors, got: %d %q", tt.input, len(tt.expectedErrors), len(errors), errors)
+			continue
+		}
+
+		for i, message := range tt.expectedErrors {
+			if errors[i] != message {
+				t.Errorf("input %q: errors[%d] wrong. want: %q, got: %q", tt.input, i, message, errors[i])
+			}
+		}
+
+		if len(program.Statements) != tt.expectedStmts {
+			t.Errorf("input %q: expected %d statements to survive, got: %d", tt.input, tt.expectedStmts, len(program.Statements))
+		}
+	}
+}
+
+func TestParseErrorDetails(t *testing.T) {
+	l := lexer.NewFile("test.mk", "let x 5;")
+	p := New(l)
+	p.ParseProgram()
+
+	errors := p.ParseErrors()
+	if len(errors) != 1 {
+		t.Fatalf("expected 1 error, got: %d", len(errors))
+	}
+
+	err := errors[0]
+	if err.Pos.String() != "test.mk:1:7" {
+		t.Errorf("err.Pos wrong. want: %s, got: %s", "test.mk:1:7", err.Pos)
+	}
+	if len(err.Expected) != 1 || err.Expected[0] != token.ASSIGN {
+		t.Errorf("err.Expected wrong. want: [%s], got: %v", token.ASSIGN, err.Expected)
+	}
+	if err.Found.Type != token.INT || err.Found.Literal != "5" {
+		t.Errorf("err.Found wrong. want: INT 5, got: %s %s", err.Found.Type, err.Found.Literal)
+	}
+}
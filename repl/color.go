@@ -0,0 +1,111 @@
+package repl
+
+import (
+	"interpreters/lexer"
+	"interpreters/token"
+	"io"
+	"os"
+	"strings"
+)
+
+// ANSI escape sequences for the colors of the REPL
+const (
+	colorReset   = "\x1b[0m"
+	colorRed     = "\x1b[31m"
+	colorGreen   = "\x1b[32m"
+	colorYellow  = "\x1b[33m"
+	colorMagenta = "\x1b[35m"
+	colorCyan    = "\x1b[36m"
+	colorGray    = "\x1b[90m"
+)
+
+// useColor reports whether out is a terminal and NO_COLOR is not set
+func useColor(out io.Writer) bool {
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+	f, ok := out.(*os.File)
+	return ok && isTerminal(f.Fd())
+}
+
+// paint wraps s in color, when the REPL uses colors
+func (r *repl) paint(color string, s string) string {
+	if !r.color {
+		return s
+	}
+	return color + s + colorReset
+}
+
+// highlight colors the keywords, strings, numbers, operators and comments
+// of line, as well as the input the lexer could not read
+func highlight(line string) string {
+	var out strings.Builder
+
+	l := lexer.New(line)
+	l.KeepComments()
+	last := 0
+	for {
+		tok := l.NextToken()
+		if tok.Type == token.EOF {
+			break
+		}
+
+		out.WriteString(line[last:tok.Pos.Offset])
+		text := line[tok.Pos.Offset:tok.End.Offset]
+		if color := tokenColor(tok); color != "" {
+			text = color + text + colorReset
+		}
+		out.WriteString(text)
+		last = tok.End.Offset
+	}
+	out.WriteString(line[last:])
+
+	return out.String()
+}
+
+// tokenColor returns the color of tok in highlighted input, or "" to leave
+// it as it is
+func tokenColor(tok token.Token) string {
+	switch tok.Type {
+	case token.IDENT, token.COMMA, token.SEMICOLON, token.COLON,
+		token.LPAREN, token.RPAREN, token.LBRACE, token.RBRACE, token.LBRACKET, token.RBRACKET:
+		return ""
+	case token.STRING, token.TEMPLATE_HEAD, token.TEMPLATE_MIDDLE, token.TEMPLATE_TAIL:
+		return colorGreen
+	case token.INT, token.FLOAT, token.TRUE, token.FALSE:
+		return colorCyan
+	case token.COMMENT:
+		return colorGray
+	case token.ERROR, token.ILLEGAL:
+		return colorRed
+	}
+	if token.LookupIdent(tok.Literal) != token.IDENT {
+		return colorMagenta
+	}
+	return colorYellow
+}
+
+// snippet returns the line of source at pos with a caret under its column,
+// or "" if pos is not in source
+func snippet(source string, pos token.Position) string {
+	lines := strings.Split(source, "\n")
+	if !pos.IsValid() || pos.Line > len(lines) {
+		return ""
+	}
+	line := lines[pos.Line-1]
+
+	// tabs are kept so that the caret lines up with the line
+	var caret strings.Builder
+	for i, r := range []rune(line) {
+		if i >= pos.Column-1 {
+			break
+		}
+		if r == '\t' {
+			caret.WriteRune('\t')
+		} else {
+			caret.WriteRune(' ')
+		}
+	}
+
+	return line + "\n" + caret.String() + "^\n"
+}
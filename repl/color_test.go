@@ -0,0 +1,86 @@
+package repl
+
+import (
+	"bytes"
+	"interpreters/engine"
+	"interpreters/token"
+	"strings"
+	"testing"
+)
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x", "x"},
+		{
+			`let s = "a ${x} b"; // c`,
+			"\x1b[35mlet\x1b[0m s \x1b[33m=\x1b[0m \x1b[32m\"a ${\x1b[0mx\x1b[32m} b\"\x1b[0m; \x1b[90m// c\x1b[0m",
+		},
+		{
+			"if (n >= 1.5) { true } else { f(1) }",
+			"\x1b[35mif\x1b[0m (n \x1b[33m>=\x1b[0m \x1b[36m1.5\x1b[0m) { \x1b[36mtrue\x1b[0m } \x1b[35melse\x1b[0m { f(\x1b[36m1\x1b[0m) }",
+		},
+		{`puts("open`, "puts(\x1b[31m\"open\x1b[0m"},
+		{"  a # b  ", "  a \x1b[31m#\x1b[0m b  "},
+	}
+
+	for _, tt := range tests {
+		if actual := highlight(tt.input); actual != tt.expected {
+			t.Errorf("highlight(%q) wrong.\nwant: %q\ngot:  %q", tt.input, tt.expected, actual)
+		}
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	tests := []struct {
+		source   string
+		pos      token.Position
+		expected string
+	}{
+		{"let x = 1 +;", token.Position{Line: 1, Column: 12}, "let x = 1 +;\n           ^\n"},
+		{"a\n\tb(é, c)\n", token.Position{Line: 2, Column: 6}, "\tb(é, c)\n\t    ^\n"},
+		{"a\n", token.Position{Line: 2, Column: 1}, "\n^\n"},
+		{"a", token.Position{Line: 3, Column: 1}, ""},
+		{"a", token.Position{}, ""},
+	}
+
+	for _, tt := range tests {
+		if actual := snippet(tt.source, tt.pos); actual != tt.expected {
+			t.Errorf("snippet(%q, %s) wrong. want: %q, got: %q", tt.source, tt.pos, tt.expected, actual)
+		}
+	}
+}
+
+func TestColoredErrors(t *testing.T) {
+	backend, err := engine.New("vm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	r := &repl{out: &out, backend: backend, color: true}
+
+	r.eval("", "let f = fn(x) {\n  x * 2\n};\n")
+	r.eval("", "let y = 1;\nf(true)\n")
+
+	expected := "f(true)\n^\n" + colorRed + "Traceback (most recent call last):\n" +
+		"  at 2:1, in <main>\n  at 2:3, in f\nerror: type mismatch: BOOLEAN * INTEGER" + colorReset + "\n"
+	if out.String() != expected {
+		t.Errorf("wrong runtime error.\nwant: %q\ngot:  %q", expected, out.String())
+	}
+
+	out.Reset()
+	r.eval("", "let = 1;")
+	expected = "let = 1;\n    ^\n" + colorRed + "\t1:5: expected next token to be IDENT, got: =" + colorReset + "\n"
+	if out.String() != expected {
+		t.Errorf("wrong parse error.\nwant: %q\ngot:  %q", expected, out.String())
+	}
+
+	out.Reset()
+	r.color = false
+	r.eval("", "1 + true")
+	if strings.Contains(out.String(), "\x1b[") {
+		t.Errorf("expected no colors, got: %q", out.String())
+	}
+}
@@ -0,0 +1,190 @@
+package repl
+
+import (
+	"context"
+	"fmt"
+	"interpreters/ast"
+	"interpreters/engine"
+	"interpreters/lexer"
+	"interpreters/object"
+	"interpreters/token"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// command is a REPL command such as :type, run with the rest of its line
+type command struct {
+	name string
+	// arg names the argument of the command, if it takes one
+	arg  string
+	help string
+	run  func(r *repl, arg string)
+}
+
+// commands is set in init, as :help lists it
+var commands []command
+
+func init() {
+	commands = []command{
+		{"env", "", "list the global bindings and their values", (*repl).env},
+		{"type", "expr", "evaluate expr and print the type of its value", (*repl).typeOf},
+		{"ast", "expr", "print the syntax tree of expr", (*repl).ast},
+		{"tokens", "expr", "print the tokens of expr", (*repl).tokens},
+		{"load", "file.mk", "run a script in the current environment", (*repl).load},
+		{"reset", "", "clear the environment", (*repl).reset},
+		{"save", "file.mk", "save the inputs that ran without errors to a script", (*repl).save},
+		{"restore", "file.mk", "clear the environment and run a saved script", (*repl).restore},
+		{"time", "expr", "evaluate expr and print how long it took", (*repl).time},
+		{"help", "", "list the commands", (*repl).help},
+	}
+}
+
+// command runs line, a colon followed by a command name and its argument
+func (r *repl) command(line string) {
+	name, arg := line[1:], ""
+	if i := strings.IndexAny(name, " \t"); i >= 0 {
+		name, arg = name[:i], strings.TrimSpace(name[i+1:])
+	}
+
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		if cmd.arg != "" && arg == "" {
+			fmt.Fprintf(r.out, "usage: :%s %s\n", cmd.name, cmd.arg)
+			return
+		}
+		cmd.run(r, arg)
+		return
+	}
+	fmt.Fprintf(r.out, "unknown command :%s, see :help\n", name)
+}
+
+func (r *repl) env(string) {
+	for _, name := range r.backend.Globals() {
+		value, _ := r.backend.Get(name)
+		fmt.Fprintf(r.out, "%s = %s\n", name, value.Inspect())
+	}
+}
+
+func (r *repl) typeOf(arg string) {
+	program, ok := r.parse("", arg)
+	if !ok {
+		return
+	}
+
+	evaluated := r.backend.Eval(context.Background(), program)
+	switch evaluated := evaluated.(type) {
+	case *object.Error:
+		r.printError(evaluated, "", arg)
+		return
+	case nil:
+		fmt.Fprintln(r.out, object.NULL_OBJ)
+	default:
+		fmt.Fprintln(r.out, evaluated.Type())
+	}
+	r.remember(arg)
+}
+
+func (r *repl) ast(arg string) {
+	if program, ok := r.parse("", arg); ok {
+		ast.Fprint(r.out, program)
+	}
+}
+
+func (r *repl) tokens(arg string) {
+	l := lexer.New(arg)
+	l.KeepComments()
+
+	for {
+		tok := l.NextToken()
+		fmt.Fprintf(r.out, "%s\t%s\t%q\n", tok.Pos, tok.Type, tok.Literal)
+		if tok.Type == token.EOF {
+			return
+		}
+	}
+}
+
+func (r *repl) load(path string) {
+	source, err := ioutil.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(r.out, err)
+		return
+	}
+	r.eval(path, string(source))
+}
+
+func (r *repl) reset(string) {
+	backend, err := engine.New(r.engineName)
+	if err != nil {
+		fmt.Fprintln(r.out, err)
+		return
+	}
+	r.backend = backend
+	r.inputs = nil
+}
+
+// save writes the inputs as a script, which :restore, :load or `monkey
+// run` can run again. Inputs that failed are left out along with the
+// bindings they made before failing, such as x in `let x = 1; x + "a"`.
+func (r *repl) save(path string) {
+	if err := ioutil.WriteFile(path, []byte(strings.Join(r.inputs, "")), 0644); err != nil {
+		fmt.Fprintln(r.out, err)
+		return
+	}
+	fmt.Fprintf(r.out, "saved %d inputs to %s\n", len(r.inputs), path)
+}
+
+// restore replaces the session with the one saved in path. The current
+// session is kept if the script cannot be read, parsed or run.
+func (r *repl) restore(path string) {
+	source, err := ioutil.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(r.out, err)
+		return
+	}
+	program, ok := r.parse(path, string(source))
+	if !ok {
+		return
+	}
+
+	backend, inputs := r.backend, r.inputs
+	r.reset("")
+	if _, ok := r.evalProgram(path, string(source), program); !ok {
+		r.backend, r.inputs = backend, inputs
+		fmt.Fprintf(r.out, "%s failed, the session was kept\n", path)
+		return
+	}
+	r.remember(string(source))
+	fmt.Fprintf(r.out, "restored %s\n", path)
+}
+
+func (r *repl) time(arg string) {
+	program, ok := r.parse("", arg)
+	if !ok {
+		return
+	}
+
+	start := time.Now()
+	evaluated := r.backend.Eval(context.Background(), program)
+	elapsed := time.Since(start)
+
+	if err, ok := evaluated.(*object.Error); ok {
+		r.printError(err, "", arg)
+	} else {
+		r.print(evaluated)
+		r.remember(arg)
+	}
+	fmt.Fprintf(r.out, "took %s\n", elapsed)
+}
+
+func (r *repl) help(string) {
+	for _, cmd := range commands {
+		usage := ":" + cmd.name
+		if cmd.arg != "" {
+			usage += " " + cmd.arg
+		}
+		fmt.Fprintf(r.out, "  %-16s %s\n", usage, cmd.help)
+	}
+}
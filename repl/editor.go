@@ -0,0 +1,504 @@
+package repl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// maxHistory is the number of lines the editor remembers
+const maxHistory = 1000
+
+// errInterrupted is returned by readLine when Ctrl-C is pressed
+var errInterrupted = errors.New("interrupted")
+
+const (
+	keyCtrlA     = 1
+	keyCtrlB     = 2
+	keyCtrlC     = 3
+	keyCtrlD     = 4
+	keyCtrlE     = 5
+	keyCtrlF     = 6
+	keyCtrlG     = 7
+	keyCtrlH     = 8
+	keyTab       = 9
+	keyCtrlK     = 11
+	keyEnter     = 13
+	keyCtrlN     = 14
+	keyCtrlP     = 16
+	keyCtrlR     = 18
+	keyCtrlU     = 21
+	keyCtrlW     = 23
+	keyEscape    = 27
+	keyBackspace = 127
+)
+
+// lineReader reads the lines of a REPL session, returning io.EOF at the
+// end of the input and errInterrupted when the current input is abandoned
+type lineReader interface {
+	readLine(prompt string) (string, error)
+}
+
+// completer returns the words that complete prefix, given the text of the
+// line before it
+type completer func(before string, prefix string) []string
+
+// newLineReader returns a line editor when in and out are a terminal, and
+// a plain line scanner otherwise. The editor colors the line being typed
+// with highlight, if it is not nil.
+func newLineReader(in io.Reader, out io.Writer, complete completer, highlight func(string) string) lineReader {
+	inFile, ok := in.(*os.File)
+	if !ok || !isTerminal(inFile.Fd()) {
+		return &scannerReader{scanner: bufio.NewScanner(in), out: out}
+	}
+	if outFile, ok := out.(*os.File); !ok || !isTerminal(outFile.Fd()) {
+		return &scannerReader{scanner: bufio.NewScanner(in), out: out}
+	}
+
+	e := newEditor(in, out, historyPath())
+	e.complete = complete
+	e.highlight = highlight
+	e.raw = func() (func() error, error) { return makeRaw(inFile.Fd()) }
+	return e
+}
+
+// historyPath returns the file the history is kept in: $MONKEY_HISTORY if
+// it is set, ~/.monkey_history otherwise. Setting MONKEY_HISTORY to an
+// empty string turns the file off.
+func historyPath() string {
+	if path, ok := os.LookupEnv("MONKEY_HISTORY"); ok {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return home + string(os.PathSeparator) + ".monkey_history"
+}
+
+// scannerReader reads plain lines, for input that is not a terminal
+type scannerReader struct {
+	scanner *bufio.Scanner
+	out     io.Writer
+}
+
+func (s *scannerReader) readLine(prompt string) (string, error) {
+	io.WriteString(s.out, prompt)
+	if !s.scanner.Scan() {
+		if err := s.scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return s.scanner.Text(), nil
+}
+
+// editor reads lines from a terminal in raw mode. Besides the usual
+// editing keys it has the arrow keys, Ctrl-A and Ctrl-E to move the
+// cursor, Up and Down to go through the history, Ctrl-R to search it and
+// Tab to complete the word before the cursor.
+type editor struct {
+	in  *bufio.Reader
+	out io.Writer
+	// history holds the lines entered, oldest first, and historyFile the
+	// file that they are appended to, if any
+	history     []string
+	historyFile string
+	complete    completer
+	// highlight returns the line with color escapes added, if set
+	highlight func(string) string
+	// raw puts the terminal in raw mode and returns a function restoring
+	// it; it is nil in tests
+	raw func() (func() error, error)
+}
+
+// newEditor returns an editor reading keys from in, with the history
+// loaded from historyFile and the file cut down to its last maxHistory
+// lines
+func newEditor(in io.Reader, out io.Writer, historyFile string) *editor {
+	e := &editor{in: bufio.NewReader(in), out: out, historyFile: historyFile}
+	if historyFile == "" {
+		return e
+	}
+
+	// a missing history file is created by the first line entered
+	data, err := ioutil.ReadFile(historyFile)
+	if err != nil {
+		return e
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			e.history = append(e.history, line)
+		}
+	}
+	// the file is trimmed here as lines are only ever appended to it
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+		ioutil.WriteFile(historyFile, []byte(strings.Join(e.history, "\n")+"\n"), 0600)
+	}
+	return e
+}
+
+// lineState is the line being edited
+type lineState struct {
+	prompt string
+	buf    []rune
+	// pos is the index of the rune under the cursor
+	pos int
+	// historyIndex is the history line shown, len(history) for the line
+	// being typed, and saved holds the latter while browsing the history
+	historyIndex int
+	saved        []rune
+}
+
+func (st *lineState) insert(text []rune) {
+	buf := make([]rune, 0, len(st.buf)+len(text))
+	buf = append(buf, st.buf[:st.pos]...)
+	buf = append(buf, text...)
+	st.buf = append(buf, st.buf[st.pos:]...)
+	st.pos += len(text)
+}
+
+// delete removes the runes between from and to and leaves the cursor at
+// from
+func (st *lineState) delete(from int, to int) {
+	st.buf = append(st.buf[:from:from], st.buf[to:]...)
+	st.pos = from
+}
+
+func (st *lineState) set(line []rune) {
+	st.buf = append([]rune{}, line...)
+	st.pos = len(st.buf)
+}
+
+// wordStart returns the index of the start of the word before the cursor
+func (st *lineState) wordStart() int {
+	start := st.pos
+	for start > 0 && isWordRune(st.buf[start-1]) {
+		start--
+	}
+	return start
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+func (e *editor) readLine(prompt string) (string, error) {
+	if e.raw != nil {
+		restore, err := e.raw()
+		if err != nil {
+			return "", err
+		}
+		defer restore()
+	}
+
+	st := &lineState{prompt: prompt, historyIndex: len(e.history)}
+	e.refresh(st)
+
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		switch r {
+		case keyEnter, '\n':
+			return e.submit(st), nil
+		case keyCtrlC:
+			io.WriteString(e.out, "^C\r\n")
+			return "", errInterrupted
+		case keyCtrlD:
+			if len(st.buf) == 0 {
+				io.WriteString(e.out, "\r\n")
+				return "", io.EOF
+			}
+			if st.pos < len(st.buf) {
+				st.delete(st.pos, st.pos+1)
+			}
+		case keyBackspace, keyCtrlH:
+			if st.pos > 0 {
+				st.delete(st.pos-1, st.pos)
+			}
+		case keyCtrlA:
+			st.pos = 0
+		case keyCtrlE:
+			st.pos = len(st.buf)
+		case keyCtrlB:
+			e.moveLeft(st)
+		case keyCtrlF:
+			e.moveRight(st)
+		case keyCtrlK:
+			st.delete(st.pos, len(st.buf))
+		case keyCtrlU:
+			st.delete(0, st.pos)
+		case keyCtrlW:
+			end := st.pos
+			for st.pos > 0 && st.buf[st.pos-1] == ' ' {
+				st.pos--
+			}
+			st.pos = st.wordStart()
+			st.delete(st.pos, end)
+		case keyCtrlP:
+			e.historyPrev(st)
+		case keyCtrlN:
+			e.historyNext(st)
+		case keyTab:
+			e.completeWord(st)
+		case keyCtrlR:
+			submit, err := e.search(st)
+			if err != nil {
+				return "", err
+			}
+			if submit {
+				return e.submit(st), nil
+			}
+		case keyEscape:
+			if err := e.readEscape(st); err != nil {
+				return "", err
+			}
+		default:
+			if unicode.IsPrint(r) {
+				st.insert([]rune{r})
+			}
+		}
+		e.refresh(st)
+	}
+}
+
+// submit ends the line being edited and adds it to the history
+func (e *editor) submit(st *lineState) string {
+	st.pos = len(st.buf)
+	e.refresh(st)
+	io.WriteString(e.out, "\r\n")
+
+	line := string(st.buf)
+	e.addHistory(line)
+	return line
+}
+
+// readEscape handles the escape sequence sent by an arrow, Home, End or
+// Delete key, whose escape character has been read
+func (e *editor) readEscape(st *lineState) error {
+	r, _, err := e.in.ReadRune()
+	if err != nil || r != '[' && r != 'O' {
+		return err
+	}
+	r, _, err = e.in.ReadRune()
+	if err != nil {
+		return err
+	}
+
+	switch r {
+	case 'A':
+		e.historyPrev(st)
+	case 'B':
+		e.historyNext(st)
+	case 'C':
+		e.moveRight(st)
+	case 'D':
+		e.moveLeft(st)
+	case 'H':
+		st.pos = 0
+	case 'F':
+		st.pos = len(st.buf)
+	default:
+		// Home, End and Delete may also be sent as ESC [ n ~
+		if r < '0' || r > '9' {
+			return nil
+		}
+		code := string(r)
+		for {
+			r, _, err = e.in.ReadRune()
+			if err != nil {
+				return err
+			}
+			if r == '~' {
+				break
+			}
+			code += string(r)
+		}
+		switch code {
+		case "1", "7":
+			st.pos = 0
+		case "4", "8":
+			st.pos = len(st.buf)
+		case "3":
+			if st.pos < len(st.buf) {
+				st.delete(st.pos, st.pos+1)
+			}
+		}
+	}
+	return nil
+}
+
+func (e *editor) moveLeft(st *lineState) {
+	if st.pos > 0 {
+		st.pos--
+	}
+}
+
+func (e *editor) moveRight(st *lineState) {
+	if st.pos < len(st.buf) {
+		st.pos++
+	}
+}
+
+func (e *editor) historyPrev(st *lineState) {
+	if st.historyIndex == 0 {
+		return
+	}
+	if st.historyIndex == len(e.history) {
+		st.saved = st.buf
+	}
+	st.historyIndex--
+	st.set([]rune(e.history[st.historyIndex]))
+}
+
+func (e *editor) historyNext(st *lineState) {
+	if st.historyIndex == len(e.history) {
+		return
+	}
+	st.historyIndex++
+	if st.historyIndex == len(e.history) {
+		st.set(st.saved)
+		return
+	}
+	st.set([]rune(e.history[st.historyIndex]))
+}
+
+// addHistory remembers line, unless it is empty or repeats the previous
+// line, and appends it to the history file
+func (e *editor) addHistory(line string) {
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	if n := len(e.history); n > 0 && e.history[n-1] == line {
+		return
+	}
+
+	e.history = append(e.history, line)
+	if len(e.history) > maxHistory {
+		e.history = e.history[1:]
+	}
+
+	if e.historyFile == "" {
+		return
+	}
+	// the history is a convenience, so failing to save it is not reported
+	f, err := os.OpenFile(e.historyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	io.WriteString(f, line+"\n")
+}
+
+// search runs a reverse incremental search through the history, started
+// by Ctrl-R. Typing extends the query, Ctrl-R finds the next older match
+// and Ctrl-G cancels. Enter submits the match, reported by returning true,
+// while any other key leaves it in the line to be edited. An arrow key is
+// applied to it as well.
+func (e *editor) search(st *lineState) (bool, error) {
+	original := st.buf
+	var query []rune
+	match := len(e.history)
+	failing := false
+
+	// find looks for the query from the history line at index from back
+	find := func(from int) {
+		for i := from; i >= 0; i-- {
+			if i < len(e.history) && strings.Contains(e.history[i], string(query)) {
+				match = i
+				failing = false
+				st.set([]rune(e.history[i]))
+				return
+			}
+		}
+		failing = true
+	}
+
+	for {
+		label := "reverse-i-search"
+		if failing {
+			label = "failing " + label
+		}
+		fmt.Fprintf(e.out, "\r(%s)`%s': %s\x1b[K", label, string(query), string(st.buf))
+
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return false, err
+		}
+
+		switch {
+		case r == keyCtrlR:
+			find(match - 1)
+		case r == keyBackspace || r == keyCtrlH:
+			if len(query) > 0 {
+				query = query[:len(query)-1]
+				find(len(e.history) - 1)
+			}
+		case r == keyCtrlG || r == keyCtrlC:
+			st.set(original)
+			return false, nil
+		case r == keyEnter || r == '\n':
+			return true, nil
+		case r == keyEscape:
+			return false, e.readEscape(st)
+		case unicode.IsPrint(r):
+			query = append(query, r)
+			find(match)
+		default:
+			return false, nil
+		}
+	}
+}
+
+// completeWord completes the word before the cursor as far as all the
+// candidates agree, and lists them when it cannot go further
+func (e *editor) completeWord(st *lineState) {
+	if e.complete == nil {
+		return
+	}
+
+	start := st.wordStart()
+	prefix := string(st.buf[start:st.pos])
+	candidates := e.complete(string(st.buf[:start]), prefix)
+	if len(candidates) == 0 {
+		io.WriteString(e.out, "\a")
+		return
+	}
+
+	common := []rune(candidates[0])
+	for _, candidate := range candidates[1:] {
+		c := []rune(candidate)
+		n := 0
+		for n < len(common) && n < len(c) && common[n] == c[n] {
+			n++
+		}
+		common = common[:n]
+	}
+
+	if len(common) > st.pos-start {
+		st.insert(common[st.pos-start:])
+		return
+	}
+	io.WriteString(e.out, "\r\n"+strings.Join(candidates, "  ")+"\r\n")
+}
+
+// refresh redraws the line and puts the cursor back in its place
+func (e *editor) refresh(st *lineState) {
+	line := string(st.buf)
+	if e.highlight != nil {
+		line = e.highlight(line)
+	}
+	fmt.Fprintf(e.out, "\r%s%s\x1b[K", st.prompt, line)
+	if back := len(st.buf) - st.pos; back > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dD", back)
+	}
+}
@@ -0,0 +1,183 @@
+package repl
+
+import (
+	"fmt"
+	"interpreters/engine"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	up    = "\x1b[A"
+	down  = "\x1b[B"
+	right = "\x1b[C"
+	left  = "\x1b[D"
+	home  = "\x1b[H"
+	del   = "\x1b[3~"
+)
+
+func TestEditor(t *testing.T) {
+	tests := []struct {
+		name     string
+		history  []string
+		keys     string
+		expected string
+	}{
+		{"typing", nil, "let x = 1;\r", "let x = 1;"},
+		{"backspace", nil, "abd\x7fc\r", "abc"},
+		{"arrows", nil, "ac" + left + "b" + right + "d\r", "abcd"},
+		{"home and end", nil, "bc" + home + "a\x05d\r", "abcd"},
+		{"delete", nil, "abxc" + left + left + del + "\x04\r", "ab"},
+		{"kill", nil, "abc def" + left + left + "\x0b\x01\x0bxy\r", "xy"},
+		{"kill line start", nil, "abc def" + left + left + left + "\x15\r", "def"},
+		{"delete word", nil, "let foo bar  \x17\r", "let foo "},
+		{"history", []string{"one", "two"}, up + up + "!\r", "one!"},
+		{"history back down", []string{"one", "two"}, "new" + up + up + down + down + "\r", "new"},
+		{"history past the ends", []string{"one"}, up + up + down + down + up + "\r", "one"},
+		{"search", []string{"let a = 1", "puts(a)", "let b = 2"}, "\x12let\r", "let b = 2"},
+		{"search older", []string{"let a = 1", "puts(a)", "let b = 2"}, "\x12let\x12\r", "let a = 1"},
+		{"search then edit", []string{"let a = 1", "puts(a)"}, "\x12put" + right + "\x05;\r", "puts(a);"},
+		{"search backspace", []string{"let a = 1", "puts(a)"}, "\x12letx\x7f\r", "let a = 1"},
+		{"search cancel", []string{"let a = 1"}, "x\x12let\x07y\r", "xy"},
+		{"complete", nil, "pu\t(1)\r", "puts(1)"},
+		{"complete common prefix", nil, "le\t\r", "le"},
+		{"complete after a word", nil, "[1].pu\t\r", "[1].puts"},
+		{"complete nothing", nil, "zz\t\r", "zz"},
+	}
+
+	for _, tt := range tests {
+		var out strings.Builder
+		e := newEditor(strings.NewReader(tt.keys), &out, "")
+		e.history = tt.history
+		e.complete = func(before string, prefix string) []string {
+			var candidates []string
+			for _, word := range []string{"len", "let", "puts"} {
+				if strings.HasPrefix(word, prefix) {
+					candidates = append(candidates, word)
+				}
+			}
+			return candidates
+		}
+
+		line, err := e.readLine(">> ")
+		if err != nil {
+			t.Errorf("%s: readLine returned error: %s", tt.name, err)
+			continue
+		}
+		if line != tt.expected {
+			t.Errorf("%s: wrong line. want: %q, got: %q", tt.name, tt.expected, line)
+		}
+	}
+}
+
+func TestEditorEndOfInput(t *testing.T) {
+	var out strings.Builder
+	e := newEditor(strings.NewReader("a\x03\x04"), &out, "")
+
+	if _, err := e.readLine(">> "); err != errInterrupted {
+		t.Errorf("Ctrl-C: want errInterrupted, got: %v", err)
+	}
+	if _, err := e.readLine(">> "); err != io.EOF {
+		t.Errorf("Ctrl-D: want io.EOF, got: %v", err)
+	}
+	if len(e.history) != 0 {
+		t.Errorf("interrupted lines should not be in the history, got: %q", e.history)
+	}
+}
+
+func TestEditorHistoryFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "monkey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "history")
+
+	e := newEditor(strings.NewReader("one\r\rone\rtwo\r"), ioutil.Discard, path)
+	for i := 0; i < 4; i++ {
+		if _, err := e.readLine(">> "); err != nil {
+			t.Fatalf("readLine returned error: %s", err)
+		}
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("history file not written: %s", err)
+	}
+	if string(data) != "one\ntwo\n" {
+		t.Errorf("wrong history file. want: %q, got: %q", "one\ntwo\n", string(data))
+	}
+
+	// a new session starts with the saved history
+	e = newEditor(strings.NewReader(up+up+"\r"), ioutil.Discard, path)
+	line, err := e.readLine(">> ")
+	if err != nil {
+		t.Fatalf("readLine returned error: %s", err)
+	}
+	if line != "one" {
+		t.Errorf("wrong line. want: %q, got: %q", "one", line)
+	}
+}
+
+func TestEditorHistoryFileTrimmed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "monkey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "history")
+
+	var lines []string
+	for i := 0; i < maxHistory+5; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+	if err := ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	e := newEditor(strings.NewReader(""), ioutil.Discard, path)
+	if len(e.history) != maxHistory || e.history[0] != "line 5" {
+		t.Errorf("wrong history. want %d lines from %q, got %d from %q", maxHistory, "line 5", len(e.history), e.history[0])
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Join(lines[5:], "\n") + "\n"
+	if string(data) != expected {
+		t.Errorf("history file not trimmed, it has %d lines", strings.Count(string(data), "\n"))
+	}
+}
+
+func TestCompletions(t *testing.T) {
+	backend, err := engine.New("eval")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &repl{out: ioutil.Discard, backend: backend}
+	r.eval("", "let length = 1; let reverse_all = 2;")
+
+	tests := []struct {
+		before   string
+		prefix   string
+		expected string
+	}{
+		{"", "le", "len,length,let"},
+		{"", "rev", "reverse,reverse_all"},
+		{"x + ", "whi", "while"},
+		{":", "t", "time,tokens,type"},
+		{"", "zz", ""},
+	}
+
+	for _, tt := range tests {
+		actual := strings.Join(r.completions(tt.before, tt.prefix), ",")
+		if actual != tt.expected {
+			t.Errorf("completions(%q, %q) wrong. want: %q, got: %q", tt.before, tt.prefix, tt.expected, actual)
+		}
+	}
+}
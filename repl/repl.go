@@ -1,46 +1,226 @@
 package repl
 
 import (
-	"bufio"
-	"fmt"
-	"interpreters/evaluator"
+	"context"
+	"interpreters/ast"
+	"interpreters/engine"
 	"interpreters/lexer"
 	"interpreters/object"
 	"interpreters/parser"
+	"interpreters/token"
 	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
 )
 
-const PROMPT = ">> "
+const (
+	PROMPT = ">> "
+	// CONTINUATION_PROMPT asks for the next line of an incomplete input
+	CONTINUATION_PROMPT = ".. "
+)
+
+// repl holds the engine that evaluates the input of a session, so that
+// commands such as :reset can replace it
+type repl struct {
+	out        io.Writer
+	engineName string
+	backend    engine.Engine
+	// inputs holds the source of the inputs and files that ran without
+	// errors since the session started or was reset, for :save. The
+	// bindings an input made before failing are kept in the session but
+	// not in inputs.
+	inputs []string
+	// color turns on the colors of errors and of the input being typed
+	color bool
+}
 
-func Start(in io.Reader, out io.Writer) {
-	scanner := bufio.NewScanner(in)
-	env := object.NewEnvironment()
+// Start reads lines from in and evaluates them with the named engine:
+// "eval" for the tree-walking evaluator, "vm" for the bytecode vm. Lines
+// are read until the input is complete, so that a function or a raw
+// string can span several of them. Lines starting with a colon are
+// commands, see :help. The script initFile, if not empty, is run first
+// and is part of the session :save writes.
+func Start(in io.Reader, out io.Writer, engineName string, initFile string) error {
+	backend, err := engine.New(engineName)
+	if err != nil {
+		return err
+	}
+	r := &repl{out: out, engineName: engineName, backend: backend, color: useColor(out)}
 
+	if initFile != "" {
+		source, err := ioutil.ReadFile(initFile)
+		if err != nil {
+			return err
+		}
+		if _, ok := r.run(initFile, string(source)); ok {
+			r.remember(string(source))
+		}
+	}
+
+	var highlighter func(string) string
+	if r.color {
+		highlighter = highlight
+	}
+	lines := newLineReader(in, out, r.completions, highlighter)
+
+	var input string
 	for {
-		fmt.Fprintf(out, PROMPT)
-		scanned := scanner.Scan()
-		if !scanned {
-			return
+		prompt := PROMPT
+		if input != "" {
+			prompt = CONTINUATION_PROMPT
+		}
+		line, err := lines.readLine(prompt)
+		if err == errInterrupted {
+			input = ""
+			continue
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
 		}
-		line := scanner.Text()
-		l := lexer.New(line)
-		p := parser.New(l)
 
-		program := p.ParseProgram()
+		if input == "" && strings.HasPrefix(line, ":") {
+			r.command(line)
+			continue
+		}
 
-		if len(p.Errors()) != 0 {
-			printParseErrors(out, p.Errors())
+		input += line + "\n"
+		if incomplete(input) {
 			continue
 		}
+		r.eval("", input)
+		input = ""
+	}
+}
+
+// completions returns the commands, after a colon at the start of the
+// line, or else the keywords, builtins and globals that start with prefix
+func (r *repl) completions(before string, prefix string) []string {
+	var names []string
+	if before == ":" {
+		for _, cmd := range commands {
+			names = append(names, cmd.name)
+		}
+	} else {
+		names = append(names, token.Keywords()...)
+		for _, def := range object.Builtins {
+			names = append(names, def.Name)
+		}
+		names = append(names, r.backend.Globals()...)
+	}
+	sort.Strings(names)
+
+	var candidates []string
+	for i, name := range names {
+		if strings.HasPrefix(name, prefix) && (i == 0 || name != names[i-1]) {
+			candidates = append(candidates, name)
+		}
+	}
+	return candidates
+}
+
+// eval runs source, read from filename if it is not empty, prints its
+// value and remembers it for :save if it ran without errors
+func (r *repl) eval(filename string, source string) {
+	if value, ok := r.run(filename, source); ok {
+		r.remember(source)
+		r.print(value)
+	}
+}
+
+// remember adds source, which ran without errors, to the inputs for :save
+func (r *repl) remember(source string) {
+	if !strings.HasSuffix(source, "\n") {
+		source += "\n"
+	}
+	r.inputs = append(r.inputs, source)
+}
 
-		evaluated := evaluator.Eval(program, env)
-		io.WriteString(out, evaluated.Inspect())
-		io.WriteString(out, "\n")
+// run parses and evaluates source, read from filename if it is not empty,
+// and returns its value. Syntax and runtime errors are printed and
+// reported by returning false.
+func (r *repl) run(filename string, source string) (object.Object, bool) {
+	program, ok := r.parse(filename, source)
+	if !ok {
+		return nil, false
 	}
+	return r.evalProgram(filename, source, program)
 }
 
-func printParseErrors(out io.Writer, errors []string) {
-	for _, msg := range errors {
-		io.WriteString(out, "\t"+msg+"\n")
+// evalProgram evaluates program, parsed from source, and returns its value.
+// A runtime error is printed and reported by returning false.
+func (r *repl) evalProgram(filename string, source string, program *ast.Program) (object.Object, bool) {
+	evaluated := r.backend.Eval(context.Background(), program)
+	if err, ok := evaluated.(*object.Error); ok {
+		r.printError(err, filename, source)
+		return nil, false
+	}
+	return evaluated, true
+}
+
+// parse parses source, read from filename if it is not empty, printing
+// its syntax errors if it has any
+func (r *repl) parse(filename string, source string) (*ast.Program, bool) {
+	p := parser.New(lexer.NewFile(filename, source))
+	program := p.ParseProgram()
+	if len(p.Errors()) == 0 {
+		return program, true
+	}
+
+	for _, err := range p.ParseErrors() {
+		io.WriteString(r.out, snippet(source, err.Pos))
+		io.WriteString(r.out, r.paint(colorRed, "\t"+err.Error())+"\n")
+	}
+	return nil, false
+}
+
+// print prints an evaluated value
+func (r *repl) print(evaluated object.Object) {
+	if evaluated == nil {
+		return
+	}
+	io.WriteString(r.out, evaluated.Inspect())
+	io.WriteString(r.out, "\n")
+}
+
+// printError prints the traceback of err, raised by source, under the
+// line of source where it was raised. When err was raised in a function
+// the line shown is that of the outermost call, as the function may have
+// come from an earlier input.
+func (r *repl) printError(err *object.Error, filename string, source string) {
+	pos := err.Pos
+	if n := len(err.Stack); n > 0 {
+		pos = err.Stack[n-1].Pos
+	}
+	if pos.Filename == filename {
+		io.WriteString(r.out, snippet(source, pos))
+	}
+	io.WriteString(r.out, r.paint(colorRed, err.Traceback())+"\n")
+}
+
+// incomplete reports whether input ends inside brackets, braces,
+// parentheses, an embedded expression, a raw string or a block comment,
+// in which case more lines are needed to complete it
+func incomplete(input string) bool {
+	l := lexer.New(input)
+	depth := 0
+
+	for {
+		tok := l.NextToken()
+		switch tok.Type {
+		case token.LPAREN, token.LBRACKET, token.LBRACE, token.TEMPLATE_HEAD:
+			depth++
+		case token.RPAREN, token.RBRACKET, token.RBRACE, token.TEMPLATE_TAIL:
+			depth--
+		case token.ERROR:
+			// input ends with a newline, so only raw strings and block
+			// comments can run to the end of it
+			return tok.End.Offset == len(input)
+		case token.EOF:
+			return depth > 0
+		}
 	}
 }
@@ -0,0 +1,193 @@
+package repl
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIncomplete(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"let x = 1;\n", false},
+		{"let add = fn(a, b) {\n", true},
+		{"let add = fn(a, b) {\n  a + b\n}\n", false},
+		{"add(1,\n", true},
+		{"[1, [2,\n3]\n", true},
+		{"{\"a\": 1\n", true},
+		{"\"a ${ 1 +\n", true},
+		{"\"a ${ 1 +\n 2 } b\"\n", false},
+		{"`raw\n", true},
+		{"`raw\nstring`\n", false},
+		{"/* comment\n", true},
+		{"\"open\n", false},
+		{"1 + 2)\n", false},
+	}
+
+	for _, tt := range tests {
+		if actual := incomplete(tt.input); actual != tt.expected {
+			t.Errorf("incomplete(%q) wrong. want: %t, got: %t", tt.input, tt.expected, actual)
+		}
+	}
+}
+
+func TestStart(t *testing.T) {
+	input := "let add = fn(a, b) {\n  a + b\n};\nadd(1,\n2)\n"
+
+	var out bytes.Buffer
+	if err := Start(strings.NewReader(input), &out, "vm", ""); err != nil {
+		t.Fatalf("Start failed: %s", err)
+	}
+
+	expected := ">> .. .. >> .. 3\n>> "
+	if out.String() != expected {
+		t.Errorf("wrong output. want: %q, got: %q", expected, out.String())
+	}
+}
+
+func TestCommands(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{":env", ""},
+		{"let b = 2; let a = [1];\n:env", "a = [1]\nb = 2\n"},
+		{":type 1.5", "FLOAT\n"},
+		{":type if (false) { 1 }", "NULL\n"},
+		{":type 1 + true", "1 + true\n^\nTraceback (most recent call last):\n  at 1:1, in <main>\nerror: type mismatch: INTEGER + BOOLEAN\n"},
+		{":ast -x", "Program 1:1\n  Statements[0]: ExpressionStatement 1:1\n    Expression: PrefixExpression 1:1 Operator=\"-\"\n      Right: Identifier 1:2 Value=\"x\"\n"},
+		{":ast let = 1", "let = 1\n    ^\n\t1:5: expected next token to be IDENT, got: =\n"},
+		{":tokens x /* c */", "1:1\tIDENT\t\"x\"\n1:3\tCOMMENT\t\"/* c */\"\n1:10\tEOF\t\"\"\n"},
+		{"let a = 1;\n:reset\n:env", ""},
+		{":load testdata/missing.mk", "open testdata/missing.mk: no such file or directory\n"},
+		{":type", "usage: :type expr\n"},
+		{":nope", "unknown command :nope, see :help\n"},
+	}
+
+	for _, tt := range tests {
+		for _, engine := range []string{"eval", "vm"} {
+			var out bytes.Buffer
+			if err := Start(strings.NewReader(tt.input+"\n"), &out, engine, ""); err != nil {
+				t.Fatalf("Start failed: %s", err)
+			}
+
+			// only the output of the last line is checked
+			outputs := strings.Split(out.String(), PROMPT)
+			actual := outputs[len(outputs)-2]
+			if actual != tt.expected {
+				t.Errorf("%s: %q: wrong output. want: %q, got: %q", engine, tt.input, tt.expected, actual)
+			}
+		}
+	}
+}
+
+func TestTimeCommand(t *testing.T) {
+	var out bytes.Buffer
+	if err := Start(strings.NewReader(":time 1 + 2\n"), &out, "vm", ""); err != nil {
+		t.Fatalf("Start failed: %s", err)
+	}
+
+	if !strings.HasPrefix(out.String(), PROMPT+"3\ntook ") {
+		t.Errorf("wrong output, got: %q", out.String())
+	}
+}
+
+func TestSaveRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "monkey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "session.mk")
+
+	input := "let x = 2;\nlet f = fn(a) {\n  a * x\n};\nf(true)\nlet = 1\n:time let y = f(3);\n:save " + path + "\n"
+	var out bytes.Buffer
+	if err := Start(strings.NewReader(input), &out, "vm", ""); err != nil {
+		t.Fatalf("Start failed: %s", err)
+	}
+
+	expected := "let x = 2;\nlet f = fn(a) {\n  a * x\n};\nlet y = f(3);\n"
+	saved, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("session not saved: %s", err)
+	}
+	if string(saved) != expected {
+		t.Errorf("wrong session saved. want: %q, got: %q", expected, string(saved))
+	}
+
+	failing := filepath.Join(dir, "failing.mk")
+	if err := ioutil.WriteFile(failing, []byte("let y = 1;\ny + \"a\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	broken := filepath.Join(dir, "broken.mk")
+	if err := ioutil.WriteFile(broken, []byte("let = 1;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, engine := range []string{"eval", "vm"} {
+		input = "let z = 1;\n:restore " + path + "\n:env\n:restore " + filepath.Join(dir, "missing.mk") + "\ny\n" +
+			":restore " + failing + "\ny\n:restore " + broken + "\ny\n"
+		out.Reset()
+		if err := Start(strings.NewReader(input), &out, engine, ""); err != nil {
+			t.Fatalf("Start failed: %s", err)
+		}
+
+		outputs := strings.Split(out.String(), PROMPT)
+		if outputs[2] != "restored "+path+"\n" {
+			t.Errorf("%s: wrong :restore output, got: %q", engine, outputs[2])
+		}
+		if !strings.HasPrefix(outputs[3], "f = ") || !strings.HasSuffix(outputs[3], "\nx = 2\ny = 6\n") {
+			t.Errorf("%s: the session was not restored, got: %q", engine, outputs[3])
+		}
+		for _, i := range []int{5, 7, 9} {
+			if outputs[i] != "6\n" {
+				t.Errorf("%s: a failed :restore should keep the session, got: %q", engine, outputs[i])
+			}
+		}
+		if !strings.HasSuffix(outputs[6], failing+" failed, the session was kept\n") {
+			t.Errorf("%s: wrong output of a failing :restore, got: %q", engine, outputs[6])
+		}
+	}
+}
+
+func TestInitFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "monkey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "init.mk")
+	if err := ioutil.WriteFile(path, []byte("let greet = fn(name) { \"hi ${name}\" };\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := Start(strings.NewReader("greet(\"ann\")\n"), &out, "eval", path); err != nil {
+		t.Fatalf("Start failed: %s", err)
+	}
+	if out.String() != ">> hi ann\n>> " {
+		t.Errorf("wrong output, got: %q", out.String())
+	}
+
+	// the definitions of the init file are saved with the session
+	session := filepath.Join(dir, "session.mk")
+	if err := Start(strings.NewReader(":save "+session+"\n"), &out, "eval", path); err != nil {
+		t.Fatalf("Start failed: %s", err)
+	}
+	out.Reset()
+	if err := Start(strings.NewReader(":restore "+session+"\ngreet(\"bob\")\n"), &out, "vm", ""); err != nil {
+		t.Fatalf("Start failed: %s", err)
+	}
+	if out.String() != ">> restored "+session+"\n>> hi bob\n>> " {
+		t.Errorf("init file not saved, got: %q", out.String())
+	}
+
+	if err := Start(strings.NewReader(""), &out, "eval", filepath.Join(dir, "missing.mk")); err == nil {
+		t.Errorf("expected an error for a missing init file")
+	}
+}
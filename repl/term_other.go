@@ -0,0 +1,16 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package repl
+
+import "errors"
+
+// isTerminal reports false, as raw mode is only supported on Linux and
+// macOS, so that the REPL reads plain lines instead
+func isTerminal(fd uintptr) bool {
+	return false
+}
+
+func makeRaw(fd uintptr) (restore func() error, err error) {
+	return nil, errors.New("raw mode is not supported on this platform")
+}
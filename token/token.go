@@ -1,16 +1,39 @@
 package token
 
+import (
+	"fmt"
+	"sort"
+)
+
 const (
 	ILLEGAL = "ILLEGAL"
 	EOF     = "EOF"
 	STRING  = "STRING"
 
+	// TEMPLATE_HEAD, TEMPLATE_MIDDLE and TEMPLATE_TAIL are the text of a
+	// string with embedded expressions, e.g. "a ${x} b ${y} c" is lexed as
+	// TEMPLATE_HEAD "a ", x, TEMPLATE_MIDDLE " b ", y, TEMPLATE_TAIL " c".
+	// Their Literal is the text with escape sequences decoded.
+	TEMPLATE_HEAD   = "TEMPLATE_HEAD"
+	TEMPLATE_MIDDLE = "TEMPLATE_MIDDLE"
+	TEMPLATE_TAIL   = "TEMPLATE_TAIL"
+
+	// COMMENT is a // or /* */ comment, including the slashes and stars.
+	// The lexer only returns comments when asked to keep them.
+	COMMENT = "COMMENT"
+
+	// ERROR marks input the lexer could not read, e.g. an unterminated
+	// string. Its Literal is the error message.
+	ERROR = "ERROR"
+
 	// IDENT stands for Identifier type
 	// E.g. foobar
 	IDENT = "IDENT"
 
 	// INT stands for Integer type
 	INT = "INT"
+	// FLOAT stands for floating-point numbers, e.g. 3.14
+	FLOAT = "FLOAT"
 
 	// Operators
 	ASSIGN   = "="
@@ -19,9 +42,20 @@ const (
 	BANG     = "!"
 	ASTERISK = "*"
 	SLASH    = "/"
+	PERCENT  = "%"
 
-	LT = "<"
-	GT = ">"
+	PLUS_ASSIGN     = "+="
+	MINUS_ASSIGN    = "-="
+	ASTERISK_ASSIGN = "*="
+	SLASH_ASSIGN    = "/="
+
+	LT    = "<"
+	GT    = ">"
+	LT_EQ = "<="
+	GT_EQ = ">="
+
+	AND = "&&"
+	OR  = "||"
 
 	// Delimiters
 	COMMA     = ","
@@ -43,6 +77,11 @@ const (
 	TRUE     = "TRUE"
 	FALSE    = "FALSE"
 	RETURN   = "RETURN"
+	WHILE    = "WHILE"
+	FOR      = "FOR"
+	IN       = "IN"
+	BREAK    = "BREAK"
+	CONTINUE = "CONTINUE"
 
 	EQ     = "=="
 	NOT_EQ = "!="
@@ -56,16 +95,59 @@ type Type string
 type Token struct {
 	Type    Type
 	Literal string
+	// Pos is the position of the first character of the token and End the
+	// position immediately after its last character
+	Pos Position
+	End Position
+}
+
+// Position describes a location in the source being lexed
+type Position struct {
+	Filename string
+	// Offset is the byte offset, starting at 0
+	Offset int
+	// Line and Column both start at 1
+	Line   int
+	Column int
+}
+
+// IsValid reports whether the position has been set by the lexer
+func (p Position) IsValid() bool { return p.Line > 0 }
+
+// String formats the position as file:line:col, leaving out the file name when there is none
+func (p Position) String() string {
+	if !p.IsValid() {
+		return "-"
+	}
+	if p.Filename == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.Filename, p.Line, p.Column)
 }
 
 var keywords = map[string]Type{
-	"fn":     FUNCTION,
-	"let":    LET,
-	"if":     IF,
-	"else":   ELSE,
-	"true":   TRUE,
-	"false":  FALSE,
-	"return": RETURN,
+	"fn":       FUNCTION,
+	"let":      LET,
+	"if":       IF,
+	"else":     ELSE,
+	"true":     TRUE,
+	"false":    FALSE,
+	"return":   RETURN,
+	"while":    WHILE,
+	"for":      FOR,
+	"in":       IN,
+	"break":    BREAK,
+	"continue": CONTINUE,
+}
+
+// Keywords returns the keywords of the language in sorted order
+func Keywords() []string {
+	names := make([]string, 0, len(keywords))
+	for name := range keywords {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
 func LookupIdent(ident string) Type {
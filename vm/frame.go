@@ -0,0 +1,32 @@
+package vm
+
+import (
+	"interpreters/code"
+	"interpreters/object"
+	"interpreters/token"
+)
+
+// Frame is the activation record of a closure call
+type Frame struct {
+	cl *object.Closure
+	// ip points at the instruction being executed
+	ip int
+	// basePointer is the stack pointer before the call; locals live above it
+	basePointer int
+	// loops holds the height of the stack when each enclosing loop of the
+	// function was entered, innermost last
+	loops []int
+}
+
+func NewFrame(cl *object.Closure, basePointer int) *Frame {
+	return &Frame{cl: cl, ip: -1, basePointer: basePointer}
+}
+
+func (f *Frame) Instructions() code.Instructions {
+	return f.cl.Fn.Instructions
+}
+
+// pos returns the source position of the instruction being executed
+func (f *Frame) pos() token.Position {
+	return f.cl.Fn.SourceMap.Lookup(f.ip)
+}
@@ -0,0 +1,928 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"interpreters/code"
+	"interpreters/compiler"
+	"interpreters/object"
+	"strings"
+)
+
+const (
+	StackSize   = 2048
+	GlobalsSize = 65536
+	MaxFrames   = 1024
+)
+
+var (
+	True  = object.TRUE
+	False = object.FALSE
+	Null  = object.NULL
+)
+
+// VM executes the bytecode produced by the compiler. Its results and error
+// messages match those of evaluator.Eval for the same program.
+type VM struct {
+	constants []object.Object
+
+	stack []object.Object
+	// sp always points to the next free slot; the top of the stack is stack[sp-1]
+	sp int
+
+	globals     []object.Object
+	globalNames []string
+
+	frames      []*Frame
+	framesIndex int
+
+	// lastPopped is the value of the last expression statement, or nil
+	// when the program ended with a let statement
+	lastPopped object.Object
+
+	// state tracks the run against its context and limits
+	state *object.State
+}
+
+func New(bytecode *compiler.Bytecode) *VM {
+	mainFn := &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		SourceMap:    bytecode.SourceMap,
+	}
+	mainClosure := &object.Closure{Fn: mainFn}
+	mainFrame := NewFrame(mainClosure, 0)
+
+	frames := make([]*Frame, MaxFrames)
+	frames[0] = mainFrame
+
+	return &VM{
+		constants:   bytecode.Constants,
+		stack:       make([]object.Object, StackSize),
+		globals:     make([]object.Object, GlobalsSize),
+		globalNames: bytecode.GlobalNames,
+		frames:      frames,
+		framesIndex: 1,
+		state:       object.NewState(context.Background(), object.Limits{}),
+	}
+}
+
+// SetState makes the vm run under state, which may be shared with other
+// vms running on behalf of the same evaluation
+func (vm *VM) SetState(state *object.State) {
+	vm.state = state
+}
+
+// NewWithGlobalsState creates a vm that shares globals with earlier runs,
+// as the REPL does between inputs
+func NewWithGlobalsState(bytecode *compiler.Bytecode, s []object.Object) *VM {
+	vm := New(bytecode)
+	vm.globals = s
+	return vm
+}
+
+// LastPoppedStackElem returns the value the program evaluated to
+func (vm *VM) LastPoppedStackElem() object.Object {
+	return vm.lastPopped
+}
+
+func (vm *VM) currentFrame() *Frame {
+	return vm.frames[vm.framesIndex-1]
+}
+
+func (vm *VM) pushFrame(f *Frame) error {
+	if vm.framesIndex >= MaxFrames {
+		return vm.newError("stack overflow")
+	}
+	vm.frames[vm.framesIndex] = f
+	vm.framesIndex++
+	return nil
+}
+
+func (vm *VM) popFrame() *Frame {
+	vm.framesIndex--
+	return vm.frames[vm.framesIndex]
+}
+
+// Run executes the program. Runtime errors are returned as *object.Error.
+func (vm *VM) Run() error {
+	return vm.run(0)
+}
+
+// Call calls fn, a closure or a builtin, with args and returns its result.
+// It lets Go code call back into Monkey functions using the globals and
+// constants of the vm. On an error, the frames the call pushed are dropped so that the VM can
+// keep running the code that called it.
+func (vm *VM) Call(fn object.Object, args ...object.Object) (object.Object, error) {
+	base, sp := vm.framesIndex, vm.sp
+
+	result, err := vm.call(base, fn, args)
+	if err != nil {
+		for vm.framesIndex > base {
+			vm.popFrame()
+			vm.state.Leave()
+		}
+		vm.sp = sp
+		return nil, err
+	}
+	return result, nil
+}
+
+func (vm *VM) call(base int, fn object.Object, args []object.Object) (object.Object, error) {
+	if err := vm.push(fn); err != nil {
+		return nil, err
+	}
+	for _, arg := range args {
+		if err := vm.push(arg); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := vm.executeCall(len(args)); err != nil {
+		return nil, err
+	}
+	// builtins return right away, closures run until their frame is popped
+	if err := vm.run(base); err != nil {
+		return nil, err
+	}
+
+	return vm.pop(), nil
+}
+
+// run executes instructions until the frame at index base returns, or
+// until the main program ends when base is 0
+func (vm *VM) run(base int) error {
+	var ip int
+	var ins code.Instructions
+	var op code.Opcode
+
+	for vm.framesIndex > base && vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+		if err := vm.state.Step(); err != nil {
+			return vm.annotateError(err)
+		}
+
+		vm.currentFrame().ip++
+
+		ip = vm.currentFrame().ip
+		ins = vm.currentFrame().Instructions()
+		op = code.Opcode(ins[ip])
+
+		var err error
+
+		switch op {
+		case code.OpConstant:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+			err = vm.push(vm.constants[constIndex])
+
+		case code.OpPop:
+			vm.lastPopped = vm.pop()
+
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod,
+			code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpLessThan,
+			code.OpGreaterEqual, code.OpLessEqual:
+			err = vm.executeBinaryOperation(op)
+
+		case code.OpTrue:
+			err = vm.push(True)
+
+		case code.OpFalse:
+			err = vm.push(False)
+
+		case code.OpNull:
+			err = vm.push(Null)
+
+		case code.OpBang:
+			err = vm.executeBangOperator()
+
+		case code.OpMinus:
+			err = vm.executeMinusOperator()
+
+		case code.OpJump:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip = pos - 1
+
+		case code.OpJumpNotTruthy:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			condition := vm.pop()
+			if !isTruthy(condition) {
+				vm.currentFrame().ip = pos - 1
+			}
+
+		case code.OpIter:
+			iterable := vm.pop()
+
+			iterator, ok := object.NewIterator(iterable)
+			if !ok {
+				err = vm.newError("not iterable: %s", iterable.Type())
+				break
+			}
+			err = vm.push(iterator)
+
+		case code.OpIterNext:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			iterator := vm.stack[vm.sp-1].(*object.Iterator)
+			element, ok := iterator.Next()
+			if !ok {
+				vm.currentFrame().ip = pos - 1
+				break
+			}
+			err = vm.push(element)
+
+		case code.OpLoop:
+			frame := vm.currentFrame()
+			frame.loops = append(frame.loops, vm.sp)
+
+		case code.OpEndLoop:
+			frame := vm.currentFrame()
+			frame.loops = frame.loops[:len(frame.loops)-1]
+
+		case code.OpLoopJump:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			frame := vm.currentFrame()
+			// drop the operands of the expression break or continue left
+			vm.sp = frame.loops[len(frame.loops)-1]
+			frame.ip = pos - 1
+
+		case code.OpSetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+			vm.globals[globalIndex] = vm.pop()
+			// let statements have no value of their own
+			vm.lastPopped = nil
+
+		case code.OpGetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			value := vm.globals[globalIndex]
+			if value == nil {
+				err = vm.newError("identifier not found: %s", vm.globalNames[globalIndex])
+				break
+			}
+			err = vm.push(value)
+
+		case code.OpSetLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			slot := &vm.stack[vm.currentFrame().basePointer+int(localIndex)]
+			if cell, ok := (*slot).(*object.Cell); ok {
+				cell.Value = vm.pop()
+			} else {
+				*slot = vm.pop()
+			}
+			vm.lastPopped = nil
+
+		case code.OpGetLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			value := deref(vm.stack[vm.currentFrame().basePointer+int(localIndex)])
+			if value == nil {
+				err = vm.newError("identifier not found: %s", vm.currentFrame().cl.Fn.LocalNames[localIndex])
+				break
+			}
+			err = vm.push(value)
+
+		case code.OpGetLocalCell:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			err = vm.push(box(&vm.stack[vm.currentFrame().basePointer+int(localIndex)]))
+
+		case code.OpGetBuiltin:
+			builtinIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			err = vm.push(object.Builtins[builtinIndex].Builtin)
+
+		case code.OpGetFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			currentClosure := vm.currentFrame().cl
+			value := deref(currentClosure.Free[freeIndex])
+			if value == nil {
+				err = vm.newError("identifier not found: %s", currentClosure.Fn.FreeNames[freeIndex])
+				break
+			}
+			err = vm.push(value)
+
+		case code.OpGetFreeCell:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			err = vm.push(box(&vm.currentFrame().cl.Free[freeIndex]))
+
+		case code.OpCurrentClosure:
+			err = vm.push(vm.currentFrame().cl)
+
+		case code.OpAssignGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			if vm.globals[globalIndex] == nil {
+				err = vm.newError("identifier not found: %s", vm.globalNames[globalIndex])
+				break
+			}
+			vm.globals[globalIndex] = vm.stack[vm.sp-1]
+
+		case code.OpAssignLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			slot := &vm.stack[vm.currentFrame().basePointer+int(localIndex)]
+			if deref(*slot) == nil {
+				err = vm.newError("identifier not found: %s", vm.currentFrame().cl.Fn.LocalNames[localIndex])
+				break
+			}
+			if cell, ok := (*slot).(*object.Cell); ok {
+				cell.Value = vm.stack[vm.sp-1]
+			} else {
+				*slot = vm.stack[vm.sp-1]
+			}
+
+		case code.OpAssignFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			// free variables are always captured as cells, so the scope
+			// that defined the variable sees the assignment
+			box(&vm.currentFrame().cl.Free[freeIndex]).Value = vm.stack[vm.sp-1]
+
+		case code.OpArray:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			array := vm.buildArray(vm.sp-numElements, vm.sp)
+			vm.sp = vm.sp - numElements
+
+			err = vm.pushAllocated(array)
+
+		case code.OpHash:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			var hash object.Object
+			hash, err = vm.buildHash(vm.sp-numElements, vm.sp)
+			if err != nil {
+				break
+			}
+			vm.sp = vm.sp - numElements
+
+			err = vm.pushAllocated(hash)
+
+		case code.OpIndex:
+			index := vm.pop()
+			left := vm.pop()
+
+			err = vm.executeIndexExpression(left, index)
+
+		case code.OpSetIndex:
+			op := code.Opcode(code.ReadUint8(ins[ip+1:]))
+			vm.currentFrame().ip += 1
+
+			err = vm.executeSetIndex(op)
+
+		case code.OpSlice:
+			err = vm.executeSlice()
+
+		case code.OpInterpolate:
+			numParts := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			var out strings.Builder
+			for _, part := range vm.stack[vm.sp-numParts : vm.sp] {
+				out.WriteString(part.Inspect())
+			}
+			vm.sp = vm.sp - numParts
+
+			err = vm.pushAllocated(&object.String{Value: out.String()})
+
+		case code.OpCall:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			err = vm.executeCall(int(numArgs))
+
+		case code.OpReturnValue:
+			returnValue := vm.pop()
+
+			// a return statement outside of a function ends the program
+			if vm.framesIndex == 1 {
+				vm.lastPopped = returnValue
+				return nil
+			}
+
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1
+			vm.state.Leave()
+
+			err = vm.push(returnValue)
+
+		case code.OpReturn:
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1
+			vm.state.Leave()
+
+			err = vm.push(Null)
+
+		case code.OpClosure:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			numFree := code.ReadUint8(ins[ip+3:])
+			vm.currentFrame().ip += 3
+
+			err = vm.pushClosure(int(constIndex), int(numFree))
+
+		default:
+			def, _ := code.Lookup(byte(op))
+			err = fmt.Errorf("unhandled opcode %s", def.Name)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (vm *VM) newError(format string, a ...interface{}) *object.Error {
+	return vm.annotateError(&object.Error{Message: fmt.Sprintf(format, a...)})
+}
+
+// annotateError positions err at the instruction being executed and adds a
+// stack frame for every active function call
+func (vm *VM) annotateError(err *object.Error) *object.Error {
+	err.Pos = vm.currentFrame().pos()
+
+	for i := vm.framesIndex - 1; i > 0; i-- {
+		name := vm.frames[i].cl.Fn.Name
+		if name == "" {
+			name = "<anonymous>"
+		}
+		err.Stack = append(err.Stack, object.Frame{Function: name, Pos: vm.frames[i-1].pos()})
+	}
+
+	return err
+}
+
+func (vm *VM) push(o object.Object) error {
+	if vm.sp >= StackSize {
+		return vm.newError("stack overflow")
+	}
+
+	vm.stack[vm.sp] = o
+	vm.sp++
+
+	return nil
+}
+
+// pushAllocated pushes o, a newly created object, after charging its size
+// to the allocation budget
+func (vm *VM) pushAllocated(o object.Object) error {
+	if err := vm.state.Allocate(object.SizeOf(o)); err != nil {
+		return vm.annotateError(err)
+	}
+	return vm.push(o)
+}
+
+func (vm *VM) pop() object.Object {
+	o := vm.stack[vm.sp-1]
+	vm.sp--
+	return o
+}
+
+// deref returns the value of a variable slot, looking through its cell if
+// the variable has been captured by a closure
+func deref(slot object.Object) object.Object {
+	if cell, ok := slot.(*object.Cell); ok {
+		return cell.Value
+	}
+	return slot
+}
+
+// box returns the cell of a variable slot, creating it on first capture
+func box(slot *object.Object) *object.Cell {
+	if cell, ok := (*slot).(*object.Cell); ok {
+		return cell
+	}
+	cell := &object.Cell{Value: *slot}
+	*slot = cell
+	return cell
+}
+
+var binaryOperators = map[code.Opcode]string{
+	code.OpAdd:         "+",
+	code.OpSub:         "-",
+	code.OpMul:         "*",
+	code.OpDiv:         "/",
+	code.OpMod:         "%",
+	code.OpEqual:       "==",
+	code.OpNotEqual:    "!=",
+	code.OpGreaterThan: ">",
+	code.OpLessThan:    "<",
+
+	code.OpGreaterEqual: ">=",
+	code.OpLessEqual:    "<=",
+}
+
+func (vm *VM) executeBinaryOperation(op code.Opcode) error {
+	right := vm.pop()
+	left := vm.pop()
+	operator := binaryOperators[op]
+
+	if left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ {
+		return vm.executeBinaryIntegerOperation(operator, left, right)
+	}
+
+	// an integer used along with a float is promoted to a float
+	if isNumber(left) && isNumber(right) {
+		return vm.executeBinaryFloatOperation(operator, left, right)
+	}
+
+	if left.Type() != right.Type() {
+		return vm.newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
+	}
+
+	switch left.Type() {
+	case object.STRING_OBJ:
+		return vm.executeBinaryStringOperation(operator, left, right)
+	}
+
+	switch op {
+	case code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(left == right))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(left != right))
+	}
+	return vm.newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+}
+
+func (vm *VM) executeBinaryIntegerOperation(operator string, left, right object.Object) error {
+	leftValue := left.(*object.Integer).Value
+	rightValue := right.(*object.Integer).Value
+
+	switch operator {
+	case "+":
+		return vm.push(&object.Integer{Value: leftValue + rightValue})
+	case "-":
+		return vm.push(&object.Integer{Value: leftValue - rightValue})
+	case "*":
+		return vm.push(&object.Integer{Value: leftValue * rightValue})
+	case "/":
+		if rightValue == 0 {
+			return vm.newError("division by zero")
+		}
+		return vm.push(&object.Integer{Value: leftValue / rightValue})
+	case "%":
+		if rightValue == 0 {
+			return vm.newError("division by zero")
+		}
+		return vm.push(&object.Integer{Value: leftValue % rightValue})
+	case ">":
+		return vm.push(nativeBoolToBooleanObject(leftValue > rightValue))
+	case "<":
+		return vm.push(nativeBoolToBooleanObject(leftValue < rightValue))
+	case ">=":
+		return vm.push(nativeBoolToBooleanObject(leftValue >= rightValue))
+	case "<=":
+		return vm.push(nativeBoolToBooleanObject(leftValue <= rightValue))
+	case "==":
+		return vm.push(nativeBoolToBooleanObject(leftValue == rightValue))
+	case "!=":
+		return vm.push(nativeBoolToBooleanObject(leftValue != rightValue))
+	default:
+		return vm.newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+func (vm *VM) executeBinaryFloatOperation(operator string, left, right object.Object) error {
+	leftValue := toFloat(left)
+	rightValue := toFloat(right)
+
+	switch operator {
+	case "+":
+		return vm.push(&object.Float{Value: leftValue + rightValue})
+	case "-":
+		return vm.push(&object.Float{Value: leftValue - rightValue})
+	case "*":
+		return vm.push(&object.Float{Value: leftValue * rightValue})
+	case "/":
+		if rightValue == 0 {
+			return vm.newError("division by zero")
+		}
+		return vm.push(&object.Float{Value: leftValue / rightValue})
+	case ">":
+		return vm.push(nativeBoolToBooleanObject(leftValue > rightValue))
+	case "<":
+		return vm.push(nativeBoolToBooleanObject(leftValue < rightValue))
+	case ">=":
+		return vm.push(nativeBoolToBooleanObject(leftValue >= rightValue))
+	case "<=":
+		return vm.push(nativeBoolToBooleanObject(leftValue <= rightValue))
+	case "==":
+		return vm.push(nativeBoolToBooleanObject(leftValue == rightValue))
+	case "!=":
+		return vm.push(nativeBoolToBooleanObject(leftValue != rightValue))
+	default:
+		return vm.newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+func isNumber(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.FLOAT_OBJ
+}
+
+// toFloat returns the value of an Integer or Float as a float64
+func toFloat(obj object.Object) float64 {
+	if integer, ok := obj.(*object.Integer); ok {
+		return float64(integer.Value)
+	}
+	return obj.(*object.Float).Value
+}
+
+func (vm *VM) executeBinaryStringOperation(operator string, left, right object.Object) error {
+	if operator != "+" {
+		return vm.newError("operator not supported: %s %s %s ", left.Type(), operator, right.Type())
+	}
+
+	leftValue := left.(*object.String).Value
+	rightValue := right.(*object.String).Value
+
+	// charged before concatenating, as repeated concatenation grows
+	// strings quickly
+	if err := vm.state.Allocate(object.StringSize(int64(len(leftValue) + len(rightValue)))); err != nil {
+		return vm.annotateError(err)
+	}
+	return vm.push(&object.String{Value: leftValue + rightValue})
+}
+
+func (vm *VM) executeBangOperator() error {
+	operand := vm.pop()
+
+	// like the evaluator, !null is false
+	switch operand {
+	case False:
+		return vm.push(True)
+	default:
+		return vm.push(False)
+	}
+}
+
+func (vm *VM) executeMinusOperator() error {
+	operand := vm.pop()
+
+	if float, ok := operand.(*object.Float); ok {
+		return vm.push(&object.Float{Value: -float.Value})
+	}
+	if operand.Type() != object.INTEGER_OBJ {
+		return vm.newError("unknown operator: -%s", operand.Type())
+	}
+
+	value := operand.(*object.Integer).Value
+	return vm.push(&object.Integer{Value: -value})
+}
+
+func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
+	elements := make([]object.Object, endIndex-startIndex)
+
+	for i := startIndex; i < endIndex; i++ {
+		elements[i-startIndex] = vm.stack[i]
+	}
+
+	return &object.Array{Elements: elements}
+}
+
+func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
+	hash := object.NewHash()
+
+	for i := startIndex; i < endIndex; i += 2 {
+		key := vm.stack[i]
+		value := vm.stack[i+1]
+
+		if _, ok := key.(object.Hashable); !ok {
+			return nil, vm.newError("unusable as hash key: %s", key.Type())
+		}
+
+		hash.Set(key, value)
+	}
+
+	return hash, nil
+}
+
+func (vm *VM) executeIndexExpression(left, index object.Object) error {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		return vm.executeArrayIndex(left, index)
+	case left.Type() == object.STRING_OBJ && index.Type() == object.INTEGER_OBJ:
+		return vm.executeStringIndex(left, index)
+	case left.Type() == object.HASH_OBJ:
+		return vm.executeHashIndex(left, index)
+	default:
+		return vm.newError("Index operator not supported: %s", left.Type())
+	}
+}
+
+func (vm *VM) executeArrayIndex(array, index object.Object) error {
+	arrayObject := array.(*object.Array)
+	i := index.(*object.Integer).Value
+	max := int64(len(arrayObject.Elements) - 1)
+
+	if i < 0 || i > max {
+		return vm.push(Null)
+	}
+
+	return vm.push(arrayObject.Elements[i])
+}
+
+func (vm *VM) executeStringIndex(str, index object.Object) error {
+	char, ok := str.(*object.String).CharAt(index.(*object.Integer).Value)
+	if !ok {
+		return vm.push(Null)
+	}
+
+	return vm.pushAllocated(char)
+}
+
+func (vm *VM) executeSlice() error {
+	end := vm.pop()
+	start := vm.pop()
+	left := vm.pop()
+
+	result := object.Slice(left, start, end)
+	if err, ok := result.(*object.Error); ok {
+		return vm.annotateError(err)
+	}
+	return vm.pushAllocated(result)
+}
+
+func (vm *VM) executeHashIndex(hash, index object.Object) error {
+	hashObject := hash.(*object.Hash)
+
+	key, ok := index.(object.Hashable)
+	if !ok {
+		return vm.newError("unusable as hash key: %s", index.Type())
+	}
+
+	pair, ok := hashObject.Pairs[key.HashKey()]
+	if !ok {
+		return vm.push(Null)
+	}
+
+	return vm.push(pair.Value)
+}
+
+// executeSetIndex stores the value on top of the stack into the array or
+// hash below it. For a compound assignment, op is the binary operation
+// combining the current element with the value.
+func (vm *VM) executeSetIndex(op code.Opcode) error {
+	value := vm.pop()
+	index := vm.pop()
+	left := vm.pop()
+
+	if op != 0 {
+		if err := vm.executeIndexExpression(left, index); err != nil {
+			return err
+		}
+		if err := vm.push(value); err != nil {
+			return err
+		}
+		if err := vm.executeBinaryOperation(op); err != nil {
+			return err
+		}
+		value = vm.pop()
+	}
+
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		array := left.(*object.Array)
+		i := index.(*object.Integer).Value
+		if i < 0 || i >= int64(len(array.Elements)) {
+			return vm.newError("index out of range: %d", i)
+		}
+		array.Elements[i] = value
+
+	case left.Type() == object.HASH_OBJ:
+		hash := left.(*object.Hash)
+		if _, ok := index.(object.Hashable); !ok {
+			return vm.newError("unusable as hash key: %s", index.Type())
+		}
+		hash.Set(index, value)
+
+	default:
+		return vm.newError("index assignment not supported: %s", left.Type())
+	}
+
+	return vm.push(value)
+}
+
+func (vm *VM) executeCall(numArgs int) error {
+	callee := vm.stack[vm.sp-1-numArgs]
+	switch callee := callee.(type) {
+	case *object.Closure:
+		return vm.callClosure(callee, numArgs)
+	case *object.Builtin:
+		return vm.callBuiltin(callee, numArgs)
+	default:
+		return vm.newError("not a function: %s", callee.Type())
+	}
+}
+
+func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
+	if numArgs != cl.Fn.NumParameters {
+		return vm.newError("wrong number of arguments: want=%d, got=%d", cl.Fn.NumParameters, numArgs)
+	}
+
+	basePointer := vm.sp - numArgs
+	if basePointer+cl.Fn.NumLocals >= StackSize {
+		return vm.newError("stack overflow")
+	}
+	if err := vm.state.Enter(); err != nil {
+		return vm.annotateError(err)
+	}
+	if err := vm.state.Allocate(object.CallSize); err != nil {
+		vm.state.Leave()
+		return vm.annotateError(err)
+	}
+	if err := vm.pushFrame(NewFrame(cl, basePointer)); err != nil {
+		vm.state.Leave()
+		return err
+	}
+
+	// slots may still hold values, or cells, left by earlier calls
+	for i := basePointer + numArgs; i < basePointer+cl.Fn.NumLocals; i++ {
+		vm.stack[i] = nil
+	}
+	vm.sp = basePointer + cl.Fn.NumLocals
+
+	return nil
+}
+
+func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
+	args := vm.stack[vm.sp-numArgs : vm.sp]
+
+	result := builtin.Call(vm.apply, vm.state, args...)
+	if err, ok := result.(*object.Error); ok {
+		// errors from functions the builtin called are annotated already
+		if err.Pos.IsValid() {
+			return err
+		}
+		return vm.annotateError(err)
+	}
+	vm.sp = vm.sp - numArgs - 1
+
+	if result == nil {
+		return vm.push(Null)
+	}
+	return vm.push(result)
+}
+
+// apply calls fn on behalf of a higher order builtin
+func (vm *VM) apply(fn object.Object, args ...object.Object) object.Object {
+	result, err := vm.Call(fn, args...)
+	if err != nil {
+		if errObj, ok := err.(*object.Error); ok {
+			return errObj
+		}
+		return &object.Error{Message: err.Error()}
+	}
+	return result
+}
+
+func (vm *VM) pushClosure(constIndex int, numFree int) error {
+	constant := vm.constants[constIndex]
+	function, ok := constant.(*object.CompiledFunction)
+	if !ok {
+		return fmt.Errorf("not a function: %+v", constant)
+	}
+
+	free := make([]object.Object, numFree)
+	for i := 0; i < numFree; i++ {
+		free[i] = vm.stack[vm.sp-numFree+i]
+	}
+	vm.sp = vm.sp - numFree
+
+	closure := &object.Closure{Fn: function, Free: free}
+	return vm.pushAllocated(closure)
+}
+
+func isTruthy(obj object.Object) bool {
+	switch obj {
+	case Null, False:
+		return false
+	default:
+		return true
+	}
+}
+
+func nativeBoolToBooleanObject(input bool) *object.Boolean {
+	if input {
+		return True
+	}
+	return False
+}
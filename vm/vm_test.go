@@ -0,0 +1,592 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"interpreters/ast"
+	"interpreters/compiler"
+	"interpreters/evaluator"
+	"interpreters/lexer"
+	"interpreters/object"
+	"interpreters/parser"
+	"testing"
+)
+
+type vmTestCase struct {
+	input    string
+	expected interface{}
+}
+
+func TestIntegerArithmetic(t *testing.T) {
+	tests := []vmTestCase{
+		{"1", 1},
+		{"2", 2},
+		{"1 + 2", 3},
+		{"1 - 2", -1},
+		{"1 * 2", 2},
+		{"4 / 2", 2},
+		{"50 / 2 * 2 + 10 - 5", 55},
+		{"5 * (2 + 10)", 60},
+		{"-5", -5},
+		{"-50 + 100 + -50", 0},
+		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestFloatArithmetic(t *testing.T) {
+	tests := []vmTestCase{
+		{"1.5 + 1.5", 3.0},
+		{"1 + 0.5", 1.5},
+		{"10 / 4.0", 2.5},
+		{"-2.5 * 2", -5.0},
+		{"1 == 1.0", true},
+		{"0.5 < 1", true},
+		{"round(2.5) + floor(1.9) + ceil(0.1)", 5},
+		{"1 / 0", &object.Error{Message: "division by zero"}},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestBooleanExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"true", true},
+		{"false", false},
+		{"1 < 2", true},
+		{"1 > 2", false},
+		{"1 == 1", true},
+		{"1 != 2", true},
+		{"true == false", false},
+		{"(1 < 2) == true", true},
+		{"!true", false},
+		{"!!5", true},
+		{"!(if (false) { 5; })", false},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestComparisonAndLogicalOperators(t *testing.T) {
+	tests := []vmTestCase{
+		{"1 <= 2", true},
+		{"3 <= 2", false},
+		{"2 >= 2", true},
+		{"2.5 >= 3", false},
+		{"7 % 3", 1},
+		{"true && false", false},
+		{"1 && 2", true},
+		{"false || 0", true},
+		{"false || false", false},
+		{"false && undefined", false},
+		{"true || undefined", true},
+		{"let x = 0; let f = fn() { x = 1; true }; false && f(); x", 0},
+		{"let x = 0; let f = fn() { x = 1; true }; false || f(); x", 1},
+		{"if (1 < 2 && 3 >= 3) { 10 } else { 20 }", 10},
+		{"1 % 0", &object.Error{Message: "division by zero"}},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestConditionals(t *testing.T) {
+	tests := []vmTestCase{
+		{"if (true) { 10 }", 10},
+		{"if (1) { 10 }", 10},
+		{"if (1 < 2) { 10 } else { 20 }", 10},
+		{"if (1 > 2) { 10 } else { 20 }", 20},
+		{"if (1 > 2) { 10 }", Null},
+		{"if ((if (false) { 10 })) { 10 } else { 20 }", 20},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestGlobalLetStatements(t *testing.T) {
+	tests := []vmTestCase{
+		{"let one = 1; one", 1},
+		{"let one = 1; let two = one + one; one + two", 3},
+		{"let one = 1; let one = one + 1; one", 2},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestStringArrayAndHashExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{`"mon" + "key" + "banana"`, "monkeybanana"},
+		{"[1 + 2, 3 * 4][1]", 12},
+		{"[1, 2, 3][3]", Null},
+		{"[1, 2, 3][-1]", Null},
+		{"{1: 1, 2: 2}[2]", 2},
+		{"{}[0]", Null},
+		{`let key = "foo"; {"foo": 5}[key]`, 5},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestLoops(t *testing.T) {
+	tests := []vmTestCase{
+		{"let i = 0; while (i < 5) { let i = i + 1; }; i", 5},
+		{"while (false) { 1 }", Null},
+		{"let i = 0; while (true) { let i = i + 1; if (i == 3) { break; } }; i", 3},
+		{"let s = 0; for (x in [1, 2, 3, 4]) { if (x == 2) { continue; } let s = s + x; }; s", 8},
+		{"let s = 0; for (i in range(10, 0, -2)) { let s = s + i; }; s", 30},
+		{`let s = ""; for (k in {"b": 1, "a": 2}) { let s = s + k; }; s`, "ba"},
+		{"let f = fn() { let s = 0; for (i in range(5)) { let s = s + i; }; s }; f()", 10},
+		{"let f = fn() { for (x in [1, 2, 3]) { if (x == 2) { return x * 10; } } }; f()", 20},
+		{"let f = fn() { while (true) { let g = fn() { 1 }; break; } }; f()", Null},
+		{"let n = 0; for (i in range(3)) { for (j in range(3)) { if (j == 1) { break; } let n = n + 1; } }; n", 3},
+		{"let s = 0; for (x in [1, 2, 3]) { s = s + (1 + if (x == 1) { continue } else { x }) }; s", 7},
+		{"let r = []; for (x in [1, 2, 3]) { r = push(r, if (x == 2) { continue } else { x }) }; r[0] + r[1]", 4},
+		{"let s = 0; for (x in [1, 2, 3]) { s = s + if (x == 2) { break } else { x } }; s", 1},
+		{"let i = 0; let s = 0; while (i < 3) { i = i + 1; s = s + if (i == 2) { continue } else { i } }; s", 4},
+		{"let f = fn() { let s = 0; for (x in [1, 2, 3]) { let y = [if (x == 2) { continue } else { x }]; s = s + y[0] }; s }; f()", 4},
+		{"for (x in 5) { x }", &object.Error{Message: "not iterable: INTEGER"}},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestAssignments(t *testing.T) {
+	tests := []vmTestCase{
+		{"let x = 1; x = 2; x", 2},
+		{"let a = 0; let b = 0; a = b = 3; a + b", 6},
+		{"let x = 10; x += 5; x -= 3; x *= 2; x /= 4; x", 6},
+		{"let f = fn() { let x = 1; x += 1; x }; f()", 2},
+		{"let count = 0; let inc = fn() { count += 1 }; inc(); inc(); count", 2},
+		{"let counter = fn() { let n = 0; fn() { n = n + 1 } }; let c = counter(); c(); c()", 2},
+		{"let f = fn() { let n = 0; let g = fn() { fn() { n += 5 } }; g()(); n }; f()", 5},
+		{"let arr = [1, 2, 3]; arr[2] *= 3; arr[2]", 9},
+		{`let h = {"a": 1}; h["b"] = 2; h["a"] += h["b"]; h["a"]`, 3},
+		{"x = 1", &object.Error{Message: "identifier not found: x"}},
+		{"let f = fn() { y = 1 }; f()", &object.Error{Message: "identifier not found: y"}},
+		{"[1, 2][2] = 1", &object.Error{Message: "index out of range: 2"}},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestCallingFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{"let fivePlusTen = fn() { 5 + 10; }; fivePlusTen();", 15},
+		{"let earlyExit = fn() { return 99; 100; }; earlyExit();", 99},
+		{"let noReturn = fn() { }; noReturn();", Null},
+		{"let sum = fn(a, b) { let c = a + b; c; }; sum(1, 2) + sum(3, 4);", 10},
+		{"let identity = fn(x) { x }; identity(5);", 5},
+		{"fn(x, y) { x + y; }(5, 5);", 10},
+		{"9; return 2 * 5; 9", 10},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestClosures(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			`let newAdder = fn(a, b) { fn(c) { a + b + c } };
+			let adder = newAdder(1, 2);
+			adder(8);`,
+			11,
+		},
+		{
+			`let newClosure = fn(a) { fn() { fn() { a } } };
+			newClosure(99)()();`,
+			99,
+		},
+		{
+			`let f = fn() {
+				let x = 1;
+				let g = fn() { x };
+				let x = 2;
+				g();
+			};
+			f();`,
+			2,
+		},
+		{
+			`let countDown = fn(x) { if (x == 0) { return 0; } else { countDown(x - 1); } };
+			let wrapper = fn() { countDown(1); };
+			wrapper();`,
+			0,
+		},
+		{
+			`let fibonacci = fn(x) {
+				if (x == 0) { return 0; }
+				if (x == 1) { return 1; }
+				fibonacci(x - 1) + fibonacci(x - 2);
+			};
+			fibonacci(15);`,
+			610,
+		},
+		{
+			`let callLater = fn() { later() };
+			let later = fn() { 7 };
+			callLater();`,
+			7,
+		},
+		{
+			`let f = fn() {
+				let g = fn() { h() };
+				let h = fn() { 1 };
+				g();
+			};
+			f();`,
+			1,
+		},
+		{
+			`let h = fn() { 2 };
+			let f = fn() {
+				let g = fn() { h() };
+				let before = h();
+				let h = fn() { 1 };
+				before * 10 + g();
+			};
+			f();`,
+			21,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestBuiltinFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{`len("")`, 0},
+		{`len("four")`, 4},
+		{`len(1)`, &object.Error{Message: "argument to `len` not supported, got INTEGER"}},
+		{`len("one", "two")`, &object.Error{Message: "wrong number of arguments. got=2 want=1"}},
+		{`puts("hello")`, Null},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestRuntimeErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{"5 + true", &object.Error{Message: "type mismatch: INTEGER + BOOLEAN"}},
+		{"-true", &object.Error{Message: "unknown operator: -BOOLEAN"}},
+		{"true < false", &object.Error{Message: "unknown operator: BOOLEAN < BOOLEAN"}},
+		{"foobar", &object.Error{Message: "identifier not found: foobar"}},
+		{"fn() { 1 }(1)", &object.Error{Message: "wrong number of arguments: want=0, got=1"}},
+		{"1()", &object.Error{Message: "not a function: INTEGER"}},
+		{"{fn(){}: 1}", &object.Error{Message: "unusable as hash key: CLOSURE"}},
+		{"let f = fn() { f() }; f()", &object.Error{Message: "maximum call depth exceeded: 1000 calls"}},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestErrorStack(t *testing.T) {
+	input := `let inner = fn(x) {
+  x + true
+};
+let outer = fn(x) {
+  inner(x)
+};
+fn() { outer(1) }();`
+
+	program := parse(input)
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	err := vm.Run()
+	errObj, ok := err.(*object.Error)
+	if !ok {
+		t.Fatalf("expected *object.Error, got: %T (%v)", err, err)
+	}
+
+	expected := `Traceback (most recent call last):
+  at 7:1, in <main>
+  at 7:8, in <anonymous>
+  at 5:3, in outer
+  at 2:3, in inner
+error: type mismatch: INTEGER + BOOLEAN`
+
+	if errObj.Traceback() != expected {
+		t.Errorf("traceback wrong. want:\n%s\ngot:\n%s", expected, errObj.Traceback())
+	}
+}
+
+// TestEvaluatorParity runs the same programs through evaluator.Eval and the
+// vm and expects the same result or the same error message
+func TestEvaluatorParity(t *testing.T) {
+	inputs := []string{
+		"5 * 2 + 10",
+		"2 * ( 5 + 10)",
+		"(1 > 2) == false",
+		"!!false",
+		"!5",
+		"if (0) { 10 }",
+		"if (false) { 10 }",
+		"return 10*10",
+		"if (10 > 1) { if (10 > 1) { return 10; } return 1; }",
+		"5  + true",
+		"if ( 10 > 1) { true + false; }",
+		`"a" == "a"`,
+		"let a = 5; let b = a; let c = a + b + 5; c;",
+		"let a = 5;",
+		"let add = fn(x, y) { x + y}; add(5+5, add(5,5));",
+		"fn(x) { x  + 10; }(1)",
+		`"Hello" + " " + "World!"`,
+		`len("four")`,
+		"len(1);",
+		"[1, 2 * 2, 3 + 3]",
+		"[1, 2, 3][true]",
+		`{"foo": 5}["foo"]`,
+		`{false: 5}[false]`,
+		`{"foo": 5}[[]]`,
+		"[1] == [1]",
+		"let x = 1; let f = fn() { let y = x; let x = 2; y + x }; f()",
+		"let f = fn(x) { if (x > 0) { return undefined; } 1 }; f(1)",
+		"let f = fn() { if (false) { let a = 1; } a }; f()",
+		"let i = 0; while (i < 3) { let i = i + 1; i }",
+		"for (x in [1, 2]) { x }",
+		"let f = fn() { if (true) { for (x in [1]) { x } } }; f()",
+		"let r = []; for (x in [1, 2, 3]) { let y = if (x == 2) { continue } else { x }; r = push(r, y) }; r",
+		"let r = []; for (x in [1, 2, 3]) { r = push(r, [x, if (x == 2) { break } else { x }]) }; r",
+		"let f = fn() { 1 + if (true) { return 5 } }; f()",
+		"let f = fn() { let g = fn() { h() }; let h = fn() { 1 }; g() }; f()",
+		"let f = fn() { f = 1 }; f()",
+		"let f = fn() { f = 1 }; f(); f",
+		"let f = fn() { f = 1; f }; f()",
+		"let f = fn() { let g = fn() { f = 2 }; g(); f }; f()",
+		"let outer = fn() { let f = fn(n) { if (n > 0) { f(n - 1) } else { f = 5 } }; f(2); f }; outer()",
+		"let f = fn() { let g = fn() { h() }; g() }; let h = fn() { 2 }; f()",
+		"let f = fn() { let g = fn() { h() }; let r = g(); let h = fn() { 1 }; r }; f()",
+		`repeat("ab", 4611686018427387904)`,
+		`pad_left("a", 4611686018427387904)`,
+		// closures share the loop variable rather than capture one per iteration
+		"let f = 0; for (i in range(3)) { if (i == 0) { let f = fn() { i }; } }; f()",
+		"let g = fn() { let f = 0; for (i in range(3)) { if (i == 0) { let f = fn() { i }; } }; f() }; g()",
+		"for (x in [1, 2]) { x + true }",
+		"let x = 1; x = 2",
+		"3.0 * 2",
+		"7 / 2.0 - 1",
+		"1.5 > 1.5",
+		"2.0 == 2",
+		"-0.5",
+		"1.5 / 0",
+		"true * 1.5",
+		`"a" - 1.5`,
+		"{1.5: 1}[1.5]",
+		"5 % 3 <= 2 && 1 >= 1.0",
+		"null || true",
+		"1 + true && false",
+		"false || 1 + true",
+		`"a" <= "b"`,
+		"let x = 1; x += true",
+		"let a = [1]; a[-1] += 1",
+		`{"a": 1}[[]] = 1`,
+		`"abc"[0] = "x"`,
+		"let f = fn() { let x = 1; let g = fn() { x = x + 1 }; g(); g(); x }; f()",
+		"len([1, 2]) + len({1: 2})",
+		"push(rest([1, 2, 3]), 4, 5)",
+		"sort([3, 1.5, 2])",
+		"sort([3, 1, 2], fn(a, b) { a > b })",
+		"let by = fn(key) { fn(a, b) { a[key] < b[key] } }; sort([[2], [1]], by(0))",
+		"sort([2, 1], fn(a, b) { a + true })",
+		"sort([2, 1], fn(a) { true })",
+		"sort([2, 1], fn(a, b) { 1 })",
+		`sort([1, "a"])`,
+		"map([1, 2, 3], fn(x) { x * 2 })",
+		"let k = 3; filter(range(6), fn(x) { x % k == 0 })",
+		"reduce([1, 2, 3], fn(acc, x) { acc + x }, 10)",
+		"let total = 0; each([1, 2, 3], fn(x) { total = total + x }); total",
+		"let f = fn() { let total = 0; each([1, 2], fn(x) { total += x }); total }; f()",
+		"[any([1], fn(x) { x > 0 }), all([1], fn(x) { x > 1 }), find([1, 2], fn(x) { x > 1 })]",
+		"zip([1, 2], [3])",
+		"group_by([1, 2, 3], fn(x) { x % 2 })[1]",
+		"flat_map([1, 2], fn(x) { [x, x] })",
+		"map([1, 2], fn(x) { x + true })",
+		"map([[1]], fn(a) { map(a, fn(x) { x + 1 }) })",
+		"map([1], fn() { 1 })",
+		"map([1], 1)",
+		`let h = {"b": 1, "a": 2}; [keys(h), values(h), items(h), has(h, "a")]`,
+		`merge(delete({"a": 1, "b": 2}, "a"), {"c": 3})`,
+		`{"b": [1], "a": {2: true}}`,
+		`"hello"[1]`,
+		`"hello"[9]`,
+		`["hello"[1:3], "hello"[:-1], "hello"[3:], [1, 2, 3][:]]`,
+		`let s = "abc"; let f = fn(n) { s[n:] }; f(1)`,
+		`"hello"[true:]`,
+		`5[1:]`,
+		`format("%s-%d", upper("a"), len(split("a b", " ")))`,
+		`pad_left(trim(" 7 "), 3, "0")`,
+		`format("%d", 1.5)`,
+		`let name = "Ann"; let items = [1, 2.5, {"a": true}]; "Hello ${name}, ${len(items)} items: ${items} ${if (false) { 1 }}"`,
+		`let f = fn(x) { "<${x}>" }; "${map([1, 2], f)} ${"a ${"b"}"}"`,
+		`"a ${1 + true} b"`,
+		`let größe = "日本語"; [len(größe), bytes_len(größe), größe[1], größe[1:]]`,
+	}
+
+	for _, input := range inputs {
+		env := object.NewEnvironment()
+		expected := evaluator.Eval(parse(input), env)
+
+		comp := compiler.New()
+		if err := comp.Compile(parse(input)); err != nil {
+			t.Fatalf("%q: compiler error: %s", input, err)
+		}
+		vm := New(comp.Bytecode())
+
+		var actual object.Object
+		if err := vm.Run(); err != nil {
+			actual = err.(*object.Error)
+		} else {
+			actual = vm.LastPoppedStackElem()
+		}
+
+		if describe(actual) != describe(expected) {
+			t.Errorf("%q: evaluator and vm disagree. evaluator: %s, vm: %s", input, describe(expected), describe(actual))
+		}
+	}
+}
+
+func describe(obj object.Object) string {
+	if obj == nil {
+		return "<nil>"
+	}
+	return string(obj.Type()) + " " + obj.Inspect()
+}
+
+func parse(input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	return p.ParseProgram()
+}
+
+func runVmTests(t *testing.T, tests []vmTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err := vm.Run()
+
+		if expectedErr, ok := tt.expected.(*object.Error); ok {
+			if err == nil {
+				t.Errorf("%q: expected error %q, got none", tt.input, expectedErr.Message)
+			} else if err.Error() != expectedErr.Message {
+				t.Errorf("%q: wrong error. want: %q, got: %q", tt.input, expectedErr.Message, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%q: vm error: %s", tt.input, err)
+		}
+
+		testExpectedObject(t, tt.input, tt.expected, vm.LastPoppedStackElem())
+	}
+}
+
+func testExpectedObject(t *testing.T, input string, expected interface{}, actual object.Object) {
+	t.Helper()
+
+	switch expected := expected.(type) {
+	case int:
+		result, ok := actual.(*object.Integer)
+		if !ok {
+			t.Errorf("%q: object is not Integer. got: %T (%+v)", input, actual, actual)
+			return
+		}
+		if result.Value != int64(expected) {
+			t.Errorf("%q: object has wrong value. want: %d, got: %d", input, expected, result.Value)
+		}
+
+	case float64:
+		result, ok := actual.(*object.Float)
+		if !ok {
+			t.Errorf("%q: object is not Float. got: %T (%+v)", input, actual, actual)
+			return
+		}
+		if result.Value != expected {
+			t.Errorf("%q: object has wrong value. want: %g, got: %g", input, expected, result.Value)
+		}
+
+	case bool:
+		result, ok := actual.(*object.Boolean)
+		if !ok {
+			t.Errorf("%q: object is not Boolean. got: %T (%+v)", input, actual, actual)
+			return
+		}
+		if result.Value != expected {
+			t.Errorf("%q: object has wrong value. want: %t, got: %t", input, expected, result.Value)
+		}
+
+	case string:
+		result, ok := actual.(*object.String)
+		if !ok {
+			t.Errorf("%q: object is not String. got: %T (%+v)", input, actual, actual)
+			return
+		}
+		if result.Value != expected {
+			t.Errorf("%q: object has wrong value. want: %q, got: %q", input, expected, result.Value)
+		}
+
+	case *object.Null:
+		if actual != Null {
+			t.Errorf("%q: object is not Null. got: %T (%+v)", input, actual, actual)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		input    string
+		ctx      context.Context
+		limits   object.Limits
+		cause    error
+		expected string
+	}{
+		{"while (true) {}", context.Background(), object.Limits{MaxSteps: 1000}, object.ErrStepLimit, "step limit exceeded: 1000 steps"},
+		{"let f = fn(n) { f(n + 1) }; f(0)", context.Background(), object.Limits{MaxDepth: 50}, object.ErrDepthLimit, "maximum call depth exceeded: 50 calls"},
+		{`let s = ""; while (true) { s += "abcdefgh" }`, context.Background(), object.Limits{MaxAllocation: 1 << 16}, object.ErrAllocationLimit, "allocation limit exceeded: 65536 bytes"},
+		{"while (true) { [1, 2, 3] }", context.Background(), object.Limits{MaxAllocation: 1 << 16}, object.ErrAllocationLimit, "allocation limit exceeded: 65536 bytes"},
+		{`let s = "abcdefgh"; while (true) { s = s + s }`, context.Background(), object.Limits{MaxAllocation: 1 << 16}, object.ErrAllocationLimit, "allocation limit exceeded: 65536 bytes"},
+		{`repeat("a", 1000000)`, context.Background(), object.Limits{MaxAllocation: 1 << 16}, object.ErrAllocationLimit, "allocation limit exceeded: 65536 bytes"},
+		{`pad_left("a", 1000000)`, context.Background(), object.Limits{MaxAllocation: 1 << 16}, object.ErrAllocationLimit, "allocation limit exceeded: 65536 bytes"},
+		{"len(zip(range(10000000)))", context.Background(), object.Limits{MaxSteps: 1000, MaxAllocation: 1 << 16}, object.ErrAllocationLimit, "allocation limit exceeded: 65536 bytes"},
+		{"len(map(range(1000000000), float))", context.Background(), object.Limits{MaxSteps: 1000}, object.ErrStepLimit, "step limit exceeded: 1000 steps"},
+		{"reduce(range(1000000000), fn(a, x) { a + x })", context.Background(), object.Limits{MaxSteps: 1000}, object.ErrStepLimit, "step limit exceeded: 1000 steps"},
+		{`let s = repeat("a", 1000); replace(s, "a", s)`, context.Background(), object.Limits{MaxAllocation: 1 << 16}, object.ErrAllocationLimit, "allocation limit exceeded: 65536 bytes"},
+		{`join([1, 2, 3], repeat("-", 40000))`, context.Background(), object.Limits{MaxAllocation: 1 << 16}, object.ErrAllocationLimit, "allocation limit exceeded: 65536 bytes"},
+		{"let a = [1, 2, 3, 4, 5, 6, 7, 8]; let b = concat(a, a, a, a, a, a, a, a); let c = concat(b, b, b, b, b, b, b, b); concat(c, c, c, c, c, c, c, c)", context.Background(), object.Limits{MaxAllocation: 1 << 16}, object.ErrAllocationLimit, "allocation limit exceeded: 65536 bytes"},
+		{"while (true) {}", canceled, object.Limits{}, context.Canceled, "evaluation stopped: context canceled"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		vm.SetState(object.NewState(tt.ctx, tt.limits))
+
+		err := vm.Run()
+		if err == nil {
+			t.Fatalf("%q: expected error, got none", tt.input)
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("%q: wrong error. want: %q, got: %q", tt.input, tt.expected, err)
+		}
+		if !errors.Is(err, tt.cause) {
+			t.Errorf("%q: wrong cause. want: %v, got: %v", tt.input, tt.cause, err)
+		}
+	}
+}